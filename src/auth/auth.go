@@ -0,0 +1,774 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aiden-deloryn/hoist/src/identity"
+	"github.com/aiden-deloryn/hoist/src/types"
+	"github.com/aiden-deloryn/hoist/src/values"
+	"golang.org/x/crypto/argon2"
+)
+
+// The handshake works as follows:
+//
+//  1. The client sends a hello of HELLO_LENGTH bytes, made up of
+//     PROTOCOL_MAGIC, the protocol version, a random nonce of NONCE_LENGTH
+//     bytes and zero padding.
+//  2. The server replies with PROTOCOL_MAGIC, its protocol version, a random
+//     salt of SALT_LENGTH bytes and its identity. If it won't serve the
+//     client at all, e.g. because the client's address is locked out, it
+//     replies with statusRefused, a one byte length and the reason instead.
+//  3. The client checks the server's identity and sends its own, followed
+//     by a one byte length and the name of the share it wants, which is
+//     empty for the server's unnamed share.
+//  4. The server replies with a status byte. If the server has no such
+//     share, the status is statusUnknownShare. If the client is a trusted
+//     peer the status is statusAccepted and the handshake is complete. If
+//     the server only accepts trusted peers it is statusRejected.
+//     Otherwise it is statusPasswordRequired, and both sides stretch the
+//     password with Argon2id and the salt. The client sends an HMAC of the
+//     salt keyed with the result, which the server checks against its own,
+//     and the server replies with a single byte, 1 if the password was
+//     correct and 0 if not. If the server won't check the password at the
+//     moment, it replies with statusRefused and the reason as in step 2.
+//  5. The client sends a single byte followed by the length of a JSON
+//     encoded types.Request and the request itself. A client which knows
+//     several of the server's addresses connects to them first, and only
+//     starts the handshake on the first connection to succeed, so this is
+//     the only connection it authenticates on.
+//  6. The server replies with approvalGranted, or with approvalPending
+//     while its user decides whether to send to the client, followed by
+//     approvalGranted or approvalDeclined. If the server can't grant the
+//     request, e.g. because the path asked for doesn't exist, it replies
+//     with approvalRefused, a one byte length and the reason.
+//  7. Unless the client only wants a listing, the server sends the manifest
+//     and the client replies with a single byte, 1 to accept it and 0 to
+//     decline. Once accepted, the server replies with approvalGranted and
+//     the transfer starts, or with approvalRefused and the reason, e.g.
+//     because another client has used the last download meanwhile.
+//
+// An identity is a one byte name length, the name, a one byte user name
+// length, the user name, an Ed25519 public key and a signature of the nonce,
+// salt, name and user name, so that identities can't be replayed from
+// another session.
+//
+// Hoist 1.x clients sent the password padded to 32 bytes and 1.x servers
+// replied with a single 0 or 1 byte. The hello is the same length so that
+// older servers reject it straight away instead of waiting for more data,
+// and the magic can't be mistaken for a status byte, so each side can tell
+// when it is talking to an older peer.
+
+const (
+	HELLO_LENGTH = 32
+	NONCE_LENGTH = 16
+	SALT_LENGTH  = 16
+	PROOF_LENGTH = sha256.Size
+)
+
+// Status bytes sent by the server once it has checked the client's identity
+const (
+	statusRejected         byte = 0
+	statusAccepted         byte = 1
+	statusPasswordRequired byte = 2
+	statusUnknownShare     byte = 3
+	statusRefused          byte = 4
+)
+
+// Bytes sent by the server in reply to a transfer request
+const (
+	approvalDeclined byte = 0
+	approvalGranted  byte = 1
+	approvalPending  byte = 2
+	approvalRefused  byte = 3
+)
+
+// Argon2id parameters, as recommended by RFC 9106 for memory constrained
+// environments.
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+	keyLength    = 32
+)
+
+var ErrPasswordIncorrect = errors.New("Password is incorrect")
+
+// ErrNotTrusted is returned when the server only accepts trusted peers and
+// the client is not one of them.
+var ErrNotTrusted = errors.New("only trusted peers are accepted")
+
+// UnknownShareError is returned when the client asks for a share the server
+// doesn't have.
+type UnknownShareError struct {
+	Share string
+}
+
+func (this *UnknownShareError) Error() string {
+	if this.Share == "" {
+		return "the sender only has named shares, add the name to the address, e.g. host:port/NAME"
+	}
+
+	return fmt.Sprintf("the sender has no share called %q", this.Share)
+}
+
+// RejectedError is returned by Authenticate when the server refuses the
+// connection, e.g. because the client's address has made too many failed
+// password attempts.
+type RejectedError struct {
+	Reason string
+}
+
+func (this *RejectedError) Error() string {
+	return fmt.Sprintf("the sender refused the connection: %s", this.Reason)
+}
+
+// ErrDeclined is returned by RequestTransfer when the server's user declines
+// to send to the client.
+var ErrDeclined = errors.New("the sender declined the transfer")
+
+// RefusedError is returned by RequestTransfer when the server can't grant
+// the request.
+type RefusedError struct {
+	Reason string
+}
+
+func (this *RefusedError) Error() string {
+	return fmt.Sprintf("the sender refused the request: %s", this.Reason)
+}
+
+// IncompatibleError is returned when the other side of the connection speaks
+// a different version of the protocol.
+type IncompatibleError struct {
+	message string
+}
+
+func (this *IncompatibleError) Error() string {
+	return this.message
+}
+
+// ClientOptions configures the client side of the handshake.
+type ClientOptions struct {
+	Identity *identity.Identity
+	// VerifyServer is called with the server's identity once its signature
+	// has been checked. Returning an error aborts the handshake.
+	VerifyServer func(peer identity.Peer) error
+	// Password is called if the server asks for a password
+	Password func() (string, error)
+	// Share is the name of the share to ask for, or empty for the server's
+	// unnamed share
+	Share string
+}
+
+// ShareOptions configures how clients authenticate for one of the server's
+// shares.
+type ShareOptions struct {
+	// IsTrusted reports whether a client may skip the password
+	IsTrusted func(peer identity.Peer) bool
+	// TrustedOnly rejects clients which aren't trusted instead of asking
+	// them for a password
+	TrustedOnly bool
+	Password    string
+}
+
+// ServerOptions configures the server side of the handshake.
+type ServerOptions struct {
+	Identity *identity.Identity
+	// Share is called with the name of the share the client asked for. It
+	// returns the options to authenticate the client with, or nil if there
+	// is no such share.
+	Share func(name string) *ShareOptions
+	// Waiting is called whenever the server starts waiting for the user on
+	// the other end, e.g. to accept the server's identity or type a
+	// password, so that timeouts can be restarted
+	Waiting func()
+	// DeriveKey, if not nil, is used instead of the package's DeriveKey to
+	// check the client's password, e.g. to limit how many keys each client
+	// may have derived at once. If it fails, the connection is abandoned
+	// and its error is returned.
+	DeriveKey func(password string, salt []byte) ([]byte, error)
+}
+
+// derivations limits how many keys are derived at once, because each
+// derivation needs argonMemory KiB of memory.
+var derivations = make(chan struct{}, 4)
+
+// DeriveKey stretches password with Argon2id. The password is used exactly
+// as given, it is never padded or truncated.
+func DeriveKey(password string, salt []byte) []byte {
+	derivations <- struct{}{}
+	defer func() { <-derivations }()
+
+	return argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, keyLength)
+}
+
+// proof returns the value sent by the client to show it knows the password.
+func proof(key []byte, salt []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("hoist client proof"))
+	mac.Write(salt)
+
+	return mac.Sum(nil)
+}
+
+func hello() []byte {
+	return append([]byte(values.PROTOCOL_MAGIC), values.PROTOCOL_VERSION)
+}
+
+// transcript returns the data signed by a peer to prove its identity.
+func transcript(label string, nonce []byte, salt []byte, name string, user string) []byte {
+	var data bytes.Buffer
+	data.WriteString(label)
+	data.WriteByte(0)
+	data.Write(nonce)
+	data.Write(salt)
+	data.WriteString(name)
+	data.WriteByte(0)
+	data.WriteString(user)
+
+	return data.Bytes()
+}
+
+const (
+	serverLabel = "hoist server identity"
+	clientLabel = "hoist client identity"
+)
+
+// Authenticate performs the client side of the handshake and returns the
+// server's identity.
+func Authenticate(conn io.ReadWriter, options ClientOptions) (*identity.Peer, error) {
+	nonce := make([]byte, NONCE_LENGTH)
+
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("Failed to generate nonce: %s", err)
+	}
+
+	clientHello := make([]byte, HELLO_LENGTH)
+	copy(clientHello, append(hello(), nonce...))
+
+	if _, err := conn.Write(clientHello); err != nil {
+		return nil, fmt.Errorf("Failed to send data to server: %s", err)
+	}
+
+	serverHello := make([]byte, len(hello()))
+
+	// Older servers reply with a single status byte and close the connection
+	if _, err := io.ReadFull(conn, serverHello[:1]); err != nil {
+		return nil, fmt.Errorf("Failed to get response from server: %s", err)
+	}
+
+	if serverHello[0] == 0 || serverHello[0] == 1 {
+		return nil, &IncompatibleError{"the sender is running an older version of hoist, both computers must use the same version"}
+	}
+
+	if serverHello[0] == statusRefused {
+		return nil, readRejection(conn)
+	}
+
+	if _, err := io.ReadFull(conn, serverHello[1:]); err != nil {
+		return nil, fmt.Errorf("Failed to get response from server: %s", err)
+	}
+
+	if err := checkHello(serverHello, "sender"); err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, SALT_LENGTH)
+
+	if _, err := io.ReadFull(conn, salt); err != nil {
+		return nil, fmt.Errorf("Failed to get response from server: %s", err)
+	}
+
+	server, err := readIdentity(conn, func(name string, user string) []byte {
+		return transcript(serverLabel, nonce, salt, name, user)
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to verify the sender's identity: %s", err)
+	}
+
+	if err := options.VerifyServer(*server); err != nil {
+		return nil, err
+	}
+
+	if err := writeIdentity(conn, options.Identity, transcript(clientLabel, nonce, salt, options.Identity.Name, options.Identity.User)); err != nil {
+		return nil, fmt.Errorf("Failed to send data to server: %s", err)
+	}
+
+	if len(options.Share) > 255 {
+		return nil, fmt.Errorf("the share name is too long")
+	}
+
+	if _, err := conn.Write(append([]byte{byte(len(options.Share))}, options.Share...)); err != nil {
+		return nil, fmt.Errorf("Failed to send data to server: %s", err)
+	}
+
+	status := make([]byte, 1)
+
+	if _, err := io.ReadFull(conn, status); err != nil {
+		return nil, fmt.Errorf("Failed to get response from server: %s", err)
+	}
+
+	switch status[0] {
+	case statusAccepted:
+		return server, nil
+	case statusRejected:
+		return nil, ErrNotTrusted
+	case statusUnknownShare:
+		return nil, &UnknownShareError{options.Share}
+	case statusPasswordRequired:
+	default:
+		return nil, fmt.Errorf("the sender replied with an unknown status %d", status[0])
+	}
+
+	password, err := options.Password()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Write(proof(DeriveKey(password, salt), salt)); err != nil {
+		return nil, fmt.Errorf("Failed to send data to server: %s", err)
+	}
+
+	// Read the result from the server (result is boolean 0 or 1)
+	if _, err := io.ReadFull(conn, status); err != nil {
+		return nil, fmt.Errorf("Failed to get response from server: %s", err)
+	}
+
+	if status[0] == statusRefused {
+		return nil, readRejection(conn)
+	}
+
+	if status[0] != 1 {
+		return nil, ErrPasswordIncorrect
+	}
+
+	return server, nil
+}
+
+// VerifyClient performs the server side of the handshake and returns the
+// client's identity, and whether it is trusted. It returns an
+// *IncompatibleError if the client uses a different version of the protocol,
+// an *UnknownShareError if it asked for a share the server doesn't have,
+// ErrNotTrusted if the client was rejected for not being trusted, or
+// ErrPasswordIncorrect if the client used the wrong password.
+func VerifyClient(conn io.ReadWriter, options ServerOptions) (client *identity.Peer, trusted bool, err error) {
+	clientHello := make([]byte, HELLO_LENGTH)
+
+	if _, err := io.ReadFull(conn, clientHello); err != nil {
+		return nil, false, fmt.Errorf("Failed to read hello from the client: %s", err)
+	}
+
+	if !bytes.HasPrefix(clientHello, []byte(values.PROTOCOL_MAGIC)) {
+		// Older clients understand this as an incorrect password
+		conn.Write([]byte{0})
+		return nil, false, &IncompatibleError{"the client is running an older version of hoist, both computers must use the same version"}
+	}
+
+	salt := make([]byte, SALT_LENGTH)
+
+	if _, err := rand.Read(salt); err != nil {
+		return nil, false, fmt.Errorf("Failed to generate salt: %s", err)
+	}
+
+	// Send our hello even if the versions don't match, so that the client
+	// can explain the problem
+	if _, err := conn.Write(append(hello(), salt...)); err != nil {
+		return nil, false, fmt.Errorf("Failed to send data to the client: %s", err)
+	}
+
+	if err := checkHello(clientHello[:len(hello())], "client"); err != nil {
+		return nil, false, err
+	}
+
+	nonce := clientHello[len(hello()) : len(hello())+NONCE_LENGTH]
+
+	if err := writeIdentity(conn, options.Identity, transcript(serverLabel, nonce, salt, options.Identity.Name, options.Identity.User)); err != nil {
+		return nil, false, fmt.Errorf("Failed to send data to the client: %s", err)
+	}
+
+	// The user may be asked whether to trust our identity
+	options.Waiting()
+
+	client, err = readIdentity(conn, func(name string, user string) []byte {
+		return transcript(clientLabel, nonce, salt, name, user)
+	})
+
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to verify the client's identity: %s", err)
+	}
+
+	shareName, err := readShortString(conn)
+
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to read the share name from the client: %s", err)
+	}
+
+	share := options.Share(shareName)
+
+	if share == nil {
+		conn.Write([]byte{statusUnknownShare})
+		return client, false, &UnknownShareError{shareName}
+	}
+
+	if share.IsTrusted(*client) {
+		if _, err := conn.Write([]byte{statusAccepted}); err != nil {
+			return nil, false, fmt.Errorf("Failed to send data to the client: %s", err)
+		}
+
+		return client, true, nil
+	}
+
+	if share.TrustedOnly {
+		conn.Write([]byte{statusRejected})
+		return client, false, ErrNotTrusted
+	}
+
+	if _, err := conn.Write([]byte{statusPasswordRequired}); err != nil {
+		return nil, false, fmt.Errorf("Failed to send data to the client: %s", err)
+	}
+
+	// The user may be asked for the password
+	options.Waiting()
+
+	guess := make([]byte, PROOF_LENGTH)
+
+	if _, err := io.ReadFull(conn, guess); err != nil {
+		return nil, false, fmt.Errorf("Failed to read password from the client: %s", err)
+	}
+
+	var key []byte
+
+	if options.DeriveKey != nil {
+		key, err = options.DeriveKey(share.Password, salt)
+
+		if err != nil {
+			writeRejection(conn, err.Error())
+			return client, false, err
+		}
+	} else {
+		key = DeriveKey(share.Password, salt)
+	}
+
+	// hmac.Equal takes the same time however much of the proof was correct
+	if !hmac.Equal(guess, proof(key, salt)) {
+		// Notify the client that password verification failed
+		conn.Write([]byte{0})
+		return client, false, ErrPasswordIncorrect
+	}
+
+	// Notify the client that password verification succeeded
+	if _, err := conn.Write([]byte{1}); err != nil {
+		return nil, false, fmt.Errorf("Failed to notify client of password verification result: %s", err)
+	}
+
+	return client, false, nil
+}
+
+// RequestTransfer sends request to the server once the client has
+// authenticated, and waits until the server approves it. waiting is called
+// if the server's user has to approve the request first. It returns
+// ErrDeclined if the request is declined.
+func RequestTransfer(conn io.ReadWriter, request *types.Request, waiting func()) error {
+	requestJSON, err := marshalRequest(request)
+
+	if err != nil {
+		return err
+	}
+
+	message := &bytes.Buffer{}
+	message.WriteByte(1)
+	binary.Write(message, binary.LittleEndian, int64(len(requestJSON)))
+	message.Write(requestJSON)
+
+	if _, err := conn.Write(message.Bytes()); err != nil {
+		return fmt.Errorf("Failed to send data to server: %s", err)
+	}
+
+	return readApproval(conn, waiting)
+}
+
+// CheckRequest returns an error if request is too long to send, e.g.
+// because it selects too many files.
+func CheckRequest(request *types.Request) error {
+	_, err := marshalRequest(request)
+
+	return err
+}
+
+func marshalRequest(request *types.Request) ([]byte, error) {
+	requestJSON, err := json.Marshal(request)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %s", err)
+	}
+
+	if len(requestJSON) > values.MAX_REQUEST_LENGTH {
+		return nil, fmt.Errorf("the request is too long, select whole directories rather than the files in them")
+	}
+
+	return requestJSON, nil
+}
+
+// AwaitStart waits for the server to start the transfer, once the client
+// has accepted the manifest. It returns a *RefusedError if the server
+// can't send it after all.
+func AwaitStart(conn io.Reader) error {
+	return readApproval(conn, func() {})
+}
+
+// readApproval reads the server's reply to a request, calling waiting
+// whenever the server asks the client to wait.
+func readApproval(conn io.Reader, waiting func()) error {
+	approval := make([]byte, 1)
+
+	for {
+		if _, err := io.ReadFull(conn, approval); err != nil {
+			return fmt.Errorf("Failed to get response from server: %s", err)
+		}
+
+		switch approval[0] {
+		case approvalGranted:
+			return nil
+		case approvalDeclined:
+			return ErrDeclined
+		case approvalPending:
+			waiting()
+		case approvalRefused:
+			reason, err := readReason(conn)
+
+			if err != nil {
+				return fmt.Errorf("Failed to get response from server: %s", err)
+			}
+
+			return &RefusedError{reason}
+		default:
+			return fmt.Errorf("the sender replied with an unknown approval %d", approval[0])
+		}
+	}
+}
+
+// AwaitRequest waits for an authenticated client to send its request.
+func AwaitRequest(conn io.Reader) (*types.Request, error) {
+	marker := make([]byte, 1)
+
+	if _, err := io.ReadFull(conn, marker); err != nil {
+		return nil, fmt.Errorf("Failed to read request from the client: %s", err)
+	}
+
+	if marker[0] != 1 {
+		return nil, fmt.Errorf("the client sent an unknown request %d", marker[0])
+	}
+
+	var length int64
+
+	if err := binary.Read(conn, binary.LittleEndian, &length); err != nil {
+		return nil, fmt.Errorf("Failed to read request from the client: %s", err)
+	}
+
+	if length < 0 || length > values.MAX_REQUEST_LENGTH {
+		return nil, fmt.Errorf("the client sent an invalid request length %d", length)
+	}
+
+	requestJSON := make([]byte, length)
+
+	if _, err := io.ReadFull(conn, requestJSON); err != nil {
+		return nil, fmt.Errorf("Failed to read request from the client: %s", err)
+	}
+
+	request := &types.Request{}
+
+	if err := json.Unmarshal(requestJSON, request); err != nil {
+		return nil, fmt.Errorf("the client sent an invalid request: %s", err)
+	}
+
+	return request, nil
+}
+
+// Refuse tells the client that its request can't be granted, and why.
+func Refuse(conn io.Writer, reason string) error {
+	if len(reason) > 255 {
+		reason = reason[:255]
+	}
+
+	message := append([]byte{approvalRefused, byte(len(reason))}, reason...)
+
+	if _, err := conn.Write(message); err != nil {
+		return fmt.Errorf("Failed to send data to the client: %s", err)
+	}
+
+	return nil
+}
+
+// Reject refuses a connection before the handshake, telling the client why.
+// It reads the client's hello first, so that closing the connection
+// afterwards doesn't discard the reason before the client has read it.
+func Reject(conn io.ReadWriter, reason string) error {
+	if _, err := io.ReadFull(conn, make([]byte, HELLO_LENGTH)); err != nil {
+		return fmt.Errorf("Failed to read hello from the client: %s", err)
+	}
+
+	return writeRejection(conn, reason)
+}
+
+// writeRejection sends statusRefused and reason.
+func writeRejection(conn io.Writer, reason string) error {
+	if len(reason) > 255 {
+		reason = reason[:255]
+	}
+
+	if _, err := conn.Write(append([]byte{statusRefused, byte(len(reason))}, reason...)); err != nil {
+		return fmt.Errorf("Failed to send data to the client: %s", err)
+	}
+
+	return nil
+}
+
+// readRejection reads the reason sent with statusRefused.
+func readRejection(conn io.Reader) error {
+	reason, err := readReason(conn)
+
+	if err != nil {
+		return fmt.Errorf("Failed to get response from server: %s", err)
+	}
+
+	return &RejectedError{reason}
+}
+
+// readReason reads a reason sent by the server, which is displayed to the
+// user, so control characters are replaced.
+func readReason(conn io.Reader) (string, error) {
+	reason, err := readShortString(conn)
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return '?'
+		}
+
+		return r
+	}, reason), nil
+}
+
+// Approve tells the client whether the transfer may start. If approve is not
+// nil, the client is told to wait while it is called, and the transfer is
+// declined if it returns false. It returns whether the transfer was
+// approved.
+func Approve(conn io.Writer, approve func() bool) (bool, error) {
+	if approve != nil {
+		if _, err := conn.Write([]byte{approvalPending}); err != nil {
+			return false, fmt.Errorf("Failed to send data to the client: %s", err)
+		}
+
+		if !approve() {
+			conn.Write([]byte{approvalDeclined})
+			return false, nil
+		}
+	}
+
+	if _, err := conn.Write([]byte{approvalGranted}); err != nil {
+		return false, fmt.Errorf("Failed to send data to the client: %s", err)
+	}
+
+	return true, nil
+}
+
+// checkHello makes sure the hello received from peer matches our own.
+func checkHello(received []byte, peer string) error {
+	if !bytes.HasPrefix(received, []byte(values.PROTOCOL_MAGIC)) {
+		return fmt.Errorf("the %s is not running hoist", peer)
+	}
+
+	version := received[len(values.PROTOCOL_MAGIC)]
+
+	if version != values.PROTOCOL_VERSION {
+		return &IncompatibleError{fmt.Sprintf("the %s uses protocol version %d but this computer uses version %d, both computers must use the same version of hoist", peer, version, values.PROTOCOL_VERSION)}
+	}
+
+	return nil
+}
+
+// writeIdentity sends our name, user name and public key, along with a
+// signature of signed.
+func writeIdentity(w io.Writer, self *identity.Identity, signed []byte) error {
+	var data bytes.Buffer
+	data.WriteByte(byte(len(self.Name)))
+	data.WriteString(self.Name)
+	data.WriteByte(byte(len(self.User)))
+	data.WriteString(self.User)
+	data.Write(self.PublicKey())
+	data.Write(ed25519.Sign(self.PrivateKey, signed))
+
+	_, err := w.Write(data.Bytes())
+
+	return err
+}
+
+// readIdentity receives a peer's identity and checks its signature of the
+// data returned by signed.
+func readIdentity(r io.Reader, signed func(name string, user string) []byte) (*identity.Peer, error) {
+	name, err := readShortString(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := readShortString(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, ed25519.PublicKeySize+ed25519.SignatureSize)
+
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	publicKey := ed25519.PublicKey(data[:ed25519.PublicKeySize])
+	signature := data[ed25519.PublicKeySize:]
+
+	if err := identity.ValidateName(name); err != nil {
+		return nil, err
+	}
+
+	if err := identity.ValidateUser(user); err != nil {
+		return nil, err
+	}
+
+	if !ed25519.Verify(publicKey, signed(name, user), signature) {
+		return nil, errors.New("the identity signature is invalid")
+	}
+
+	return &identity.Peer{Name: name, User: user, PublicKey: publicKey}, nil
+}
+
+// readShortString reads a string preceded by its one byte length.
+func readShortString(r io.Reader) (string, error) {
+	length := make([]byte, 1)
+
+	if _, err := io.ReadFull(r, length); err != nil {
+		return "", err
+	}
+
+	data := make([]byte, length[0])
+
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
@@ -0,0 +1,308 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/aiden-deloryn/hoist/src/identity"
+	"github.com/aiden-deloryn/hoist/src/types"
+	"github.com/aiden-deloryn/hoist/src/values"
+)
+
+// fuzzConn reads from the fuzzer's input and discards everything written.
+type fuzzConn struct {
+	io.Reader
+	io.Writer
+}
+
+func newFuzzConn(data []byte) *fuzzConn {
+	return &fuzzConn{Reader: bytes.NewReader(data), Writer: io.Discard}
+}
+
+func FuzzVerifyClient(f *testing.F) {
+	server, err := identity.Generate("server")
+
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	client, err := identity.Generate("client")
+
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	clientHello := make([]byte, HELLO_LENGTH)
+	copy(clientHello, hello())
+
+	// A well formed client identity, although its signature can't match
+	// the random salt chosen by the server
+	var clientIdentity bytes.Buffer
+	writeIdentity(&clientIdentity, client, []byte("not the transcript"))
+
+	f.Add(clientHello)
+	f.Add(append(append([]byte{}, clientHello...), clientIdentity.Bytes()...))
+	f.Add(append(append(append([]byte{}, clientHello...), clientIdentity.Bytes()...), "\x04docs"...))
+	f.Add(make([]byte, HELLO_LENGTH))
+	f.Add(append(append([]byte{}, clientHello...), 0xff))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		peer, _, err := VerifyClient(newFuzzConn(data), ServerOptions{
+			Identity: server,
+			Share: func(name string) *ShareOptions {
+				return &ShareOptions{
+					IsTrusted: func(peer identity.Peer) bool { return false },
+					Password:  "password",
+				}
+			},
+			Waiting: func() {},
+		})
+
+		if err == nil {
+			t.Fatalf("authenticated %+v without a valid signature", peer)
+		}
+	})
+}
+
+func FuzzAuthenticate(f *testing.F) {
+	server, err := identity.Generate("server")
+
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	client, err := identity.Generate("client")
+
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	var serverHello bytes.Buffer
+	serverHello.Write(hello())
+	serverHello.Write(make([]byte, SALT_LENGTH))
+	writeIdentity(&serverHello, server, []byte("not the transcript"))
+
+	f.Add(serverHello.Bytes())
+	f.Add(append(append([]byte{}, serverHello.Bytes()...), statusAccepted))
+	f.Add([]byte{0})
+	f.Add(hello())
+	f.Add([]byte{statusRefused, 3, 'a', 'b', 'c'})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		peer, err := Authenticate(newFuzzConn(data), ClientOptions{
+			Identity:     client,
+			VerifyServer: func(peer identity.Peer) error { return nil },
+			Password:     func() (string, error) { return "password", nil },
+		})
+
+		if err == nil {
+			t.Fatalf("accepted server %+v without a valid signature", peer)
+		}
+	})
+}
+
+func FuzzRequestTransfer(f *testing.F) {
+	f.Add([]byte{approvalGranted})
+	f.Add([]byte{approvalPending, approvalPending, approvalDeclined})
+	f.Add([]byte("\x03\x10no such file\x1b[2J"))
+	f.Add([]byte{7})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		RequestTransfer(newFuzzConn(data), &types.Request{Only: []string{"*.pdf"}}, func() {})
+	})
+}
+
+func FuzzAwaitRequest(f *testing.F) {
+	f.Add([]byte("\x01\x0d\x00\x00\x00\x00\x00\x00\x00{\"list\":true}"))
+	f.Add([]byte("\x01\x1e\x00\x00\x00\x00\x00\x00\x00{\"only\":[\"*.pdf\"],\"path\":\"..\"}"))
+	f.Add([]byte("\x01\x04\x00\x00\x00\x00\x00\x00\x00null"))
+	f.Add([]byte("\x01\x02\x00\x00\x00\x00\x00\x00\x00[]"))
+	f.Add([]byte("\x01\xff\xff\xff\xff\xff\xff\xff\x7f"))
+	f.Add([]byte("\x01\xff\xff\xff\xff\xff\xff\xff\xff"))
+	f.Add([]byte{2})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := bytes.NewReader(data)
+		request, err := AwaitRequest(&fuzzConn{Reader: r, Writer: io.Discard})
+
+		if err != nil {
+			return
+		}
+
+		if request == nil {
+			t.Fatal("returned no request and no error")
+		}
+
+		// The marker, the length and the JSON
+		if read := int64(len(data)) - int64(r.Len()); read > 1+8+values.MAX_REQUEST_LENGTH {
+			t.Fatalf("read %d bytes, more than the longest request", read)
+		}
+	})
+}
+
+func TestRejectedConnections(t *testing.T) {
+	server, err := identity.Generate("server")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := identity.Generate("client")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description string
+		reject      func(conn net.Conn)
+	}{
+		{"before the handshake", func(conn net.Conn) {
+			Reject(conn, "locked out\x1b")
+		}},
+		{"instead of checking the password", func(conn net.Conn) {
+			VerifyClient(conn, ServerOptions{
+				Identity: server,
+				Share: func(name string) *ShareOptions {
+					return &ShareOptions{IsTrusted: func(peer identity.Peer) bool { return false }, Password: "password"}
+				},
+				Waiting: func() {},
+				DeriveKey: func(password string, salt []byte) ([]byte, error) {
+					return nil, errors.New("locked out\x1b")
+				},
+			})
+		}},
+	}
+
+	for _, test := range tests {
+		serverConn, clientConn := net.Pipe()
+
+		go func() {
+			defer serverConn.Close()
+			test.reject(serverConn)
+		}()
+
+		_, err := Authenticate(clientConn, ClientOptions{
+			Identity:     client,
+			VerifyServer: func(peer identity.Peer) error { return nil },
+			Password:     func() (string, error) { return "password", nil },
+		})
+		clientConn.Close()
+
+		var rejected *RejectedError
+
+		if !errors.As(err, &rejected) || rejected.Reason != "locked out?" {
+			t.Errorf("%s: got %v, want the reason the connection was rejected", test.description, err)
+		}
+	}
+}
+
+// handshake authenticates a client using clientPassword with a server using
+// serverPassword over a pipe, and returns the errors from both sides.
+func handshake(t *testing.T, serverPassword string, clientPassword string) (clientErr error, serverErr error) {
+	server, err := identity.Generate("server")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := identity.Generate("client")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	done := make(chan error, 1)
+
+	go func() {
+		defer serverConn.Close()
+
+		_, _, err := VerifyClient(serverConn, ServerOptions{
+			Identity: server,
+			Share: func(name string) *ShareOptions {
+				return &ShareOptions{IsTrusted: func(peer identity.Peer) bool { return false }, Password: serverPassword}
+			},
+			Waiting: func() {},
+		})
+		done <- err
+	}()
+
+	_, clientErr = Authenticate(clientConn, ClientOptions{
+		Identity:     client,
+		VerifyServer: func(peer identity.Peer) error { return nil },
+		Password:     func() (string, error) { return clientPassword, nil },
+	})
+
+	return clientErr, <-done
+}
+
+func TestPasswords(t *testing.T) {
+	long := strings.Repeat("correct horse battery staple ", 10)
+
+	tests := []struct {
+		description    string
+		serverPassword string
+		clientPassword string
+		accepted       bool
+	}{
+		{"same password", "abc", "abc", true},
+		{"wrong password", "abc", "abd", false},
+		{"padded password", "abc", "abc000", false},
+		{"truncated password", long, long[:32], false},
+		{"longer than 32 bytes", long, long, true},
+		{"unicode", "pässwörd 🔑", "pässwörd 🔑", true},
+		{"empty password", "", "", true},
+	}
+
+	for _, test := range tests {
+		clientErr, serverErr := handshake(t, test.serverPassword, test.clientPassword)
+
+		if test.accepted && (clientErr != nil || serverErr != nil) {
+			t.Errorf("%s: got (%v, %v), want the password to be accepted", test.description, clientErr, serverErr)
+		} else if !test.accepted && (clientErr != ErrPasswordIncorrect || serverErr != ErrPasswordIncorrect) {
+			t.Errorf("%s: got (%v, %v), want the password to be incorrect", test.description, clientErr, serverErr)
+		}
+	}
+}
+
+func TestOlderPeersAreRefused(t *testing.T) {
+	server, err := identity.Generate("server")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := identity.Generate("client")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hoist 1.x clients send the password padded to 32 bytes
+	var reply bytes.Buffer
+	_, _, err = VerifyClient(&fuzzConn{Reader: strings.NewReader(strings.Repeat("0", HELLO_LENGTH)), Writer: &reply}, ServerOptions{Identity: server})
+
+	if _, ok := err.(*IncompatibleError); !ok || !bytes.Equal(reply.Bytes(), []byte{0}) {
+		t.Errorf("an old client got %v and the reply %v, want it to be refused as a wrong password", err, reply.Bytes())
+	}
+
+	oldHello := append([]byte(values.PROTOCOL_MAGIC), values.PROTOCOL_VERSION-1)
+
+	for _, serverReply := range [][]byte{{0}, {1}, append(oldHello, make([]byte, SALT_LENGTH)...)} {
+		_, err := Authenticate(newFuzzConn(serverReply), ClientOptions{
+			Identity:     client,
+			VerifyServer: func(peer identity.Peer) error { return nil },
+			Password:     func() (string, error) { return "password", nil },
+		})
+
+		if _, ok := err.(*IncompatibleError); !ok {
+			t.Errorf("the reply %q got %v, want the server to be incompatible", serverReply, err)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package browser
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aiden-deloryn/hoist/src/progress"
+	"github.com/aiden-deloryn/hoist/src/types"
+	"github.com/aiden-deloryn/hoist/src/util"
+	"golang.org/x/term"
+)
+
+// ANSI escape sequences used to draw the browser
+const (
+	enterAlternateScreen = "\x1b[?1049h\x1b[?25l"
+	leaveAlternateScreen = "\x1b[?25h\x1b[?1049l"
+	moveHome             = "\x1b[H"
+	clearLine            = "\x1b[K"
+	clearBelow           = "\x1b[J"
+	bold                 = "\x1b[1m"
+	dim                  = "\x1b[2m"
+	reverse              = "\x1b[7m"
+	reset                = "\x1b[0m"
+)
+
+// resizeCheckInterval is how often the browser checks whether the terminal
+// has been resized.
+const resizeCheckInterval = 250 * time.Millisecond
+
+const helpText = "↑/↓ move  →/enter open  ←/backspace back  space mark  a mark all  d download  q quit"
+
+// Browser is a full screen terminal browser for the share described by a
+// manifest, which lets the user choose what to download.
+type Browser struct {
+	in    *os.File
+	out   *os.File
+	title string
+	root  *node
+	// current is the directory being displayed, and cursor is the index of
+	// the highlighted entry in it
+	current *node
+	cursor  int
+	// offset is the index of the first entry on screen
+	offset  int
+	message string
+	check   func(patterns []string) error
+}
+
+// New returns a Browser for manifest which reads keys from in and draws on
+// out. Both must be terminals. title is displayed at the top of the screen.
+// check, if not nil, is called with the patterns before the browser closes
+// to download them, and if it returns an error, the error is shown and the
+// browser stays open.
+func New(in *os.File, out *os.File, title string, manifest *types.Manifest, check func(patterns []string) error) *Browser {
+	root := buildTree(manifest)
+	current := root
+
+	// When a directory is shared, start inside it
+	if len(root.children) == 1 && root.children[0].directory {
+		current = root.children[0]
+	}
+
+	return &Browser{
+		in:      in,
+		out:     out,
+		title:   title,
+		root:    root,
+		current: current,
+		check:   check,
+	}
+}
+
+// Run shows the browser until the user quits or chooses to download. It
+// returns patterns which select the marked files and directories, as used
+// by share.Selection, or nil if the user quit.
+func (this *Browser) Run() ([]string, error) {
+	state, err := term.MakeRaw(int(this.in.Fd()))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up the terminal: %s", err)
+	}
+
+	defer term.Restore(int(this.in.Fd()), state)
+
+	fmt.Fprint(this.out, enterAlternateScreen)
+	defer fmt.Fprint(this.out, leaveAlternateScreen)
+
+	keys := make(chan string)
+	readErrors := make(chan error, 1)
+	closed := make(chan struct{})
+	defer close(closed)
+
+	// A read from the terminal can't be interrupted, so the reader is left
+	// waiting for one more key once the browser closes
+	go func() {
+		buffer := make([]byte, 64)
+
+		for {
+			n, err := this.in.Read(buffer)
+
+			if err != nil {
+				readErrors <- err
+				return
+			}
+
+			for _, key := range parseKeys(buffer[:n]) {
+				select {
+				case keys <- key:
+				case <-closed:
+					return
+				}
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(resizeCheckInterval)
+	defer ticker.Stop()
+
+	width, height := this.size()
+	this.draw(width, height)
+
+	for {
+		select {
+		case key := <-keys:
+			done, download := this.handleKey(key)
+
+			if done && !download {
+				return nil, nil
+			}
+
+			if done {
+				return patterns(this.root), nil
+			}
+		case err := <-readErrors:
+			return nil, fmt.Errorf("failed to read from the terminal: %s", err)
+		case <-ticker.C:
+			if newWidth, newHeight := this.size(); newWidth == width && newHeight == height {
+				continue
+			}
+		}
+
+		width, height = this.size()
+		this.draw(width, height)
+	}
+}
+
+// size returns the size of the terminal.
+func (this *Browser) size() (width int, height int) {
+	width, height, err := term.GetSize(int(this.out.Fd()))
+
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+
+	return width, height
+}
+
+// listHeight returns how many entries fit on a screen of the given height,
+// leaving room for the title, status and help lines.
+func listHeight(height int) int {
+	if height < 5 {
+		return 1
+	}
+
+	return height - 4
+}
+
+// handleKey updates the browser after key was pressed. done is true if the
+// browser should close, and download is true if the user chose to download
+// the marked items.
+func (this *Browser) handleKey(key string) (done bool, download bool) {
+	this.message = ""
+	_, height := this.size()
+	children := this.current.children
+
+	switch key {
+	case "q", "esc", "ctrl-c":
+		return true, false
+	case "up", "k":
+		this.cursor--
+	case "down", "j":
+		this.cursor++
+	case "pgup":
+		this.cursor -= listHeight(height)
+	case "pgdn":
+		this.cursor += listHeight(height)
+	case "home":
+		this.cursor = 0
+	case "end":
+		this.cursor = len(children) - 1
+	case "right", "l", "enter":
+		if this.cursor < len(children) && children[this.cursor].directory {
+			this.current = children[this.cursor]
+			this.cursor = 0
+			this.offset = 0
+		}
+	case "left", "h", "backspace":
+		if this.current != this.root {
+			previous := this.current
+			this.current = this.current.parent
+			this.offset = 0
+
+			for i, child := range this.current.children {
+				if child == previous {
+					this.cursor = i
+				}
+			}
+		}
+	case "space":
+		if this.cursor < len(children) {
+			children[this.cursor].toggle()
+			this.cursor++
+		}
+	case "a":
+		allSelected := true
+
+		for _, child := range children {
+			if !child.selected() {
+				allSelected = false
+			}
+		}
+
+		for _, child := range children {
+			if child.selected() == allSelected {
+				child.toggle()
+			}
+		}
+	case "d":
+		if len(this.root.markedNodes()) == 0 {
+			this.message = "Mark something to download with the space bar first"
+			break
+		}
+
+		if this.check != nil {
+			if err := this.check(patterns(this.root)); err != nil {
+				this.message = "Can't download the marked items: " + err.Error()
+				break
+			}
+		}
+
+		return true, true
+	}
+
+	children = this.current.children
+
+	if this.cursor >= len(children) {
+		this.cursor = len(children) - 1
+	}
+
+	if this.cursor < 0 {
+		this.cursor = 0
+	}
+
+	return false, false
+}
+
+// draw redraws the whole screen.
+func (this *Browser) draw(width int, height int) {
+	var screen strings.Builder
+	rows := listHeight(height)
+	children := this.current.children
+
+	// Keep the cursor on screen
+	if this.cursor < this.offset {
+		this.offset = this.cursor
+	}
+
+	if this.cursor >= this.offset+rows {
+		this.offset = this.cursor - rows + 1
+	}
+
+	location := "/"
+
+	if this.current != this.root {
+		location = "/" + this.current.name + "/"
+	}
+
+	screen.WriteString(moveHome)
+	screen.WriteString(bold + truncate(this.title+"  "+location, width) + reset + clearLine + "\r\n")
+	screen.WriteString(clearLine + "\r\n")
+
+	for row := 0; row < rows; row++ {
+		i := this.offset + row
+
+		if i < len(children) {
+			line := truncate(describe(children[i]), width)
+
+			if i == this.cursor {
+				line = reverse + line + reset
+			}
+
+			screen.WriteString(line)
+		} else if i == 0 {
+			screen.WriteString(dim + "(empty)" + reset)
+		}
+
+		screen.WriteString(clearLine + "\r\n")
+	}
+
+	status := this.message
+
+	if status == "" {
+		files, size := this.root.selection()
+		status = "Selected: " + progress.DescribeFiles(files, size)
+	}
+
+	screen.WriteString(bold + truncate(status, width) + reset + clearLine + "\r\n")
+	screen.WriteString(dim + truncate(helpText, width) + reset + clearLine + clearBelow)
+
+	fmt.Fprint(this.out, screen.String())
+}
+
+// describe returns the line displayed for an entry.
+func describe(entry *node) string {
+	mark := "[ ]"
+
+	if entry.selected() {
+		mark = "[x]"
+	} else if entry.partlySelected() {
+		mark = "[-]"
+	}
+
+	size := "-"
+	name := entry.baseName()
+
+	switch {
+	case entry.directory:
+		size = util.FormatByteSize(entry.size)
+		name += "/"
+	case entry.target != "":
+		name += " -> " + entry.target
+	default:
+		size = util.FormatByteSize(entry.size)
+	}
+
+	modTime := strings.Repeat(" ", 16)
+
+	if !entry.modTime.IsZero() {
+		modTime = entry.modTime.Local().Format("2006-01-02 15:04")
+	}
+
+	return fmt.Sprintf(" %s %10s  %s  %s", mark, size, modTime, name)
+}
+
+// truncate shortens s to at most width characters.
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+
+	if width <= 1 {
+		return string([]rune(s)[:width])
+	}
+
+	return string([]rune(s)[:width-1]) + "…"
+}
+
+// parseKeys converts the bytes read from a terminal in raw mode into the
+// names of the keys which were pressed.
+func parseKeys(input []byte) []string {
+	var keys []string
+
+	for i := 0; i < len(input); i++ {
+		switch b := input[i]; {
+		case b == 0x1b && i+1 < len(input) && (input[i+1] == '[' || input[i+1] == 'O'):
+			// Escape sequences end with a byte between '@' and '~'
+			end := i + 2
+
+			for end < len(input) && (input[end] < '@' || input[end] > '~') {
+				end++
+			}
+
+			if end == len(input) {
+				return keys
+			}
+
+			keys = append(keys, escapeSequences[string(input[i+2:end+1])])
+			i = end
+		case b == 0x1b:
+			keys = append(keys, "esc")
+		case b == 0x03:
+			keys = append(keys, "ctrl-c")
+		case b == '\r' || b == '\n':
+			keys = append(keys, "enter")
+		case b == 0x7f || b == 0x08:
+			keys = append(keys, "backspace")
+		case b == ' ':
+			keys = append(keys, "space")
+		default:
+			keys = append(keys, string(rune(b)))
+		}
+	}
+
+	return keys
+}
+
+// escapeSequences maps the end of the escape sequences sent by common
+// terminals to the names of their keys.
+var escapeSequences = map[string]string{
+	"A":  "up",
+	"B":  "down",
+	"C":  "right",
+	"D":  "left",
+	"H":  "home",
+	"F":  "end",
+	"1~": "home",
+	"4~": "end",
+	"7~": "home",
+	"8~": "end",
+	"5~": "pgup",
+	"6~": "pgdn",
+}
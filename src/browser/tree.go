@@ -0,0 +1,232 @@
+package browser
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aiden-deloryn/hoist/src/share"
+	"github.com/aiden-deloryn/hoist/src/types"
+)
+
+// node is a directory, file or symlink in the share.
+type node struct {
+	// name is the full name, using '/' as the path separator
+	name      string
+	directory bool
+	target    string
+	modTime   time.Time
+	// size and files are totals for directories
+	size     int64
+	files    int64
+	parent   *node
+	children []*node
+	// marked is true if the user marked this node. Everything inside a
+	// marked directory is selected without being marked itself.
+	marked bool
+}
+
+// baseName returns the last component of the node's name.
+func (this *node) baseName() string {
+	return path.Base(this.name)
+}
+
+// selected reports whether the node, or a directory containing it, is
+// marked.
+func (this *node) selected() bool {
+	for current := this; current != nil; current = current.parent {
+		if current.marked {
+			return true
+		}
+	}
+
+	return false
+}
+
+// partlySelected reports whether something inside the node is marked.
+func (this *node) partlySelected() bool {
+	for _, child := range this.children {
+		if child.marked || child.partlySelected() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// toggle selects the node if it isn't selected, and deselects it if it is.
+// Deselecting a node inside a marked directory marks everything else in
+// that directory instead.
+func (this *node) toggle() {
+	if !this.selected() {
+		this.unmarkChildren()
+		this.marked = true
+		this.collapse()
+		return
+	}
+
+	marked := this
+
+	for !marked.marked {
+		marked = marked.parent
+	}
+
+	marked.marked = false
+
+	for current := this; current != marked; current = current.parent {
+		for _, sibling := range current.parent.children {
+			sibling.marked = sibling != current
+		}
+	}
+}
+
+// collapse marks the directories containing the node in place of their
+// children once every child is marked, so that a fully marked directory is
+// selected with a single pattern. The root can't be marked.
+func (this *node) collapse() {
+	for parent := this.parent; parent != nil && parent.parent != nil; parent = parent.parent {
+		for _, child := range parent.children {
+			if !child.marked {
+				return
+			}
+		}
+
+		parent.unmarkChildren()
+		parent.marked = true
+	}
+}
+
+// unmarkChildren unmarks everything inside the node.
+func (this *node) unmarkChildren() {
+	for _, child := range this.children {
+		child.marked = false
+		child.unmarkChildren()
+	}
+}
+
+// markedNodes returns the marked nodes, in the order they are displayed.
+func (this *node) markedNodes() []*node {
+	var marked []*node
+
+	for _, child := range this.children {
+		if child.marked {
+			marked = append(marked, child)
+		} else {
+			marked = append(marked, child.markedNodes()...)
+		}
+	}
+
+	return marked
+}
+
+// selection returns the number and total size of the selected files inside
+// the node.
+func (this *node) selection() (files int64, size int64) {
+	for _, child := range this.children {
+		switch {
+		case child.marked:
+			files += child.files
+			size += child.size
+		case child.directory:
+			childFiles, childSize := child.selection()
+			files += childFiles
+			size += childSize
+		}
+	}
+
+	return files, size
+}
+
+// buildTree arranges the entries in manifest into a tree, under a root node
+// which doesn't appear in the share. Directories are listed before files,
+// and each in order of name.
+func buildTree(manifest *types.Manifest) *node {
+	root := &node{directory: true}
+	nodes := map[string]*node{"": root}
+
+	// find returns the node called name, creating it and its parents as
+	// directories if needed
+	var find func(name string) *node
+
+	find = func(name string) *node {
+		if existing, ok := nodes[name]; ok {
+			return existing
+		}
+
+		parentName := path.Dir(name)
+
+		if parentName == "." {
+			parentName = ""
+		}
+
+		parent := find(parentName)
+		created := &node{name: name, directory: true, parent: parent}
+		parent.children = append(parent.children, created)
+		nodes[name] = created
+
+		return created
+	}
+
+	for _, directory := range manifest.Directories {
+		find(directory.Name).modTime = directory.ModTime
+	}
+
+	for _, file := range manifest.Files {
+		entry := find(file.Name)
+		entry.directory = false
+		entry.modTime = file.ModTime
+		entry.size = file.Size
+		entry.files = 1
+
+		for parent := entry.parent; parent != nil; parent = parent.parent {
+			parent.size += file.Size
+			parent.files++
+		}
+	}
+
+	for _, symlink := range manifest.Symlinks {
+		entry := find(symlink.Name)
+		entry.directory = false
+		entry.modTime = symlink.ModTime
+		entry.target = symlink.Target
+	}
+
+	sortTree(root)
+
+	return root
+}
+
+func sortTree(parent *node) {
+	sort.Slice(parent.children, func(i, j int) bool {
+		a, b := parent.children[i], parent.children[j]
+
+		if a.directory != b.directory {
+			return a.directory
+		}
+
+		return strings.ToLower(a.name) < strings.ToLower(b.name)
+	})
+
+	for _, child := range parent.children {
+		sortTree(child)
+	}
+}
+
+// patterns returns the patterns which select the marked nodes under root,
+// one for each file and "dir/**" for each directory.
+func patterns(root *node) []string {
+	var result []string
+
+	for _, marked := range root.markedNodes() {
+		pattern := "/" + share.EscapePattern(marked.name)
+
+		if marked.directory {
+			pattern += "/**"
+		}
+
+		result = append(result, pattern)
+	}
+
+	return result
+}
@@ -0,0 +1,97 @@
+package browser
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aiden-deloryn/hoist/src/types"
+)
+
+var testManifest = &types.Manifest{
+	Files: []types.ManifestEntry{
+		{Name: "share/photos/a.jpg", Size: 1},
+		{Name: "share/photos/b.jpg", Size: 2},
+		{Name: "share/photos/2024/c.jpg", Size: 3},
+		{Name: "share/notes.txt", Size: 4},
+	},
+	Directories: []types.ManifestEntry{{Name: "share"}, {Name: "share/photos"}, {Name: "share/photos/2024"}},
+}
+
+// find returns the node called name under root.
+func find(root *node, name string) *node {
+	for _, child := range root.children {
+		if child.name == name {
+			return child
+		}
+
+		if found := find(child, name); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
+
+func TestPatterns(t *testing.T) {
+	tests := []struct {
+		description string
+		toggled     []string
+		patterns    []string
+	}{
+		{"one file", []string{"share/photos/a.jpg"}, []string{"/share/photos/a.jpg"}},
+		{"a directory", []string{"share/photos"}, []string{"/share/photos/**"}},
+		{
+			"every file in a directory",
+			[]string{"share/photos/2024/c.jpg", "share/photos/a.jpg", "share/photos/b.jpg"},
+			[]string{"/share/photos/**"},
+		},
+		{
+			"every file and directory in the share",
+			[]string{"share/photos/2024", "share/photos/a.jpg", "share/photos/b.jpg", "share/notes.txt"},
+			[]string{"/share/**"},
+		},
+		{
+			"a file taken out of a marked directory",
+			[]string{"share/photos", "share/photos/b.jpg"},
+			[]string{"/share/photos/2024/**", "/share/photos/a.jpg"},
+		},
+	}
+
+	for _, test := range tests {
+		root := buildTree(testManifest)
+
+		for _, name := range test.toggled {
+			find(root, name).toggle()
+		}
+
+		if got := patterns(root); !reflect.DeepEqual(got, test.patterns) {
+			t.Errorf("%s: got %q, want %q", test.description, got, test.patterns)
+		}
+	}
+}
+
+func TestMarkAllCollapses(t *testing.T) {
+	browser := New(nil, nil, "", testManifest, nil)
+	browser.handleKey("right")
+	browser.handleKey("a")
+
+	if got := patterns(browser.root); !reflect.DeepEqual(got, []string{"/share/photos/**"}) {
+		t.Errorf("got %q, want the directory as a single pattern", got)
+	}
+}
+
+func TestDownloadChecksPatterns(t *testing.T) {
+	browser := New(nil, nil, "", testManifest, func(patterns []string) error {
+		return errors.New("the request is too long")
+	})
+	browser.handleKey("space")
+
+	if done, _ := browser.handleKey("d"); done {
+		t.Fatal("the browser closed although the patterns were refused")
+	}
+
+	if browser.message == "" {
+		t.Error("the browser didn't say why it couldn't download")
+	}
+}
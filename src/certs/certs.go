@@ -0,0 +1,162 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aiden-deloryn/hoist/src/values"
+)
+
+// FingerprintMismatchError is returned when the server's certificate doesn't
+// match the fingerprint the client expected.
+type FingerprintMismatchError struct {
+	Expected string
+	Actual   string
+}
+
+func (this *FingerprintMismatchError) Error() string {
+	return fmt.Sprintf("the sender's certificate fingerprint is %s but %s was expected, someone may be impersonating the sender", this.Actual, this.Expected)
+}
+
+// GenerateCertificate creates a self-signed certificate with a new key, for
+// use by a single share. Clients verify it by its fingerprint, so the
+// subject and validity period only need to be plausible.
+func GenerateCertificate() (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %s", err)
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: values.APP_NAME},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %s", err)
+	}
+
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// LoadCertificate reads a PEM encoded certificate and private key.
+func LoadCertificate(certFile string, keyFile string) (*tls.Certificate, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %s", err)
+	}
+
+	return &certificate, nil
+}
+
+// LoadCertPool reads one or more PEM encoded certificates into a pool.
+func LoadCertPool(filename string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(filename)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificates: %s", err)
+	}
+
+	pool := x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", filename)
+	}
+
+	return pool, nil
+}
+
+// Fingerprint returns the hex encoded SHA-256 hash of a DER encoded
+// certificate.
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseFingerprint normalises a fingerprint typed by the user, accepting an
+// optional "sha256:" prefix, colons between bytes and either case.
+func ParseFingerprint(fingerprint string) (string, error) {
+	fingerprint = strings.ToLower(strings.TrimSpace(fingerprint))
+	fingerprint = strings.TrimPrefix(fingerprint, "sha256:")
+	fingerprint = strings.ReplaceAll(fingerprint, ":", "")
+
+	if decoded, err := hex.DecodeString(fingerprint); err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("invalid fingerprint %q, expected %d hex encoded bytes", fingerprint, sha256.Size)
+	}
+
+	return fingerprint, nil
+}
+
+// ServerConfig returns the TLS configuration for a server presenting
+// certificate. If clientCAs is not nil, clients must present a certificate
+// signed by one of them.
+func ServerConfig(certificate *tls.Certificate, clientCAs *x509.CertPool) *tls.Config {
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		Certificates: []tls.Certificate{*certificate},
+	}
+
+	if clientCAs != nil {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = clientCAs
+	}
+
+	return config
+}
+
+// ClientConfig returns the TLS configuration for a client. The server's
+// certificate is accepted only if its fingerprint matches, or accepted
+// without verification if fingerprint is empty. certificate, if not nil, is
+// presented to servers which ask for a client certificate.
+func ClientConfig(fingerprint string, certificate *tls.Certificate) *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS13,
+		// Shares use self-signed certificates, so they are checked against
+		// the fingerprint below instead of a certificate authority
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("the sender did not present a certificate")
+			}
+
+			if actual := Fingerprint(rawCerts[0]); fingerprint != "" && actual != fingerprint {
+				return &FingerprintMismatchError{Expected: fingerprint, Actual: actual}
+			}
+
+			return nil
+		},
+	}
+
+	if certificate != nil {
+		config.Certificates = []tls.Certificate{*certificate}
+	}
+
+	return config
+}
@@ -2,9 +2,10 @@ package client
 
 import (
 	"bufio"
-	"bytes"
-	"encoding/binary"
-	"encoding/json"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -12,168 +13,560 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/aiden-deloryn/hoist/src/auth"
+	"github.com/aiden-deloryn/hoist/src/certs"
+	"github.com/aiden-deloryn/hoist/src/events"
+	"github.com/aiden-deloryn/hoist/src/identity"
+	"github.com/aiden-deloryn/hoist/src/share"
 	"github.com/aiden-deloryn/hoist/src/types"
 	"github.com/aiden-deloryn/hoist/src/util"
 	"github.com/aiden-deloryn/hoist/src/values"
 )
 
+// Options configures a Client.
+type Options struct {
+	// Password is sent to the server to authenticate. It may be empty.
+	Password string
+	// GetPassword, if not nil, is called instead of using Password the
+	// first time a server asks for the password. Servers which trust this
+	// client's identity don't ask for one.
+	GetPassword func() (string, error)
+	// Identity identifies this client to servers. If nil, a new identity is
+	// generated for each client.
+	Identity *identity.Identity
+	// VerifySender, if not nil, is called with the server's identity before
+	// authenticating. Returning an error aborts the download. It is only
+	// called once for each identity, even if several addresses are tried.
+	VerifySender func(peer identity.Peer) error
+	// OutputDirectory is where downloaded files are written. If empty, the
+	// current working directory is used.
+	OutputDirectory string
+	// MaxSize, MaxFiles, MaxFileSize, MaxDepth, AllowExtensions and
+	// BlockExtensions limit what the client will accept. Shares which break
+	// a limit according to their manifest are declined before any data is
+	// sent, and if the files sent don't match the manifest, the download is
+	// aborted before the first file which breaks a limit is written. In
+	// both cases Get returns a *LimitError.
+	//
+	// MaxSize limits the total size of the share in bytes. 0 means no
+	// limit.
+	MaxSize int64
+	// MaxFiles limits how many files the share may contain. 0 means no
+	// limit.
+	MaxFiles int64
+	// MaxFileSize limits the size of each file in bytes. 0 means no limit.
+	MaxFileSize int64
+	// MaxDepth limits how many levels deep files may be, so that 2 allows
+	// "dir/file" but not "dir/sub/file". 0 means no limit.
+	MaxDepth int
+	// AllowExtensions, if not empty, only accepts files ending in one of
+	// these extensions, e.g. ".jpg" or ".tar.gz". Case is ignored.
+	AllowExtensions []string
+	// BlockExtensions refuses files ending in any of these extensions.
+	BlockExtensions []string
+	// Only, if not empty, asks the server to send only the files matching
+	// these patterns (see share.Selection)
+	Only []string
+	// Confirm, if not nil, is called with the manifest before any files are
+	// downloaded. Returning an error declines the transfer, and Get returns
+	// the error.
+	Confirm func(manifest *types.Manifest) error
+	// Preallocate reserves disk space for each file before it is downloaded
+	Preallocate bool
+	// HandshakeTimeout limits how long connecting and authenticating may
+	// take. 0 means no limit.
+	HandshakeTimeout time.Duration
+	// IdleTimeout aborts the download when no data has been received for
+	// this long. 0 means no limit.
+	IdleTimeout time.Duration
+	// Timeout limits how long the whole download may take. 0 means no
+	// limit.
+	Timeout time.Duration
+	// TLS connects to the server using TLS 1.3. It is enabled automatically
+	// when a fingerprint is given.
+	TLS bool
+	// Fingerprint is the expected SHA-256 fingerprint of the server's TLS
+	// certificate. Share descriptors may also include a fingerprint. TLS
+	// without a fingerprint is refused unless Insecure is set.
+	Fingerprint string
+	// Insecure allows TLS without a fingerprint, in which case the server's
+	// certificate is not verified at all
+	Insecure bool
+	// TLSCertificate, if not nil, is presented to servers which require a
+	// client certificate
+	TLSCertificate *tls.Certificate
+	// Events receives events as they happen. If nil, events are discarded.
+	Events events.Handler
+}
+
+// Result summarises a completed download.
+type Result struct {
+	// Address is the address the share was downloaded from
+	Address          string
+	FilesReceived    int64
+	BytesReceived    int64
+	SymlinksReceived int64
+	Duration         time.Duration
+	// Manifest lists what the server offered to send
+	Manifest *types.Manifest
+}
+
+// Client downloads shares from hoist servers.
+type Client struct {
+	options Options
+
+	// mutex stops several connection attempts from prompting the user at
+	// once
+	mutex         sync.Mutex
+	verifiedKeys  map[string]error
+	passwordRead  bool
+	password      string
+	passwordError error
+}
+
+func NewClient(options Options) *Client {
+	if options.Events == nil {
+		options.Events = events.Discard
+	}
+
+	return &Client{
+		options:      options,
+		verifiedKeys: map[string]error{},
+	}
+}
+
+// Get downloads the share being served at address, which may be a single
+// address, a comma-separated list of addresses or a share descriptor. When
+// there are several addresses, the first one to connect is used, and the
+// client only authenticates on that connection. When the server shares a whole directory (see hoist serve), the
+// path to download is given after the address, e.g. "host:port:docs". The
+// download is aborted if ctx is cancelled.
+func (this *Client) Get(ctx context.Context, address string) (*Result, error) {
+	return this.run(ctx, address, false)
+}
+
+// List returns the manifest of the share being served at address, without
+// downloading anything. The address is given as for Get.
+func (this *Client) List(ctx context.Context, address string) (*types.Manifest, error) {
+	result, err := this.run(ctx, address, true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Manifest, nil
+}
+
+// run downloads or, if list is true, lists the share being served at
+// address.
+func (this *Client) run(ctx context.Context, address string, list bool) (*Result, error) {
+	emit := func(event events.Event) {
+		event.Time = time.Now()
+
+		if event.Address == "" {
+			event.Address = address
+		}
+
+		this.options.Events.HandleEvent(event)
+	}
+
+	transferCtx, cancel := util.WithOptionalTimeout(ctx, this.options.Timeout)
+	defer cancel()
+
+	var result *Result
+	descriptor, err := share.ParseDescriptor(address)
+
+	if err == nil {
+		result, err = this.get(transferCtx, descriptor, list, emit)
+	}
+
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	} else if transferCtx.Err() != nil {
+		err = fmt.Errorf("download did not complete within %s", this.options.Timeout)
+	}
+
+	if err != nil {
+		emit(events.Event{Type: events.Error, Message: err.Error()})
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetFileFromServer downloads the share being served at address into
+// outputDirectory.
+//
+// Deprecated: use NewClient, which supports cancellation and more options.
 func GetFileFromServer(address string, password string, outputDirectory string) error {
-	conn, err := net.Dial("tcp", address)
+	client := NewClient(Options{
+		Password:        password,
+		OutputDirectory: outputDirectory,
+	})
+
+	_, err := client.Get(context.Background(), address)
+
+	return err
+}
+
+func (this *Client) get(ctx context.Context, descriptor *share.Descriptor, list bool, emit func(events.Event)) (*Result, error) {
+	outputDirectory := this.options.OutputDirectory
+	selection, err := share.ParseSelection(this.options.Only)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := this.tlsConfig(descriptor, emit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	connection, err := this.connect(ctx, descriptor, tlsConfig, emit)
 
 	if err != nil {
-		return errors.New(fmt.Sprintf("Failed to connect to the server: %s", err))
+		return nil, err
 	}
 
+	conn := connection.conn
 	defer conn.Close()
 
-	err = authenticate(conn, password)
+	// Abort the transfer if the context is cancelled
+	stop := util.CloseOnCancel(ctx, conn)
+	defer stop()
+
+	// Report the rest of the download against the address which was used
+	emitAll := emit
+	emit = func(event events.Event) {
+		event.Address = connection.address
+		emitAll(event)
+	}
+
+	request := &types.Request{List: list, Only: selection, Path: descriptor.Path}
+
+	err = auth.RequestTransfer(conn, request, func() {
+		emit(events.Event{Type: events.AwaitingApproval})
+
+		// The sender's user may take a while to answer
+		conn.IdleTimeout = 0
+	})
 
 	if err != nil {
-		return fmt.Errorf("Authentication failed: %s", err)
+		return nil, err
+	}
+
+	conn.IdleTimeout = this.options.IdleTimeout
+
+	manifest, err := readManifest(conn, selection)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest from server: %s", err)
+	}
+
+	if list {
+		return &Result{Address: connection.address, Manifest: manifest}, nil
+	}
+
+	if err := this.checkManifest(manifest, selection); err != nil {
+		// Let the server know, rather than leaving it to notice the
+		// connection closing
+		sendManifestAnswer(conn, false)
+		return nil, err
+	}
+
+	if err := sendManifestAnswer(conn, true); err != nil {
+		return nil, fmt.Errorf("failed to accept manifest: %s", err)
 	}
 
+	if err := auth.AwaitStart(conn); err != nil {
+		return nil, err
+	}
+
+	emit(events.Event{
+		Type:       events.TransferStarted,
+		FileCount:  manifest.FileCount,
+		TotalBytes: manifest.TotalSize,
+		Peer:       connection.peer.Name,
+		User:       connection.peer.User,
+		PublicKey:  identity.FormatPublicKey(connection.peer.PublicKey),
+	})
+
+	result := &Result{Address: connection.address, Manifest: manifest}
+	startTime := time.Now()
+
+	// Only warn once if preallocation is not available on this platform
+	preallocateUnsupported := false
+
+	decoder := newDecoder(conn, manifest, selection)
+
 	for {
-		// Receive the filename size from the server
-		var filenameSize int64
-		err = binary.Read(conn, binary.LittleEndian, &filenameSize)
+		entry, err := decoder.next()
 
 		if err != nil {
-			return errors.New(fmt.Sprintf("Failed to read filename size from the server: %s", err))
+			return nil, err
 		}
 
-		// If the filenameSize is -1, there is nothing left to copy
-		if filenameSize == -1 {
+		// There is nothing left to copy
+		if entry == nil {
 			break
 		}
 
-		// If the filenameSize is -2, the next object is a symlink
-		if filenameSize == -2 {
-			err = GetSymlinkFromServer(conn, outputDirectory)
-
-			if err != nil {
-				return fmt.Errorf("failed to get symlink from server: %s", err)
+		if entry.symlink != nil {
+			if err := this.checkFile(entry.name, -1); err != nil {
+				return nil, err
 			}
 
-			continue
-		}
+			if err := createSymlink(entry.symlink, outputDirectory); err != nil {
+				return nil, fmt.Errorf("failed to get symlink from server: %s", err)
+			}
 
-		// Receive the filenameBytes from the server
-		filenameBytes := make([]byte, int(filenameSize))
-		_, err = io.ReadFull(conn, filenameBytes)
+			result.SymlinksReceived++
+			emit(events.Event{Type: events.SymlinkCreated, Path: entry.symlink.Name, Target: entry.symlink.Target})
 
-		if err != nil {
-			return errors.New(fmt.Sprintf("Failed to read filename from the server: %s", err))
+			continue
 		}
 
 		// Convert filename's path separator for the current platform
-		filename := filepath.FromSlash(string(filenameBytes))
+		remoteFilename := entry.name
+		fileSize := entry.size
+		filename := filepath.FromSlash(remoteFilename)
 
 		if outputDirectory != "" {
 			filename = filepath.Clean(outputDirectory + string(filepath.Separator) + filename)
 		}
 
+		// The sender may not stick to its manifest, so check each file
+		// again before creating it
+		if err := this.checkFile(remoteFilename, fileSize); err != nil {
+			return nil, err
+		}
+
+		if err := this.checkTotals(result.FilesReceived+1, result.BytesReceived+fileSize); err != nil {
+			return nil, err
+		}
+
 		// Create parent directories
 		if strings.Count(filename, string(filepath.Separator)) != 0 {
 			os.MkdirAll(filepath.Dir(filename), 0775)
 		}
 
-		// Receive the file size from the server
-		var fileSize int64
-		err = binary.Read(conn, binary.LittleEndian, &fileSize)
+		checksum, err := this.receiveFile(conn, remoteFilename, filename, fileSize, &preallocateUnsupported, emit)
 
 		if err != nil {
-			return errors.New(fmt.Sprintf("Failed to read file size from the server: %s", err))
+			return nil, err
 		}
 
-		file, err := os.Create(filename)
+		result.FilesReceived++
+		result.BytesReceived += fileSize
+		emit(events.Event{
+			Type:   events.FileDone,
+			File:   remoteFilename,
+			Path:   filename,
+			Size:   fileSize,
+			Bytes:  fileSize,
+			SHA256: checksum,
+		})
+	}
+
+	result.Duration = time.Since(startTime)
+	emit(events.Event{
+		Type:       events.Summary,
+		FileCount:  result.FilesReceived,
+		TotalBytes: result.BytesReceived,
+		DurationMs: events.Duration(result.Duration),
+	})
+
+	return result, nil
+}
+
+// receiveFile writes the next fileSize bytes from conn to filename and
+// returns the file's checksum. If the file can't be received in full it is
+// removed, so a failed or cancelled download doesn't leave a truncated file
+// behind.
+func (this *Client) receiveFile(conn net.Conn, remoteFilename string, filename string, fileSize int64, preallocateUnsupported *bool, emit func(events.Event)) (checksum string, err error) {
+	file, err := os.Create(filename)
+
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Failed to create file: %s", err))
+	}
+
+	defer func() {
+		file.Close()
 
 		if err != nil {
-			return errors.New(fmt.Sprintf("Failed to create file: %s", err))
+			os.Remove(filename)
 		}
+	}()
 
-		defer file.Close()
-		err = file.Truncate(fileSize)
+	if this.options.Preallocate && !*preallocateUnsupported {
+		err = util.Preallocate(file, fileSize)
 
-		if err != nil {
-			return errors.New(fmt.Sprintf("Failed to set file size: %s", err))
+		if errors.Is(err, util.ErrUnsupportedPlatform) {
+			emit(events.Event{Type: events.Warning, Message: fmt.Sprintf("preallocation is %s, falling back to sparse files", err)})
+			*preallocateUnsupported = true
+		} else if err != nil {
+			return "", fmt.Errorf("failed to preallocate %s for '%s': %s", util.FormatByteSize(fileSize), filename, err)
 		}
+	}
 
-		// Init vars to measure copy speed
-		copySpeed := int64(0)
-		sampleStartTime := time.Now().UnixMilli() - 1
-		sampleStartBytes := int64(0)
+	if !this.options.Preallocate || *preallocateUnsupported {
+		err = file.Truncate(fileSize)
+	}
 
-		// Convert our bufio.Reader into a util.ProgressReader so we can log the
-		// progress of a copy to the console.
-		progressReader := &util.ProgressReader{
-			Reader: conn,
-			ProgressCallback: func(bytesCopied int64) {
-				copyComplete := bytesCopied == fileSize
-				progress := int(float64(bytesCopied) / float64(fileSize) * 100)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Failed to set file size: %s", err))
+	}
 
-				sampleDuration := time.Now().UnixMilli() - sampleStartTime
-				sampleBytesCopied := bytesCopied - sampleStartBytes
+	emit(events.Event{Type: events.FileStarted, File: remoteFilename, Path: filename, Size: fileSize})
 
-				// Calculate the current copy speed
-				if sampleDuration >= 1000 {
-					// Calculate speed in MiB per second
-					copySpeed = (sampleBytesCopied / 1048576) / (sampleDuration / 1000)
-					sampleStartTime = time.Now().UnixMilli()
-					sampleStartBytes = bytesCopied
-				}
+	// Convert our bufio.Reader into a util.ProgressReader so we can report
+	// the progress of a copy.
+	progressReader := &util.ProgressReader{
+		Reader: conn,
+		ProgressCallback: func(bytesCopied int64) {
+			emit(events.Event{Type: events.Progress, File: remoteFilename, Path: filename, Size: fileSize, Bytes: bytesCopied})
+		},
+	}
 
-				fmt.Printf("\r%s %d/%d bytes (%d MiB/s)", util.GenerateProgressBarString(progress), bytesCopied, fileSize, copySpeed)
+	writer := bufio.NewWriter(file)
+	hash := sha256.New()
 
-				if copyComplete {
-					fmt.Print("\n")
-				}
-			},
-		}
+	// Receive the file from the server
+	_, err = io.CopyN(io.MultiWriter(writer, hash), progressReader, fileSize)
+
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Failed to receive file from the server: %s", err))
+	}
+
+	err = writer.Flush()
+
+	if err != nil {
+		return "", fmt.Errorf("failed to write file: %s", err)
+	}
 
-		writer := bufio.NewWriter(file)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
 
-		fmt.Printf("Copying file %s...\n", filename)
+// identity returns the client's identity, generating one if none was given.
+func (this *Client) identity() (*identity.Identity, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
 
-		// Receive the file from the server
-		_, err = io.CopyN(writer, progressReader, fileSize)
+	if this.options.Identity == nil {
+		generated, err := identity.Generate(values.APP_NAME)
 
 		if err != nil {
-			return errors.New(fmt.Sprintf("Failed to receive file from the server: %s", err))
+			return nil, err
 		}
 
-		file.Close()
+		this.options.Identity = generated
 	}
 
-	return nil
+	return this.options.Identity, nil
 }
 
-func GetSymlinkFromServer(conn net.Conn, outputDirectory string) error {
-	// Receive the symlink metadata size from the server
-	var metadataSize int64
-	err := binary.Read(conn, binary.LittleEndian, &metadataSize)
+// verifySender checks the server's identity with VerifySender, remembering
+// the answer for each key.
+func (this *Client) verifySender(peer identity.Peer) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
 
-	if err != nil {
-		return fmt.Errorf("failed to read symlink metadata size from the server: %s", err)
+	if this.options.VerifySender == nil {
+		return nil
 	}
 
-	// Receive the symlink metadata from the server
-	symlinkMetadataJSON := make([]byte, int(metadataSize))
-	_, err = io.ReadFull(conn, symlinkMetadataJSON)
+	key := string(peer.PublicKey)
 
-	if err != nil {
-		return fmt.Errorf("failed to read symlink metadata from the server: %s", err)
+	if err, ok := this.verifiedKeys[key]; ok {
+		return err
 	}
 
-	metadata := types.SymlinkMetadata{}
+	err := this.options.VerifySender(peer)
+	this.verifiedKeys[key] = err
 
-	err = json.Unmarshal(symlinkMetadataJSON, &metadata)
+	return err
+}
+
+// getPassword returns the password, calling GetPassword at most once.
+func (this *Client) getPassword() (string, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.options.GetPassword == nil {
+		return this.options.Password, nil
+	}
+
+	if !this.passwordRead {
+		this.password, this.passwordError = this.options.GetPassword()
+		this.passwordRead = true
+	}
+
+	return this.password, this.passwordError
+}
+
+// ErrNoFingerprint is returned when TLS is used without a fingerprint to
+// verify the server's certificate, and Insecure isn't set.
+var ErrNoFingerprint = errors.New("the sender's TLS certificate can't be verified without its fingerprint, give it with --fingerprint, or use --insecure to connect without verifying it")
+
+// tlsConfig returns the TLS configuration to use for the share, or nil if
+// TLS should not be used.
+func (this *Client) tlsConfig(descriptor *share.Descriptor, emit func(events.Event)) (*tls.Config, error) {
+	fingerprint := ""
+
+	for _, candidate := range []string{this.options.Fingerprint, descriptor.Fingerprint} {
+		if candidate == "" {
+			continue
+		}
+
+		parsed, err := certs.ParseFingerprint(candidate)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if fingerprint != "" && parsed != fingerprint {
+			return nil, fmt.Errorf("the fingerprint in the share descriptor doesn't match the fingerprint given")
+		}
+
+		fingerprint = parsed
+	}
+
+	if fingerprint == "" && !this.options.TLS {
+		return nil, nil
+	}
+
+	if fingerprint == "" && !this.options.Insecure {
+		return nil, ErrNoFingerprint
+	}
+
+	if fingerprint == "" {
+		emit(events.Event{Type: events.Warning, Message: "no certificate fingerprint was given, so the sender's TLS certificate isn't verified"})
+	}
+
+	return certs.ClientConfig(fingerprint, this.options.TLSCertificate), nil
+}
+
+func GetSymlinkFromServer(conn net.Conn, outputDirectory string) (*types.SymlinkMetadata, error) {
+	metadata, err := readSymlinkMetadata(conn)
 
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal symlink metadata: %s", err)
+		return nil, err
+	}
+
+	if err := createSymlink(metadata, outputDirectory); err != nil {
+		return nil, err
 	}
 
+	return metadata, nil
+}
+
+// createSymlink creates the symlink described by metadata inside
+// outputDirectory, and updates metadata with the path of the symlink.
+func createSymlink(metadata *types.SymlinkMetadata, outputDirectory string) error {
 	// Convert filename's path separator for the current platform
 	metadata.Target = filepath.FromSlash(metadata.Target)
 	metadata.Name = filepath.FromSlash(metadata.Name)
@@ -184,42 +577,83 @@ func GetSymlinkFromServer(conn net.Conn, outputDirectory string) error {
 
 	os.MkdirAll(filepath.Dir(metadata.Name), 0775)
 
-	fmt.Printf("Creating symlink: \n")
-	fmt.Printf("  %s --> %s\n", metadata.Name, metadata.Target)
+	if err := os.Symlink(metadata.Target, metadata.Name); err != nil {
+		return fmt.Errorf("failed to create symlink: %s", err)
+	}
 
-	err = os.Symlink(metadata.Target, metadata.Name)
+	return nil
+}
 
-	if err != nil {
-		return fmt.Errorf("failed to create symlink: %s", err)
+// checkManifest decides whether to download the share described by
+// manifest, which only lists what selection includes.
+func (this *Client) checkManifest(manifest *types.Manifest, selection share.Selection) error {
+	if len(selection) > 0 && len(manifest.Files) == 0 && len(manifest.Symlinks) == 0 {
+		return fmt.Errorf("nothing in the share matches %s", strings.Join(selection, ", "))
+	}
+
+	if err := this.checkTotals(manifest.FileCount, manifest.TotalSize); err != nil {
+		return err
+	}
+
+	for _, file := range manifest.Files {
+		if err := this.checkFile(file.Name, file.Size); err != nil {
+			return err
+		}
+	}
+
+	if err := checkAvailableDiskSpace(manifest, this.options.OutputDirectory); err != nil {
+		return err
+	}
+
+	if this.options.Confirm != nil {
+		return this.options.Confirm(manifest)
 	}
 
 	return nil
 }
 
-func authenticate(conn net.Conn, password string) error {
-	// Pad the password with '0' so that it's length is MAX_PASSWORD_LENGTH
-	for len(password) < values.MAX_PASSWORD_LENGTH {
-		password += "0"
+// sendManifestAnswer tells the server whether to go ahead with the
+// transfer.
+func sendManifestAnswer(conn net.Conn, accepted bool) error {
+	answer := []byte{0}
+
+	if accepted {
+		answer[0] = 1
+	}
+
+	_, err := conn.Write(answer)
+
+	return err
+}
+
+// checkAvailableDiskSpace makes sure the filesystem that will hold
+// outputDirectory has room for every file in the manifest before any data
+// is transferred.
+func checkAvailableDiskSpace(manifest *types.Manifest, outputDirectory string) error {
+	if outputDirectory == "" {
+		outputDirectory = "."
 	}
 
-	// Send the password to the server
-	_, err := io.CopyN(conn, bytes.NewBuffer([]byte(password)), int64(len(password)))
+	// The output directory may not have been created yet
+	path, err := util.GetExistingParentDirectory(outputDirectory)
 
 	if err != nil {
-		return fmt.Errorf("Failed to send data to server: %s", err)
+		return fmt.Errorf("failed to check available disk space: %s", err)
 	}
 
-	// Read the result from the server (result is boolean 0 or 1)
-	result := bytes.NewBuffer([]byte{})
-	_, err = io.CopyN(result, conn, 1)
+	available, err := util.GetAvailableDiskSpace(path)
+
+	if errors.Is(err, util.ErrUnsupportedPlatform) {
+		return nil
+	}
 
 	if err != nil {
-		return fmt.Errorf("Failed to get response from server: %s", err)
+		return fmt.Errorf("failed to check available disk space: %s", err)
 	}
 
-	// If the result was 0 (false) we can assume the password was incorrect
-	if result.Bytes()[0] == 0 {
-		return fmt.Errorf("Password is incorrect")
+	if manifest.TotalSize > available {
+		return fmt.Errorf("not enough free space in '%s': the transfer requires %s but only %s is available",
+			path, util.FormatByteSize(manifest.TotalSize), util.FormatByteSize(available))
 	}
 
 	return nil
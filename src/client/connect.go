@@ -0,0 +1,302 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/aiden-deloryn/hoist/src/auth"
+	"github.com/aiden-deloryn/hoist/src/certs"
+	"github.com/aiden-deloryn/hoist/src/events"
+	"github.com/aiden-deloryn/hoist/src/identity"
+	"github.com/aiden-deloryn/hoist/src/share"
+	"github.com/aiden-deloryn/hoist/src/util"
+)
+
+// connectionAttemptDelay is how long to wait for an address to connect
+// before trying the next one as well, as recommended by RFC 8305.
+const connectionAttemptDelay = 250 * time.Millisecond
+
+// attempt is an authenticated connection to one of the share's addresses.
+type attempt struct {
+	address string
+	conn    *util.TimeoutConn
+	// peer is the server's identity
+	peer *identity.Peer
+}
+
+// dialResult is the outcome of connecting to one address.
+type dialResult struct {
+	address string
+	conn    net.Conn
+	err     error
+}
+
+// connect connects to one of the descriptor's addresses, chosen with
+// dialFirst, and authenticates on that connection only, so that a wrong
+// password counts as a single failed attempt. If the server goes away
+// before the password is asked for, the remaining addresses are tried.
+func (this *Client) connect(ctx context.Context, descriptor *share.Descriptor, tlsConfig *tls.Config, emit func(events.Event)) (*attempt, error) {
+	addresses := descriptor.Addresses
+	var errs []string
+
+	for len(addresses) > 0 {
+		winner, failures, err := this.dialFirst(ctx, addresses, tlsConfig)
+
+		if err != nil {
+			return nil, err
+		}
+
+		failed := map[string]bool{}
+
+		for _, failure := range failures {
+			var mismatch *certs.FingerprintMismatchError
+
+			if errors.As(failure.err, &mismatch) {
+				emit(events.Event{Type: events.Warning, Address: failure.address, Message: failure.err.Error()})
+			}
+
+			failed[failure.address] = true
+			errs = append(errs, fmt.Sprintf("%s: %s", failure.address, failure.err))
+		}
+
+		if winner == nil {
+			break
+		}
+
+		result, passwordSent, err := this.authenticate(ctx, winner.address, winner.conn, descriptor.Share)
+
+		if err == nil {
+			emit(events.Event{Type: events.ClientConnected, Address: result.address})
+			return result, nil
+		}
+
+		// A wrong password, version or share name won't be any different
+		// on another address
+		var incompatible *auth.IncompatibleError
+		var rejected *senderRejectedError
+		var unknownShare *auth.UnknownShareError
+
+		if errors.As(err, &rejected) {
+			return nil, rejected.err
+		}
+
+		if errors.As(err, &unknownShare) {
+			return nil, unknownShare
+		}
+
+		if errors.Is(err, auth.ErrPasswordIncorrect) || errors.Is(err, auth.ErrNotTrusted) || errors.As(err, &incompatible) {
+			emit(events.Event{Type: events.ClientConnected, Address: winner.address})
+			emit(events.Event{Type: events.AuthFailed, Address: winner.address, Message: err.Error()})
+			return nil, fmt.Errorf("Authentication failed: %s", err)
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		errs = append(errs, fmt.Sprintf("%s: %s", winner.address, err))
+
+		// Once the password has been sent, trying again could count as
+		// another failed attempt
+		if passwordSent {
+			break
+		}
+
+		failed[winner.address] = true
+		var remaining []string
+
+		for _, address := range addresses {
+			if !failed[address] {
+				remaining = append(remaining, address)
+			}
+		}
+
+		addresses = remaining
+	}
+
+	if len(errs) == 1 {
+		return nil, fmt.Errorf("Failed to connect to the server: %s", errs[0])
+	}
+
+	return nil, fmt.Errorf("Failed to connect to the server on any address:\n  %s", strings.Join(errs, "\n  "))
+}
+
+// dialFirst connects to each of addresses in turn, Happy Eyeballs style. A
+// new attempt is started whenever the previous one fails, or hasn't
+// connected within connectionAttemptDelay. The first connection to complete
+// is returned and the others are closed, along with the attempts which
+// failed before it. winner is nil if every attempt failed.
+func (this *Client) dialFirst(ctx context.Context, addresses []string, tlsConfig *tls.Config) (winner *dialResult, failures []dialResult, err error) {
+	dialCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, len(addresses))
+	pending := 0
+	started := 0
+
+	startNext := func() {
+		address := addresses[started]
+		started++
+		pending++
+
+		go func() {
+			conn, err := this.dial(dialCtx, address, tlsConfig)
+			results <- dialResult{address: address, conn: conn, err: err}
+		}()
+	}
+
+	// Close the connections of attempts which finish after the winner
+	defer func() {
+		go func(pending int) {
+			for ; pending > 0; pending-- {
+				if result := <-results; result.conn != nil {
+					result.conn.Close()
+				}
+			}
+		}(pending)
+	}()
+
+	ticker := time.NewTicker(connectionAttemptDelay)
+	defer ticker.Stop()
+
+	for pending > 0 || started < len(addresses) {
+		if pending == 0 {
+			startNext()
+			ticker.Reset(connectionAttemptDelay)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-ticker.C:
+			if started < len(addresses) {
+				startNext()
+			}
+		case result := <-results:
+			pending--
+
+			if result.err == nil {
+				return &result, failures, nil
+			}
+
+			failures = append(failures, result)
+
+			if started < len(addresses) {
+				startNext()
+				ticker.Reset(connectionAttemptDelay)
+			}
+		}
+	}
+
+	return nil, failures, nil
+}
+
+// dial connects to address, and completes the TLS handshake if tlsConfig is
+// not nil.
+func (this *Client) dial(ctx context.Context, address string, tlsConfig *tls.Config) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: this.options.HandshakeTimeout}
+	rawConn, err := dialer.DialContext(ctx, "tcp", address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig == nil {
+		return rawConn, nil
+	}
+
+	tlsConn := tls.Client(rawConn, tlsConfig)
+
+	if err := handshakeTLS(ctx, tlsConn, this.options.HandshakeTimeout); err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+// authenticate authenticates on netConn, which is connected to address, and
+// closes it if that fails. passwordSent is true once the user's password has
+// been sent, even if authentication then failed.
+func (this *Client) authenticate(ctx context.Context, address string, netConn net.Conn, shareName string) (result *attempt, passwordSent bool, err error) {
+	self, err := this.identity()
+
+	if err != nil {
+		netConn.Close()
+		return nil, false, err
+	}
+
+	// Abort the handshake if the context is cancelled
+	stop := util.CloseOnCancel(ctx, netConn)
+
+	conn := util.NewTimeoutConn(netConn, this.options.IdleTimeout)
+	conn.StartHandshake(this.options.HandshakeTimeout)
+
+	// The user may be asked to accept the server's identity or type the
+	// password, so restart the handshake timeout afterwards
+	peer, err := auth.Authenticate(conn, auth.ClientOptions{
+		Identity: self,
+		VerifyServer: func(peer identity.Peer) error {
+			defer conn.StartHandshake(this.options.HandshakeTimeout)
+
+			if err := this.verifySender(peer); err != nil {
+				return &senderRejectedError{err}
+			}
+
+			return nil
+		},
+		Password: func() (string, error) {
+			defer conn.StartHandshake(this.options.HandshakeTimeout)
+			passwordSent = true
+			return this.getPassword()
+		},
+		Share: shareName,
+	})
+	stop()
+
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		netConn.Close()
+		return nil, passwordSent, err
+	}
+
+	conn.EndHandshake()
+
+	return &attempt{address: address, conn: conn, peer: peer}, passwordSent, nil
+}
+
+// senderRejectedError is returned when VerifySender rejects the server's
+// identity, which applies to every address.
+type senderRejectedError struct {
+	err error
+}
+
+func (this *senderRejectedError) Error() string {
+	return this.err.Error()
+}
+
+// handshakeTLS completes the TLS handshake within timeout.
+func handshakeTLS(ctx context.Context, conn *tls.Conn, timeout time.Duration) error {
+	handshakeCtx, cancel := util.WithOptionalTimeout(ctx, timeout)
+	defer cancel()
+
+	err := conn.HandshakeContext(handshakeCtx)
+
+	if err != nil && ctx.Err() == nil && handshakeCtx.Err() != nil {
+		return fmt.Errorf("TLS handshake did not complete within %s", timeout)
+	}
+
+	if err != nil {
+		return fmt.Errorf("TLS handshake failed: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestDialFirst(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	// A port which nothing is listening on
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closedAddress := closed.Addr().String()
+	closed.Close()
+
+	client := NewClient(Options{})
+	winner, failures, err := client.dialFirst(context.Background(), []string{closedAddress, listener.Addr().String()}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if winner == nil || winner.address != listener.Addr().String() {
+		t.Fatalf("got winner %+v, want %s", winner, listener.Addr())
+	}
+
+	winner.conn.Close()
+
+	if len(failures) != 1 || failures[0].address != closedAddress {
+		t.Errorf("got failures %+v, want one for %s", failures, closedAddress)
+	}
+
+	winner, failures, err = client.dialFirst(context.Background(), []string{closedAddress}, nil)
+
+	if err != nil || winner != nil || len(failures) != 1 {
+		t.Errorf("got (%+v, %+v, %v), want a single failure", winner, failures, err)
+	}
+}
@@ -0,0 +1,341 @@
+package client
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/aiden-deloryn/hoist/src/share"
+	"github.com/aiden-deloryn/hoist/src/types"
+	"github.com/aiden-deloryn/hoist/src/values"
+)
+
+// ProtocolError is returned when the server sends something the protocol
+// doesn't allow, such as an impossible length or an unsafe file name.
+type ProtocolError struct {
+	message string
+}
+
+func (this *ProtocolError) Error() string {
+	return fmt.Sprintf("the sender broke the protocol: %s", this.message)
+}
+
+func protocolErrorf(format string, args ...interface{}) error {
+	return &ProtocolError{fmt.Sprintf(format, args...)}
+}
+
+// Values sent in place of a filename length
+const (
+	endOfTransfer  = -1
+	symlinkFollows = -2
+)
+
+// readLength reads a length field and makes sure it is between 0 and max.
+func readLength(r io.Reader, field string, max int64) (int64, error) {
+	var length int64
+
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return 0, fmt.Errorf("failed to read %s from the server: %s", field, err)
+	}
+
+	if length < 0 || length > max {
+		return 0, protocolErrorf("invalid %s %d", field, length)
+	}
+
+	return length, nil
+}
+
+// readManifest receives the manifest and makes sure it is consistent and
+// only lists what selection includes.
+func readManifest(r io.Reader, selection share.Selection) (*types.Manifest, error) {
+	manifestSize, err := readLength(r, "manifest size", values.MAX_MANIFEST_LENGTH)
+
+	if err != nil {
+		return nil, err
+	}
+
+	manifestJSON := make([]byte, manifestSize)
+
+	if _, err := io.ReadFull(r, manifestJSON); err != nil {
+		return nil, fmt.Errorf("failed to read manifest from the server: %s", err)
+	}
+
+	manifest := &types.Manifest{}
+
+	if err := json.Unmarshal(manifestJSON, manifest); err != nil {
+		return nil, protocolErrorf("invalid manifest: %s", err)
+	}
+
+	if manifest.FileCount != int64(len(manifest.Files)) {
+		return nil, protocolErrorf("the manifest lists %d files but says it contains %d", len(manifest.Files), manifest.FileCount)
+	}
+
+	var totalSize int64
+
+	for _, file := range manifest.Files {
+		if err := checkSelectedName(file.Name, selection); err != nil {
+			return nil, err
+		}
+
+		if file.Size < 0 || totalSize+file.Size < totalSize {
+			return nil, protocolErrorf("invalid size %d for %q in the manifest", file.Size, file.Name)
+		}
+
+		totalSize += file.Size
+	}
+
+	if manifest.TotalSize != totalSize {
+		return nil, protocolErrorf("the manifest's files add up to %d bytes but it says they are %d bytes", totalSize, manifest.TotalSize)
+	}
+
+	for _, directory := range manifest.Directories {
+		if err := checkRemoteName(directory.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, symlink := range manifest.Symlinks {
+		if err := checkSelectedName(symlink.Name, selection); err != nil {
+			return nil, err
+		}
+
+		if err := checkSymlinkTarget(symlink.Name, symlink.Target); err != nil {
+			return nil, err
+		}
+	}
+
+	return manifest, nil
+}
+
+// readSymlinkMetadata receives the name and target of a symlink, using '/'
+// as the path separator.
+func readSymlinkMetadata(r io.Reader) (*types.SymlinkMetadata, error) {
+	metadataSize, err := readLength(r, "symlink metadata size", values.MAX_SYMLINK_METADATA_LENGTH)
+
+	if err != nil {
+		return nil, err
+	}
+
+	metadataJSON := make([]byte, metadataSize)
+
+	if _, err := io.ReadFull(r, metadataJSON); err != nil {
+		return nil, fmt.Errorf("failed to read symlink metadata from the server: %s", err)
+	}
+
+	metadata := &types.SymlinkMetadata{}
+
+	if err := json.Unmarshal(metadataJSON, metadata); err != nil {
+		return nil, protocolErrorf("invalid symlink metadata: %s", err)
+	}
+
+	if err := checkRemoteName(metadata.Name); err != nil {
+		return nil, err
+	}
+
+	if err := checkSymlinkTarget(metadata.Name, metadata.Target); err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// checkSymlinkTarget makes sure the target of a symlink sent by the server
+// is valid and safe to display.
+func checkSymlinkTarget(name string, target string) error {
+	if target == "" || len(target) > values.MAX_FILENAME_LENGTH {
+		return protocolErrorf("invalid target %q for symlink %q", target, name)
+	}
+
+	for _, r := range target {
+		if r < 0x20 || r == 0x7f {
+			return protocolErrorf("the target of symlink %q contains control characters", name)
+		}
+	}
+
+	return nil
+}
+
+// checkSelectedName checks a file name sent by the server as
+// checkRemoteName does, and makes sure the client asked for it.
+func checkSelectedName(name string, selection share.Selection) error {
+	if err := checkRemoteName(name); err != nil {
+		return err
+	}
+
+	if !selection.Includes(name) {
+		return protocolErrorf("%q doesn't match the files which were asked for", name)
+	}
+
+	return nil
+}
+
+// checkRemoteName makes sure a file name sent by the server, using '/' as
+// the path separator, stays inside the output directory and is safe to
+// display.
+func checkRemoteName(name string) error {
+	if name == "" || len(name) > values.MAX_FILENAME_LENGTH {
+		return protocolErrorf("invalid file name length %d", len(name))
+	}
+
+	for _, component := range strings.Split(name, "/") {
+		if component == "" || component == "." || component == ".." {
+			return protocolErrorf("unsafe file name %q", name)
+		}
+
+		// Backslashes and drive letters are path separators on Windows
+		if filepath.Separator == '\\' && strings.ContainsAny(component, "\\:") {
+			return protocolErrorf("unsafe file name %q", name)
+		}
+	}
+
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return protocolErrorf("file name %q contains control characters", name)
+		}
+	}
+
+	return nil
+}
+
+// entry is a file or symlink sent by the server. For files, size bytes of
+// data follow.
+type entry struct {
+	// name uses '/' as the path separator
+	name    string
+	size    int64
+	symlink *types.SymlinkMetadata
+}
+
+// decoder reads the files and symlinks sent after the manifest, and makes
+// sure they are safe to write and don't add up to more than the manifest
+// said.
+type decoder struct {
+	r              io.Reader
+	selection      share.Selection
+	filesRemaining int64
+	bytesRemaining int64
+	// symlinks are the names of the symlinks created so far, which files
+	// must not be written through, as returned by key
+	symlinks map[string]bool
+	// foldCase is true where file systems usually ignore case, as on
+	// Windows and macOS, so that "LINK/file" is written through "link"
+	foldCase bool
+}
+
+func newDecoder(r io.Reader, manifest *types.Manifest, selection share.Selection) *decoder {
+	return &decoder{
+		r:              r,
+		selection:      selection,
+		filesRemaining: manifest.FileCount,
+		bytesRemaining: manifest.TotalSize,
+		symlinks:       map[string]bool{},
+		foldCase:       runtime.GOOS == "windows" || runtime.GOOS == "darwin",
+	}
+}
+
+// next reads the next entry, or returns nil at the end of the transfer. The
+// data of a file must be read from the underlying reader before next is
+// called again.
+func (this *decoder) next() (*entry, error) {
+	var filenameSize int64
+
+	if err := binary.Read(this.r, binary.LittleEndian, &filenameSize); err != nil {
+		return nil, fmt.Errorf("Failed to read filename size from the server: %s", err)
+	}
+
+	switch {
+	case filenameSize == endOfTransfer:
+		return nil, nil
+	case filenameSize == symlinkFollows:
+		metadata, err := readSymlinkMetadata(this.r)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkSelectedName(metadata.Name, this.selection); err != nil {
+			return nil, err
+		}
+
+		if err := this.checkPath(metadata.Name); err != nil {
+			return nil, err
+		}
+
+		this.symlinks[this.key(metadata.Name)] = true
+
+		return &entry{name: metadata.Name, symlink: metadata}, nil
+	case filenameSize <= 0 || filenameSize > values.MAX_FILENAME_LENGTH:
+		return nil, protocolErrorf("invalid filename size %d", filenameSize)
+	}
+
+	filenameBytes := make([]byte, filenameSize)
+
+	if _, err := io.ReadFull(this.r, filenameBytes); err != nil {
+		return nil, fmt.Errorf("Failed to read filename from the server: %s", err)
+	}
+
+	name := string(filenameBytes)
+
+	if err := checkSelectedName(name, this.selection); err != nil {
+		return nil, err
+	}
+
+	if err := this.checkPath(name); err != nil {
+		return nil, err
+	}
+
+	var fileSize int64
+
+	if err := binary.Read(this.r, binary.LittleEndian, &fileSize); err != nil {
+		return nil, fmt.Errorf("Failed to read file size from the server: %s", err)
+	}
+
+	if fileSize < 0 {
+		return nil, protocolErrorf("invalid size %d for %q", fileSize, name)
+	}
+
+	// The share may have changed since the manifest was built, but it
+	// mustn't get any bigger, because the manifest is what the user agreed
+	// to and what the free disk space was checked against
+	if this.filesRemaining == 0 || fileSize > this.bytesRemaining {
+		return nil, protocolErrorf("%q was not in the manifest, the shared files may have changed", name)
+	}
+
+	this.filesRemaining--
+	this.bytesRemaining -= fileSize
+
+	return &entry{name: name, size: fileSize}, nil
+}
+
+// checkPath makes sure name isn't, or isn't inside, a symlink which has
+// already been created, so that nothing is written outside the output
+// directory.
+func (this *decoder) checkPath(name string) error {
+	key := this.key(name)
+
+	for i := 0; i <= len(key); i++ {
+		if i < len(key) && key[i] != '/' {
+			continue
+		}
+
+		if this.symlinks[key[:i]] {
+			return protocolErrorf("%q would be written through the symlink %q", name, key[:i])
+		}
+	}
+
+	return nil
+}
+
+// key returns the name which name is stored under in symlinks, ignoring
+// case if the file system does.
+func (this *decoder) key(name string) string {
+	if this.foldCase {
+		return strings.ToLower(name)
+	}
+
+	return name
+}
@@ -0,0 +1,215 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/aiden-deloryn/hoist/src/share"
+	"github.com/aiden-deloryn/hoist/src/types"
+)
+
+// encodeManifest returns manifest as the server sends it.
+func encodeManifest(manifest *types.Manifest) []byte {
+	var data bytes.Buffer
+	manifestJSON, _ := json.Marshal(manifest)
+	binary.Write(&data, binary.LittleEndian, int64(len(manifestJSON)))
+	data.Write(manifestJSON)
+
+	return data.Bytes()
+}
+
+// encodeTransfer returns the files in manifest, followed by a symlink, as
+// the server sends them.
+func encodeTransfer(manifest *types.Manifest) []byte {
+	var data bytes.Buffer
+
+	for _, file := range manifest.Files {
+		binary.Write(&data, binary.LittleEndian, int64(len(file.Name)))
+		data.WriteString(file.Name)
+		binary.Write(&data, binary.LittleEndian, file.Size)
+		data.Write(make([]byte, file.Size))
+	}
+
+	metadataJSON, _ := json.Marshal(types.SymlinkMetadata{Name: "photos/latest", Target: "a.jpg"})
+	binary.Write(&data, binary.LittleEndian, int64(symlinkFollows))
+	binary.Write(&data, binary.LittleEndian, int64(len(metadataJSON)))
+	data.Write(metadataJSON)
+	binary.Write(&data, binary.LittleEndian, int64(endOfTransfer))
+
+	return data.Bytes()
+}
+
+var testManifest = &types.Manifest{
+	TotalSize: 7,
+	FileCount: 2,
+	Files: []types.ManifestEntry{
+		{Name: "photos/a.jpg", Size: 4},
+		{Name: "photos/b.jpg", Size: 3},
+	},
+	Directories: []types.ManifestEntry{{Name: "photos"}},
+	Symlinks:    []types.ManifestEntry{{Name: "photos/latest", Target: "a.jpg"}},
+}
+
+var testSelection = share.Selection{"photos/**"}
+
+func FuzzReadManifest(f *testing.F) {
+	f.Add(encodeManifest(testManifest))
+	f.Add(encodeManifest(&types.Manifest{}))
+	f.Add(encodeManifest(&types.Manifest{TotalSize: -1, FileCount: 1, Files: []types.ManifestEntry{{Name: "../x", Size: -1}}}))
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		manifest, err := readManifest(bytes.NewReader(data), testSelection)
+
+		if err != nil {
+			return
+		}
+
+		var totalSize int64
+
+		for _, file := range manifest.Files {
+			if checkRemoteName(file.Name) != nil || !testSelection.Includes(file.Name) || file.Size < 0 {
+				t.Fatalf("accepted invalid file %q of %d bytes", file.Name, file.Size)
+			}
+
+			totalSize += file.Size
+		}
+
+		if totalSize != manifest.TotalSize || int64(len(manifest.Files)) != manifest.FileCount {
+			t.Fatalf("accepted inconsistent manifest %+v", manifest)
+		}
+	})
+}
+
+func FuzzDecoder(f *testing.F) {
+	f.Add(encodeTransfer(testManifest))
+	f.Add(encodeTransfer(&types.Manifest{}))
+	f.Add([]byte{0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f})
+	f.Add([]byte{0xfd, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := bytes.NewReader(data)
+		decoder := newDecoder(r, testManifest, testSelection)
+		var files, bytesReceived int64
+
+		for {
+			entry, err := decoder.next()
+
+			if err != nil || entry == nil {
+				return
+			}
+
+			if checkRemoteName(entry.name) != nil || !testSelection.Includes(entry.name) {
+				t.Fatalf("accepted unsafe name %q", entry.name)
+			}
+
+			if entry.symlink != nil {
+				continue
+			}
+
+			files++
+			bytesReceived += entry.size
+
+			if files > testManifest.FileCount || bytesReceived > testManifest.TotalSize {
+				t.Fatalf("accepted more than the manifest listed")
+			}
+
+			if _, err := io.CopyN(io.Discard, r, entry.size); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func FuzzCheckRemoteName(f *testing.F) {
+	for _, name := range []string{"a.txt", "dir/a.txt", "../a", "/etc/passwd", "a//b", "a/./b", "a\x00b", "a\nb", "C:\\x"} {
+		f.Add(name)
+	}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		if checkRemoteName(name) != nil {
+			return
+		}
+
+		// Joining an accepted name to a directory must stay inside it
+		if !strings.HasPrefix(path.Clean("/out/"+name), "/out/") {
+			t.Fatalf("accepted %q, which escapes the output directory", name)
+		}
+	})
+}
+
+// encodeSymlinkThenFile returns a symlink called symlink, followed by a file
+// called name, as the server sends them.
+func encodeSymlinkThenFile(symlink string, name string) []byte {
+	var data bytes.Buffer
+
+	metadataJSON, _ := json.Marshal(types.SymlinkMetadata{Name: symlink, Target: "/etc"})
+	binary.Write(&data, binary.LittleEndian, int64(symlinkFollows))
+	binary.Write(&data, binary.LittleEndian, int64(len(metadataJSON)))
+	data.Write(metadataJSON)
+
+	binary.Write(&data, binary.LittleEndian, int64(len(name)))
+	data.WriteString(name)
+	binary.Write(&data, binary.LittleEndian, int64(1))
+	data.WriteString("x")
+
+	return data.Bytes()
+}
+
+func TestDecoderRefusesFilesThroughSymlinks(t *testing.T) {
+	manifest := &types.Manifest{TotalSize: 1, FileCount: 1}
+
+	tests := []struct {
+		description string
+		symlink     string
+		name        string
+		// allowed is whether the file is allowed where case matters, and
+		// allowedFolded where it doesn't
+		allowed       bool
+		allowedFolded bool
+	}{
+		{"file beside the symlink", "dir/link", "dir/file", true, true},
+		{"file through the symlink", "dir/link", "dir/link/passwd", false, false},
+		{"file replacing the symlink", "dir/link", "dir/link", false, false},
+		{"file through the symlink in another case", "dir/link", "dir/LINK/passwd", true, false},
+		{"file replacing the symlink in another case", "dir/Link", "dir/lInK", true, false},
+		{"directory differing from the symlink in case", "Docs", "docs/x", true, false},
+		{"file with the symlink's name as a prefix", "dir/link", "dir/linked", true, true},
+	}
+
+	for _, test := range tests {
+		for _, foldCase := range []bool{false, true} {
+			decoder := newDecoder(bytes.NewReader(encodeSymlinkThenFile(test.symlink, test.name)), manifest, nil)
+			decoder.foldCase = foldCase
+
+			if _, err := decoder.next(); err != nil {
+				t.Fatalf("%s: failed to read the symlink: %s", test.description, err)
+			}
+
+			_, err := decoder.next()
+			allowed := test.allowed
+
+			if foldCase {
+				allowed = test.allowedFolded
+			}
+
+			if (err == nil) != allowed {
+				t.Errorf("%s, folding case %t: got %v, want allowed to be %t", test.description, foldCase, err, allowed)
+			}
+		}
+	}
+}
+
+func TestDecoderFoldsCaseOnlyWhereFileSystemsDo(t *testing.T) {
+	decoder := newDecoder(bytes.NewReader(nil), &types.Manifest{}, nil)
+
+	if want := runtime.GOOS == "windows" || runtime.GOOS == "darwin"; decoder.foldCase != want {
+		t.Errorf("case folding is %t on %s, want %t", decoder.foldCase, runtime.GOOS, want)
+	}
+}
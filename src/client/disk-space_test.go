@@ -0,0 +1,31 @@
+package client
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aiden-deloryn/hoist/src/types"
+	"github.com/aiden-deloryn/hoist/src/util"
+)
+
+func TestCheckAvailableDiskSpace(t *testing.T) {
+	if _, err := util.GetAvailableDiskSpace(t.TempDir()); errors.Is(err, util.ErrUnsupportedPlatform) {
+		t.Skip(err)
+	}
+
+	// The output directory doesn't have to exist yet
+	outputDirectory := filepath.Join(t.TempDir(), "not", "created")
+
+	if err := checkAvailableDiskSpace(&types.Manifest{TotalSize: 1}, outputDirectory); err != nil {
+		t.Errorf("a 1 byte share doesn't fit: %s", err)
+	}
+
+	err := checkAvailableDiskSpace(&types.Manifest{TotalSize: math.MaxInt64}, outputDirectory)
+
+	if err == nil || !strings.Contains(err.Error(), "not enough free space") {
+		t.Errorf("got %v, want a share too big for the disk to be refused", err)
+	}
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aiden-deloryn/hoist/src/util"
+)
+
+// LimitError is returned when a share breaks one of the limits set in the
+// client's Options.
+type LimitError struct {
+	// File is the file which broke the limit, or empty if the limit applies
+	// to the whole share
+	File   string
+	Reason string
+}
+
+func (this *LimitError) Error() string {
+	if this.File == "" {
+		return fmt.Sprintf("refusing the share: %s", this.Reason)
+	}
+
+	return fmt.Sprintf("refusing %s: %s", this.File, this.Reason)
+}
+
+// checkTotals returns a *LimitError if a share of fileCount files adding up
+// to totalSize bytes is too big.
+func (this *Client) checkTotals(fileCount int64, totalSize int64) error {
+	if this.options.MaxSize > 0 && totalSize > this.options.MaxSize {
+		return &LimitError{Reason: fmt.Sprintf("it is %s, more than the maximum of %s", util.FormatByteSize(totalSize), util.FormatByteSize(this.options.MaxSize))}
+	}
+
+	if this.options.MaxFiles > 0 && fileCount > this.options.MaxFiles {
+		return &LimitError{Reason: fmt.Sprintf("it contains %s files, more than the maximum of %s", util.FormatCount(fileCount), util.FormatCount(this.options.MaxFiles))}
+	}
+
+	return nil
+}
+
+// checkFile returns a *LimitError if the file called name, using '/' as the
+// path separator, may not be received. size is ignored if it is negative,
+// e.g. for symlinks, but the other limits still apply.
+func (this *Client) checkFile(name string, size int64) error {
+	if depth := strings.Count(name, "/") + 1; this.options.MaxDepth > 0 && depth > this.options.MaxDepth {
+		return &LimitError{File: name, Reason: fmt.Sprintf("it is %d levels deep, more than the maximum of %d", depth, this.options.MaxDepth)}
+	}
+
+	if size >= 0 && this.options.MaxFileSize > 0 && size > this.options.MaxFileSize {
+		return &LimitError{File: name, Reason: fmt.Sprintf("it is %s, more than the maximum file size of %s", util.FormatByteSize(size), util.FormatByteSize(this.options.MaxFileSize))}
+	}
+
+	for _, extension := range this.options.BlockExtensions {
+		if hasExtension(name, extension) {
+			return &LimitError{File: name, Reason: fmt.Sprintf("files ending in %s are blocked", normalizeExtension(extension))}
+		}
+	}
+
+	if len(this.options.AllowExtensions) == 0 {
+		return nil
+	}
+
+	for _, extension := range this.options.AllowExtensions {
+		if hasExtension(name, extension) {
+			return nil
+		}
+	}
+
+	allowed := make([]string, len(this.options.AllowExtensions))
+
+	for i, extension := range this.options.AllowExtensions {
+		allowed[i] = normalizeExtension(extension)
+	}
+
+	return &LimitError{File: name, Reason: fmt.Sprintf("only files ending in %s are allowed", strings.Join(allowed, ", "))}
+}
+
+// hasExtension reports whether name ends with extension, ignoring case.
+// Extensions may contain several dots, e.g. ".tar.gz".
+func hasExtension(name string, extension string) bool {
+	return strings.HasSuffix(strings.ToLower(name), normalizeExtension(extension))
+}
+
+// normalizeExtension converts "EXE" or ".exe" into ".exe".
+func normalizeExtension(extension string) string {
+	return "." + strings.TrimPrefix(strings.ToLower(strings.TrimSpace(extension)), ".")
+}
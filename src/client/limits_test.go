@@ -0,0 +1,40 @@
+package client
+
+import "testing"
+
+func TestCheckFile(t *testing.T) {
+	client := &Client{options: Options{
+		MaxFileSize:     100,
+		MaxDepth:        2,
+		AllowExtensions: []string{"jpg", ".tar.gz"},
+		BlockExtensions: []string{".exe.jpg"},
+	}}
+
+	tests := []struct {
+		description string
+		name        string
+		// size is -1 for symlinks
+		size    int64
+		allowed bool
+	}{
+		{"allowed file", "photos/a.jpg", 10, true},
+		{"allowed extension in upper case", "A.JPG", 10, true},
+		{"extension with two dots", "backup.tar.gz", 10, true},
+		{"too big", "a.jpg", 101, false},
+		{"too deep", "photos/2024/a.jpg", 10, false},
+		{"extension not allowed", "a.txt", 10, false},
+		{"blocked extension", "a.exe.jpg", 10, false},
+		{"allowed symlink", "photos/latest.jpg", -1, true},
+		{"symlink with an extension not allowed", "photos/latest.txt", -1, false},
+		{"symlink with a blocked extension", "run.exe.jpg", -1, false},
+		{"symlink too deep", "photos/2024/latest.jpg", -1, false},
+	}
+
+	for _, test := range tests {
+		err := client.checkFile(test.name, test.size)
+
+		if (err == nil) != test.allowed {
+			t.Errorf("%s: got %v, want allowed to be %t", test.description, err, test.allowed)
+		}
+	}
+}
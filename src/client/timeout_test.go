@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHandshakeTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	// The server accepts the connection but never replies
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			defer conn.Close()
+		}
+	}()
+
+	client := NewClient(Options{Password: "secret", OutputDirectory: t.TempDir(), HandshakeTimeout: 100 * time.Millisecond})
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := client.Get(context.Background(), listener.Addr().String())
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("a server which never replied was accepted")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handshake didn't time out")
+	}
+}
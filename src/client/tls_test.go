@@ -0,0 +1,37 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aiden-deloryn/hoist/src/events"
+	"github.com/aiden-deloryn/hoist/src/share"
+)
+
+func TestTLSConfig(t *testing.T) {
+	fingerprint := strings.Repeat("ab", 32)
+
+	tests := []struct {
+		description string
+		options     Options
+		descriptor  share.Descriptor
+		useTLS      bool
+		err         bool
+	}{
+		{"no TLS", Options{}, share.Descriptor{}, false, false},
+		{"TLS without a fingerprint", Options{TLS: true}, share.Descriptor{}, false, true},
+		{"TLS without a fingerprint, insecure", Options{TLS: true, Insecure: true}, share.Descriptor{}, true, false},
+		{"fingerprint", Options{Fingerprint: fingerprint}, share.Descriptor{}, true, false},
+		{"fingerprint in the descriptor", Options{TLS: true}, share.Descriptor{Fingerprint: fingerprint}, true, false},
+		{"fingerprints don't match", Options{Fingerprint: fingerprint}, share.Descriptor{Fingerprint: strings.Repeat("cd", 32)}, false, true},
+	}
+
+	for _, test := range tests {
+		client := NewClient(test.options)
+		config, err := client.tlsConfig(&test.descriptor, func(events.Event) {})
+
+		if (err != nil) != test.err || (config != nil) != test.useTLS {
+			t.Errorf("%s: got (%v, %v), want TLS %t and error %t", test.description, config != nil, err, test.useTLS, test.err)
+		}
+	}
+}
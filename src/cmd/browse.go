@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aiden-deloryn/hoist/src/auth"
+	"github.com/aiden-deloryn/hoist/src/browser"
+	"github.com/aiden-deloryn/hoist/src/client"
+	"github.com/aiden-deloryn/hoist/src/progress"
+	"github.com/aiden-deloryn/hoist/src/share"
+	"github.com/aiden-deloryn/hoist/src/types"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// browseCmd represents the browse command
+var browseCmd = &cobra.Command{
+	Use:   "browse [address]",
+	Short: "Browse the files being shared from another computer and choose which to download",
+	Long: `Browse the files being shared from another computer and choose which to download.
+
+Use the arrow keys to move around and step into directories, and the space
+bar to mark files and directories. Press d to download everything marked, or
+q to quit without downloading.`,
+	RunE: runBrowseCmd,
+	Args: cobra.ExactArgs(1),
+}
+
+func init() {
+	rootCmd.AddCommand(browseCmd)
+
+	addConnectionFlags(browseCmd)
+	browseCmd.Flags().StringP("output", "o", "", "Set a custom output directory")
+	browseCmd.Flags().String("progress", string(progress.ModeAuto), "How to display download progress: auto, bar, plain or none")
+	browseCmd.Flags().BoolP("quiet", "q", false, "Do not display download progress (same as --progress=none)")
+}
+
+func runBrowseCmd(cmd *cobra.Command, args []string) error {
+	outputDirectory, _ := cmd.Flags().GetString("output")
+	progressModeString, _ := cmd.Flags().GetString("progress")
+	quiet, _ := cmd.Flags().GetBool("quiet")
+
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) || !terminal.IsTerminal(int(os.Stdout.Fd())) {
+		return errors.New("hoist browse needs a terminal, use hoist ls and hoist get --only instead")
+	}
+
+	progressMode, err := progress.ParseMode(progressModeString)
+
+	if err != nil {
+		return err
+	}
+
+	if quiet {
+		progressMode = progress.ModeNone
+	}
+
+	outputDirectory, err = expandHome(outputDirectory)
+
+	if err != nil {
+		return err
+	}
+
+	options, err := connectionOptions(cmd)
+
+	if err != nil {
+		return err
+	}
+
+	options.OutputDirectory = outputDirectory
+	options.Events = progress.NewReceiverConsole(os.Stderr, progress.ModeNone)
+
+	ctx, stop := withInterrupt(cmd.Context())
+	defer stop()
+
+	manifest, err := client.NewClient(options).List(ctx, args[0])
+
+	if errors.Is(err, context.Canceled) {
+		return fmt.Errorf("browsing interrupted")
+	} else if err != nil {
+		return err
+	}
+
+	descriptor, err := share.ParseDescriptor(args[0])
+
+	if err != nil {
+		return err
+	}
+
+	// Tell the user while they can still change what is marked if there is
+	// too much to ask for
+	check := func(patterns []string) error {
+		return auth.CheckRequest(&types.Request{Only: patterns, Path: descriptor.Path})
+	}
+
+	patterns, err := browser.New(os.Stdin, os.Stdout, "hoist browse "+args[0], manifest, check).Run()
+
+	if err != nil {
+		return err
+	}
+
+	if patterns == nil {
+		return nil
+	}
+
+	// Download what was marked on a new connection
+	options.Only = patterns
+	options.Events = progress.NewReceiverConsole(os.Stdout, progressMode)
+
+	if _, err := client.NewClient(options).Get(ctx, args[0]); err != nil {
+		if errors.Is(err, context.Canceled) {
+			return fmt.Errorf("download interrupted")
+		}
+
+		return err
+	}
+
+	return nil
+}
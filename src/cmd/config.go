@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/aiden-deloryn/hoist/src/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// exclusiveFlags are groups of flags which can't be used together. A flag
+// given on the command line overrides every flag in its group in the config
+// file.
+var exclusiveFlags = [][]string{
+	{"no-password", "password", "password-file", "generate-password", "trusted-only"},
+	{"bind", "interface"},
+}
+
+// applyConfigFile sets cmd's flags from the file given by --config, where
+// each key is the name of a flag. Flags given on the command line take
+// precedence. Entries in sections are returned for the caller to handle.
+func applyConfigFile(cmd *cobra.Command) ([]config.Entry, error) {
+	filename, _ := cmd.Flags().GetString("config")
+
+	if filename == "" {
+		return nil, nil
+	}
+
+	entries, err := config.Load(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var sections []config.Entry
+
+	overridden := map[string]bool{}
+
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		overridden[flag.Name] = true
+
+		for _, group := range exclusiveFlags {
+			if contains(group, flag.Name) {
+				for _, name := range group {
+					overridden[name] = true
+				}
+			}
+		}
+	})
+
+	for _, entry := range entries {
+		if entry.Section != "" {
+			sections = append(sections, entry)
+			continue
+		}
+
+		if entry.Key == "config" || cmd.Flags().Lookup(entry.Key) == nil {
+			return nil, fmt.Errorf("%s:%d: unknown setting %q", filename, entry.Line, entry.Key)
+		}
+
+		if overridden[entry.Key] {
+			continue
+		}
+
+		if err := cmd.Flags().Set(entry.Key, entry.Value); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid value for %s: %s", filename, entry.Line, entry.Key, err)
+		}
+	}
+
+	return sections, nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
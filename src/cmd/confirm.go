@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aiden-deloryn/hoist/src/progress"
+	"github.com/aiden-deloryn/hoist/src/server"
+)
+
+// confirmer asks the user whether to send the share to each client which
+// authenticates, one client at a time.
+type confirmer struct {
+	// turn is held by the client whose question is being asked
+	turn    chan struct{}
+	answers chan string
+}
+
+func newConfirmer() *confirmer {
+	this := &confirmer{
+		turn:    make(chan struct{}, 1),
+		answers: make(chan string),
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+
+		for scanner.Scan() {
+			this.answers <- scanner.Text()
+		}
+
+		close(this.answers)
+	}()
+
+	return this
+}
+
+// approve asks the user whether to send the share to the client described
+// by request. The client is declined if ctx is cancelled before the user
+// answers.
+func (this *confirmer) approve(ctx context.Context, request server.ApprovalRequest) bool {
+	select {
+	case this.turn <- struct{}{}:
+		defer func() { <-this.turn }()
+	case <-ctx.Done():
+		return false
+	}
+
+	// Ignore anything typed before the question was asked
+	for drained := false; !drained; {
+		select {
+		case <-this.answers:
+		default:
+			drained = true
+		}
+	}
+
+	name := filepath.Base(request.Filename)
+
+	if request.Path != "" {
+		name = strings.Trim(request.Path, "/")
+	}
+
+	if info, err := os.Stat(request.Filename); err == nil && info.IsDir() {
+		name += "/"
+	}
+
+	// The path comes from the client, so quote it like the patterns below
+	if request.Path != "" {
+		name = strconv.Quote(name)
+	}
+
+	action := "download"
+
+	if request.List {
+		action = "list"
+	}
+
+	// The patterns come from the client, so quote them in case they contain
+	// control characters
+	if len(request.Only) > 0 {
+		patterns := make([]string, len(request.Only))
+
+		for i, pattern := range request.Only {
+			patterns[i] = strconv.Quote(pattern)
+		}
+
+		name += " (only " + strings.Join(patterns, ", ") + ")"
+	}
+
+	fmt.Fprintf(os.Stderr, "%s wants to %s %s [y/N] ", progress.DescribePeer(request.Address, request.Peer, request.Trusted), action, name)
+
+	select {
+	case answer, ok := <-this.answers:
+		if !ok {
+			fmt.Fprintf(os.Stderr, "\n")
+			return false
+		}
+
+		answer = strings.ToLower(strings.TrimSpace(answer))
+
+		return answer == "y" || answer == "yes"
+	case <-ctx.Done():
+		fmt.Fprintf(os.Stderr, "\nNo answer, declined.\n")
+		return false
+	}
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/aiden-deloryn/hoist/src/daemon"
+	"github.com/aiden-deloryn/hoist/src/server"
+	"github.com/aiden-deloryn/hoist/src/systemd"
+	"github.com/spf13/cobra"
+)
+
+// daemonCmd represents the daemon command
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run the shares declared in a config file as a long-running service",
+	Long: `Run the shares declared in a config file as a long-running service.
+
+The YAML config file takes the same settings as hoist send, plus "path" for
+an unnamed share, "serve: true" to let receivers choose a path inside each
+share as with hoist serve, and the settings of each named share under
+"shares". A password must be set in the file, or in $HOIST_PASSWORD, as there
+is no one to ask for it. For example:
+
+  port: 7000
+  password-file: /etc/hoist/password
+  shares:
+    builds:
+      path: /srv/builds
+      trusted-only: true
+
+Files which don't end in .yaml or .yml are read in the "key = value" format
+of hoist send --config.
+
+Send SIGHUP to reload the config file. New connections use the new settings,
+while active transfers finish with the old ones. Lockouts, download counts
+and a generated TLS certificate are kept. The port and bind address
+can only be changed by restarting the daemon.
+
+When started by systemd, the daemon reports its state with sd_notify, so it
+can be used with Type=notify, and listens on the socket passed in by socket
+activation if there is one. Events are logged to journald, syslog or stderr.`,
+	RunE: runDaemonCmd,
+	Args: cobra.NoArgs,
+}
+
+// interactiveFlags are the settings of hoist send which need someone at the
+// terminal, so can't be used in the daemon's config file.
+var interactiveFlags = []string{"confirm", "confirm-timeout", "generate-password", "json", "progress", "quiet"}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+
+	daemonCmd.Flags().String("config", "", "The config file declaring what to share (required)")
+	daemonCmd.Flags().String("log", daemon.LogAuto, "Where to log events: auto, stderr, journald or syslog")
+	daemonCmd.MarkFlagRequired("config")
+}
+
+func runDaemonCmd(cmd *cobra.Command, args []string) error {
+	configFile, _ := cmd.Flags().GetString("config")
+	logTarget, _ := cmd.Flags().GetString("log")
+
+	logger, err := daemon.NewLogger(logTarget)
+
+	if err != nil {
+		return err
+	}
+
+	listeners, err := systemd.Listeners()
+
+	if err != nil {
+		return err
+	}
+
+	var listener net.Listener
+
+	if len(listeners) > 1 {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+
+		return fmt.Errorf("hoist daemon can only use one socket, but systemd passed in %d", len(listeners))
+	} else if len(listeners) == 1 {
+		listener = listeners[0]
+	}
+
+	settings := &daemonConfig{filename: configFile, socketActivated: listener != nil}
+
+	hoistDaemon := daemon.New(daemon.Options{
+		Load:     settings.load,
+		Listener: listener,
+		Logger:   logger,
+	})
+
+	ctx, cancel := context.WithCancel(cmd.Context())
+	defer cancel()
+
+	// SIGHUP reloads the config file. The first SIGTERM or Ctrl-C stops
+	// accepting new connections and lets active transfers finish, the
+	// second cancels them
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	go func() {
+		stopping := false
+
+		for {
+			select {
+			case received := <-signals:
+				switch {
+				case received == syscall.SIGHUP:
+					hoistDaemon.Reload()
+				case stopping:
+					cancel()
+				default:
+					stopping = true
+					hoistDaemon.Shutdown()
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return hoistDaemon.Run(ctx)
+}
+
+// daemonConfig reads the daemon's config file each time it is loaded.
+type daemonConfig struct {
+	filename string
+	// socketActivated is true if the daemon was given a socket by systemd,
+	// so doesn't need a port
+	socketActivated bool
+	// certificate is the certificate generated for "tls: true" without a
+	// tls-cert, kept so that its fingerprint doesn't change on reload
+	certificate *tls.Certificate
+}
+
+// load reads the server's options from the config file. The port must be
+// set unless the daemon was given a socket by systemd.
+func (this *daemonConfig) load() (*server.Options, error) {
+	settings := &cobra.Command{Use: "daemon"}
+	addServerFlags(settings)
+	settings.Flags().Bool("follow-symlinks", false, "")
+	settings.Flags().Bool("serve", false, "")
+	settings.Flags().String("path", "", "")
+	settings.Flags().Set("config", this.filename)
+
+	sections, err := applyConfigFile(settings)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range interactiveFlags {
+		if settings.Flags().Changed(name) {
+			return nil, fmt.Errorf("%s: %s can't be used with hoist daemon", this.filename, name)
+		}
+	}
+
+	if !this.socketActivated && !settings.Flags().Changed("port") {
+		return nil, fmt.Errorf("%s: set the port to listen on, or start hoist daemon with systemd socket activation", this.filename)
+	}
+
+	filename, _ := settings.Flags().GetString("path")
+	serveRoot, _ := settings.Flags().GetBool("serve")
+
+	options, _, err := readServerOptions(settings, sections, filename, serveRoot, false, this.certificate)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsCertFile, _ := settings.Flags().GetString("tls-cert"); tlsCertFile == "" && options.TLSCertificate != nil {
+		this.certificate = options.TLSCertificate
+	}
+
+	return options, nil
+}
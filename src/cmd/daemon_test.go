@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aiden-deloryn/hoist/src/values"
+)
+
+// isolateConfig keeps the user's identity and password out of a test.
+func isolateConfig(t *testing.T) {
+	t.Setenv(values.CONFIG_DIR_ENV_VAR, t.TempDir())
+	// Restores the password after the test
+	t.Setenv(values.PASSWORD_ENV_VAR, "")
+	os.Unsetenv(values.PASSWORD_ENV_VAR)
+}
+
+func TestDaemonConfigLoad(t *testing.T) {
+	isolateConfig(t)
+
+	directory := t.TempDir()
+	shared := filepath.Join(directory, "builds")
+
+	if err := os.Mkdir(shared, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description     string
+		filename        string
+		config          string
+		socketActivated bool
+		// err is part of the expected error, or empty if loading succeeds
+		err string
+	}{
+		{
+			"yaml with a share", "hoist.yaml",
+			"port: 7000\npassword: secret\nallow: [10.0.0.0/8, 192.168.1.0/24]\nshares:\n  builds:\n    path: " + shared + "\n    trusted-only: true\n",
+			false, "",
+		},
+		{"ini with a share", "hoist.conf", "port = 7000\npassword = secret\n\n[share builds]\npath = " + shared + "\n", false, ""},
+		{"no port", "hoist.yaml", "password: secret\npath: " + shared + "\n", false, "set the port"},
+		{"no port with socket activation", "hoist.yaml", "password: secret\npath: " + shared + "\n", true, ""},
+		{"interactive setting", "hoist.yaml", "port: 7000\npassword: secret\nconfirm: true\npath: " + shared + "\n", false, "confirm can't be used"},
+		{"no password", "hoist.yaml", "port: 7000\npath: " + shared + "\n", false, "no password is set"},
+		{"unknown setting", "hoist.yaml", "port: 7000\ncolour: blue\n", false, "unknown setting \"colour\""},
+		{"unknown share setting", "hoist.yaml", "port: 7000\npassword: secret\nshares:\n  builds:\n    colour: blue\n", false, "colour"},
+		{"shares not a mapping", "hoist.yaml", "port: 7000\nshares: [builds]\n", false, "expected the settings of each share"},
+		{"nothing to share", "hoist.yaml", "port: 7000\npassword: secret\n", false, "nothing to share"},
+	}
+
+	for _, test := range tests {
+		filename := filepath.Join(directory, test.filename)
+
+		if err := os.WriteFile(filename, []byte(test.config), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		settings := &daemonConfig{filename: filename, socketActivated: test.socketActivated}
+		_, err := settings.load()
+
+		if test.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.description, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: got error %v, want one containing %q", test.description, err, test.err)
+		}
+	}
+}
+
+func TestDaemonConfigKeepsGeneratedCertificate(t *testing.T) {
+	isolateConfig(t)
+
+	directory := t.TempDir()
+	filename := filepath.Join(directory, "hoist.yaml")
+	config := "port: 7000\npassword: secret\ntls: true\npath: " + directory + "\n"
+
+	if err := os.WriteFile(filename, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	settings := &daemonConfig{filename: filename}
+	first, err := settings.load()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := settings.load()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.TLSCertificate == nil || reloaded.TLSCertificate != first.TLSCertificate {
+		t.Error("a new certificate was generated when the config was reloaded")
+	}
+}
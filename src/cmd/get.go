@@ -1,13 +1,24 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"os"
 	"os/user"
 	"path/filepath"
 	"strings"
-	"syscall"
 
+	"github.com/aiden-deloryn/hoist/src/certs"
 	"github.com/aiden-deloryn/hoist/src/client"
+	"github.com/aiden-deloryn/hoist/src/events"
+	"github.com/aiden-deloryn/hoist/src/progress"
+	"github.com/aiden-deloryn/hoist/src/share"
+	"github.com/aiden-deloryn/hoist/src/types"
+	"github.com/aiden-deloryn/hoist/src/util"
+	"github.com/aiden-deloryn/hoist/src/values"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh/terminal"
 )
@@ -33,42 +44,252 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// getCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
-	getCmd.Flags().Bool("no-password", false, "Do not prompt for a password (password will be blank)")
-	getCmd.Flags().StringP("password", "p", "", "Provide the password to use for authentication")
+	addConnectionFlags(getCmd)
 	getCmd.Flags().StringP("output", "o", "", "Set a custom output directory")
+	getCmd.Flags().StringArray("only", nil, "Only download the files matching this pattern, e.g. 'photos/2024/**' or '*.pdf' (can be repeated)")
+	getCmd.Flags().BoolP("yes", "y", false, "Download without asking first")
+	getCmd.Flags().String("max-size", "", "Refuse shares larger than this, e.g. 10G")
+	getCmd.Flags().Int64("max-files", 0, "Refuse shares containing more than this many files (0 for no limit)")
+	getCmd.Flags().String("max-file-size", "", "Refuse files larger than this, e.g. 2G")
+	getCmd.Flags().Int("max-depth", 0, "Refuse files nested more than this many levels deep, e.g. 2 allows dir/file but not dir/sub/file (0 for no limit)")
+	getCmd.Flags().StringSlice("allow-ext", nil, "Only accept files with these extensions, e.g. .jpg,.png")
+	getCmd.Flags().StringSlice("block-ext", nil, "Refuse files with these extensions, e.g. .exe,.sh")
+	getCmd.Flags().Bool("preallocate", false, "Reserve disk space for each file before downloading it (Linux only)")
+	getCmd.Flags().String("progress", string(progress.ModeAuto), "How to display download progress: auto, bar, plain or none")
+	getCmd.Flags().BoolP("quiet", "q", false, "Do not display download progress (same as --progress=none)")
+	getCmd.Flags().Bool("json", false, "Write newline-delimited JSON events to stdout instead of human readable output")
+}
+
+// addConnectionFlags adds the flags used by get and ls to connect to a
+// sender.
+func addConnectionFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("no-password", false, "Do not prompt for a password (password will be blank)")
+	cmd.Flags().StringP("password", "p", "", "Provide the password to use for authentication (visible to other users, prefer --password-file or $HOIST_PASSWORD)")
+	cmd.Flags().String("password-file", "", "Read the password from the first line of this file")
+	cmd.Flags().Duration("handshake-timeout", values.DEFAULT_HANDSHAKE_TIMEOUT, "Abort if authentication doesn't complete within this time (0 to disable)")
+	cmd.Flags().Duration("idle-timeout", values.DEFAULT_IDLE_TIMEOUT, "Abort if no data is sent or received for this long (0 to disable)")
+	cmd.Flags().Duration("timeout", 0, "Abort if the transfer doesn't complete within this time (0 to disable)")
+	cmd.Flags().Bool("tls", false, "Connect using TLS 1.3 (enabled automatically by --fingerprint)")
+	cmd.Flags().String("fingerprint", "", "Only accept a sender whose TLS certificate has this SHA-256 fingerprint")
+	cmd.Flags().Bool("insecure", false, "Allow --tls without --fingerprint, accepting any certificate the sender presents")
+	cmd.Flags().String("tls-cert", "", "Present this PEM encoded TLS client certificate to senders which require one (requires --tls-key)")
+	cmd.Flags().String("tls-key", "", "The PEM encoded private key for --tls-cert")
+}
+
+// connectionOptions returns the client options set by the flags added by
+// addConnectionFlags.
+func connectionOptions(cmd *cobra.Command) (client.Options, error) {
+	handshakeTimeout, _ := cmd.Flags().GetDuration("handshake-timeout")
+	idleTimeout, _ := cmd.Flags().GetDuration("idle-timeout")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	useTLS, _ := cmd.Flags().GetBool("tls")
+	fingerprint, _ := cmd.Flags().GetString("fingerprint")
+	insecure, _ := cmd.Flags().GetBool("insecure")
+	tlsCertFile, _ := cmd.Flags().GetString("tls-cert")
+	tlsKeyFile, _ := cmd.Flags().GetString("tls-key")
+
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		return client.Options{}, fmt.Errorf("--tls-cert and --tls-key must be used together")
+	}
+
+	var tlsCertificate *tls.Certificate
+
+	if tlsCertFile != "" {
+		certificate, err := certs.LoadCertificate(tlsCertFile, tlsKeyFile)
+
+		if err != nil {
+			return client.Options{}, err
+		}
+
+		tlsCertificate = certificate
+	}
+
+	self, trustedPeers, err := loadIdentity()
+
+	if err != nil {
+		return client.Options{}, err
+	}
+
+	knownSenders, err := loadKnownSenders()
+
+	if err != nil {
+		return client.Options{}, err
+	}
+
+	password, _, hasPassword, err := readPasswordOption(cmd)
+
+	if err != nil {
+		return client.Options{}, err
+	}
+
+	// Only ask for a password if the sender doesn't trust us, and only ask
+	// once if several connections are made
+	getPassword := func() (string, error) {
+		if hasPassword {
+			return password, nil
+		}
+
+		password, err = promptPassword("Enter password: ")
+		hasPassword = err == nil
+
+		return password, err
+	}
+
+	return client.Options{
+		GetPassword:      getPassword,
+		Identity:         self,
+		VerifySender:     verifySender(trustedPeers, knownSenders),
+		HandshakeTimeout: handshakeTimeout,
+		IdleTimeout:      idleTimeout,
+		Timeout:          timeout,
+		TLS:              useTLS || tlsCertificate != nil,
+		Fingerprint:      fingerprint,
+		Insecure:         insecure,
+		TLSCertificate:   tlsCertificate,
+	}, nil
 }
 
 func runGetCmd(cmd *cobra.Command, args []string) error {
-	skipPassword, _ := cmd.Flags().GetBool("no-password")
-	password, _ := cmd.Flags().GetString("password")
 	outputDirectory, _ := cmd.Flags().GetString("output")
+	preallocate, _ := cmd.Flags().GetBool("preallocate")
+	progressModeString, _ := cmd.Flags().GetString("progress")
+	quiet, _ := cmd.Flags().GetBool("quiet")
+	jsonOutput, _ := cmd.Flags().GetBool("json")
+	only, _ := cmd.Flags().GetStringArray("only")
+	yes, _ := cmd.Flags().GetBool("yes")
+	maxSizeString, _ := cmd.Flags().GetString("max-size")
+	maxFiles, _ := cmd.Flags().GetInt64("max-files")
+	maxFileSizeString, _ := cmd.Flags().GetString("max-file-size")
+	maxDepth, _ := cmd.Flags().GetInt("max-depth")
+	allowExtensions, _ := cmd.Flags().GetStringSlice("allow-ext")
+	blockExtensions, _ := cmd.Flags().GetStringSlice("block-ext")
+
+	progressMode, err := progress.ParseMode(progressModeString)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := share.ParseSelection(only); err != nil {
+		return fmt.Errorf("invalid --only: %s", err)
+	}
+
+	if quiet {
+		progressMode = progress.ModeNone
+	}
+
+	var handler events.Handler = progress.NewReceiverConsole(os.Stdout, progressMode)
+
+	if jsonOutput {
+		handler = events.NewJSONHandler(os.Stdout)
+	}
 
-	// Bash doesn't expand "~" if the path is in single or double quotes
-	if strings.HasPrefix(outputDirectory, "~") {
-		user, err := user.Current()
+	outputDirectory, err = expandHome(outputDirectory)
+
+	if err != nil {
+		return err
+	}
+
+	var maxSize, maxFileSize int64
+
+	if maxSizeString != "" {
+		maxSize, err = util.ParseByteSize(maxSizeString)
 
 		if err != nil {
-			return fmt.Errorf("failed to expand home directory (~): %s", err)
+			return fmt.Errorf("invalid --max-size: %s", err)
 		}
-
-		outputDirectory = filepath.Join(user.HomeDir, outputDirectory[1:])
 	}
 
-	if !skipPassword && password == "" {
-		fmt.Print("Enter password: ")
-		passwordBytes, err := terminal.ReadPassword(int(syscall.Stdin))
-		fmt.Println()
+	if maxFileSizeString != "" {
+		maxFileSize, err = util.ParseByteSize(maxFileSizeString)
 
 		if err != nil {
-			return fmt.Errorf("failed to read password: %s", err)
+			return fmt.Errorf("invalid --max-file-size: %s", err)
 		}
+	}
+
+	var confirm func(manifest *types.Manifest) error
 
-		password = string(passwordBytes)
+	if !jsonOutput {
+		confirm = func(manifest *types.Manifest) error {
+			return confirmDownload(manifest, outputDirectory, !yes && terminal.IsTerminal(int(os.Stdin.Fd())))
+		}
 	}
 
-	if err := client.GetFileFromServer(args[0], string(password), outputDirectory); err != nil {
+	options, err := connectionOptions(cmd)
+
+	if err != nil {
 		return err
 	}
 
+	options.OutputDirectory = outputDirectory
+	options.MaxSize = maxSize
+	options.MaxFiles = maxFiles
+	options.MaxFileSize = maxFileSize
+	options.MaxDepth = maxDepth
+	options.AllowExtensions = allowExtensions
+	options.BlockExtensions = blockExtensions
+	options.Only = only
+	options.Confirm = confirm
+	options.Preallocate = preallocate
+	options.Events = handler
+
+	getClient := client.NewClient(options)
+
+	ctx, stop := withInterrupt(cmd.Context())
+	defer stop()
+
+	if _, err := getClient.Get(ctx, args[0]); err != nil {
+		if errors.Is(err, context.Canceled) {
+			return fmt.Errorf("download interrupted")
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// expandHome replaces a leading "~" in filename with the user's home
+// directory, because Bash doesn't expand it if the path is in single or
+// double quotes.
+func expandHome(filename string) (string, error) {
+	if !strings.HasPrefix(filename, "~") {
+		return filename, nil
+	}
+
+	user, err := user.Current()
+
+	if err != nil {
+		return "", fmt.Errorf("failed to expand home directory (~): %s", err)
+	}
+
+	return filepath.Join(user.HomeDir, filename[1:]), nil
+}
+
+// confirmDownload shows what the sender is sharing, and if ask is true, asks
+// the user whether to download it.
+func confirmDownload(manifest *types.Manifest, outputDirectory string, ask bool) error {
+	progress.WriteManifestSummary(os.Stdout, manifest)
+
+	if !ask {
+		return nil
+	}
+
+	if outputDirectory == "" {
+		outputDirectory = "."
+	}
+
+	fmt.Fprintf(os.Stderr, "Download %s to %s? [Y/n] ", progress.DescribeFiles(manifest.FileCount, manifest.TotalSize), outputDirectory)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+
+	if err != nil {
+		return fmt.Errorf("failed to read answer: %s", err)
+	}
+
+	if answer := strings.ToLower(strings.TrimSpace(answer)); answer != "" && answer != "y" && answer != "yes" {
+		return errors.New("download cancelled")
+	}
+
 	return nil
 }
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aiden-deloryn/hoist/src/client"
+	"github.com/aiden-deloryn/hoist/src/progress"
+	"github.com/aiden-deloryn/hoist/src/share"
+	"github.com/spf13/cobra"
+)
+
+// lsCmd represents the ls command
+var lsCmd = &cobra.Command{
+	Use:   "ls [address]",
+	Short: "List the files being shared from another computer without downloading them",
+	Long: `List the files being shared from another computer without downloading them.
+
+Use the names listed with "hoist get --only" to download some of the files.`,
+	RunE: runLsCmd,
+	Args: cobra.ExactArgs(1),
+}
+
+func init() {
+	rootCmd.AddCommand(lsCmd)
+
+	addConnectionFlags(lsCmd)
+	lsCmd.Flags().StringArray("only", nil, "Only list the files matching this pattern, e.g. 'photos/2024/**' or '*.pdf' (can be repeated)")
+	lsCmd.Flags().Bool("json", false, "Write the sender's manifest to stdout as JSON instead of a human readable listing")
+}
+
+func runLsCmd(cmd *cobra.Command, args []string) error {
+	only, _ := cmd.Flags().GetStringArray("only")
+	jsonOutput, _ := cmd.Flags().GetBool("json")
+
+	if _, err := share.ParseSelection(only); err != nil {
+		return fmt.Errorf("invalid --only: %s", err)
+	}
+
+	options, err := connectionOptions(cmd)
+
+	if err != nil {
+		return err
+	}
+
+	options.Only = only
+	options.Events = progress.NewReceiverConsole(os.Stderr, progress.ModeNone)
+
+	ctx, stop := withInterrupt(cmd.Context())
+	defer stop()
+
+	manifest, err := client.NewClient(options).List(ctx, args[0])
+
+	if errors.Is(err, context.Canceled) {
+		return fmt.Errorf("listing interrupted")
+	} else if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(manifest)
+	}
+
+	progress.WriteListing(os.Stdout, manifest)
+
+	return nil
+}
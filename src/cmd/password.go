@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aiden-deloryn/hoist/src/util"
+	"github.com/aiden-deloryn/hoist/src/values"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// readPassword works out which password to use for send or get. In order of
+// preference it comes from --no-password, --password, --password-file,
+// --generate-password, $HOIST_PASSWORD, stdin if it is not a terminal, and
+// finally a prompt. generated is true if a random password was generated.
+func readPassword(cmd *cobra.Command, prompt string) (password string, generated bool, err error) {
+	password, generated, ok, err := readPasswordOption(cmd)
+
+	if err != nil || ok {
+		return password, generated, err
+	}
+
+	password, err = promptPassword(prompt)
+
+	return password, false, err
+}
+
+// readPasswordOption returns the password given by a flag or $HOIST_PASSWORD.
+// ok is false if there is none, and the user needs to be asked instead.
+func readPasswordOption(cmd *cobra.Command) (password string, generated bool, ok bool, err error) {
+	skipPassword, _ := cmd.Flags().GetBool("no-password")
+	password, _ = cmd.Flags().GetString("password")
+	passwordFile, _ := cmd.Flags().GetString("password-file")
+	generatePassword, _ := cmd.Flags().GetBool("generate-password")
+
+	var flags []string
+	sources := 0
+
+	for _, flag := range []string{"no-password", "password", "password-file", "generate-password"} {
+		if cmd.Flags().Lookup(flag) == nil {
+			continue
+		}
+
+		flags = append(flags, "--"+flag)
+
+		if cmd.Flags().Changed(flag) {
+			sources++
+		}
+	}
+
+	if sources > 1 {
+		return "", false, false, fmt.Errorf("only one of %s can be used", strings.Join(flags, ", "))
+	}
+
+	switch {
+	case skipPassword:
+		return "", false, true, nil
+	case cmd.Flags().Changed("password"):
+		return password, false, true, nil
+	case passwordFile != "":
+		password, err = readPasswordFile(passwordFile)
+		return password, false, true, err
+	case generatePassword:
+		password, err = util.GeneratePassword(5, 5)
+		return password, true, true, err
+	}
+
+	if password, ok := os.LookupEnv(values.PASSWORD_ENV_VAR); ok {
+		return password, false, true, nil
+	}
+
+	return "", false, false, nil
+}
+
+// promptPassword reads the password from stdin if it is not a terminal, and
+// otherwise prompts the user for it.
+func promptPassword(prompt string) (string, error) {
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		password, err := readPasswordLine(os.Stdin)
+
+		if err != nil {
+			return "", fmt.Errorf("failed to read password from stdin: %s", err)
+		}
+
+		return password, nil
+	}
+
+	// Prompt on stderr so the prompt doesn't end up in the --json output
+	fmt.Fprint(os.Stderr, prompt)
+	passwordBytes, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %s", err)
+	}
+
+	return string(passwordBytes), nil
+}
+
+// readPasswordFile returns the first line of the file at path.
+func readPasswordFile(path string) (string, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to open password file: %s", err)
+	}
+
+	defer file.Close()
+
+	password, err := readPasswordLine(file)
+
+	if err != nil {
+		return "", fmt.Errorf("failed to read password file: %s", err)
+	}
+
+	return password, nil
+}
+
+// readPasswordLine reads a single line from r without the line ending.
+func readPasswordLine(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+
+	if err != nil && !(err == io.EOF && line != "") {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
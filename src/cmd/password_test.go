@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/aiden-deloryn/hoist/src/values"
+	"github.com/spf13/cobra"
+)
+
+func TestReadPasswordOption(t *testing.T) {
+	isolateConfig(t)
+
+	passwordFile := filepath.Join(t.TempDir(), "password")
+
+	if err := os.WriteFile(passwordFile, []byte("  from file  \r\nsecond line\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	generated := regexp.MustCompile(`^[a-km-np-z2-9]{5}(-[a-km-np-z2-9]{5}){4}$`)
+
+	tests := []struct {
+		description string
+		args        []string
+		env         string
+		setEnv      bool
+		password    string
+		generated   bool
+		ok          bool
+		// err is part of the expected error, or empty if there is none
+		err string
+	}{
+		{"nothing", nil, "", false, "", false, false, ""},
+		{"environment", nil, "from env", true, "from env", false, true, ""},
+		{"empty environment", nil, "", true, "", false, true, ""},
+		{"flag before environment", []string{"--password", "from flag"}, "from env", true, "from flag", false, true, ""},
+		{"empty flag", []string{"--password", ""}, "", false, "", false, true, ""},
+		{"file", []string{"--password-file", passwordFile}, "", false, "  from file  ", false, true, ""},
+		{"no password", []string{"--no-password"}, "from env", true, "", false, true, ""},
+		{"generated", []string{"--generate-password"}, "", false, "", true, true, ""},
+		{"two sources", []string{"--password", "a", "--password-file", passwordFile}, "", false, "", false, false, "only one of"},
+		{"missing file", []string{"--password-file", passwordFile + ".missing"}, "", false, "", false, false, "failed to open password file"},
+	}
+
+	for _, test := range tests {
+		if test.setEnv {
+			os.Setenv(values.PASSWORD_ENV_VAR, test.env)
+		} else {
+			os.Unsetenv(values.PASSWORD_ENV_VAR)
+		}
+
+		cmd := &cobra.Command{Use: "send"}
+		addServerFlags(cmd)
+
+		if err := cmd.ParseFlags(test.args); err != nil {
+			t.Fatal(err)
+		}
+
+		password, isGenerated, ok, err := readPasswordOption(cmd)
+
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got %v, want an error containing %q", test.description, err, test.err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", test.description, err)
+		} else if ok != test.ok || isGenerated != test.generated {
+			t.Errorf("%s: got ok %t and generated %t, want %t and %t", test.description, ok, isGenerated, test.ok, test.generated)
+		} else if test.generated && !generated.MatchString(password) {
+			t.Errorf("%s: %q isn't a generated password", test.description, password)
+		} else if !test.generated && password != test.password {
+			t.Errorf("%s: got %q, want %q", test.description, password, test.password)
+		}
+	}
+}
+
+func TestReadPasswordLine(t *testing.T) {
+	tests := []struct {
+		input    string
+		password string
+		valid    bool
+	}{
+		{"secret\n", "secret", true},
+		{"secret\r\nmore\n", "secret", true},
+		{"no newline", "no newline", true},
+		{"\n", "", true},
+		{"", "", false},
+	}
+
+	for _, test := range tests {
+		password, err := readPasswordLine(strings.NewReader(test.input))
+
+		if (err == nil) != test.valid || password != test.password {
+			t.Errorf("%q: got (%q, %v), want %q and valid %t", test.input, password, err, test.password, test.valid)
+		}
+	}
+}
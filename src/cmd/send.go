@@ -1,27 +1,39 @@
 package cmd
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"net"
 	"os"
-	"os/user"
-	"path/filepath"
+	"os/signal"
 	"strings"
 	"syscall"
 
+	"github.com/aiden-deloryn/hoist/src/certs"
+	"github.com/aiden-deloryn/hoist/src/config"
+	"github.com/aiden-deloryn/hoist/src/events"
+	"github.com/aiden-deloryn/hoist/src/progress"
 	"github.com/aiden-deloryn/hoist/src/server"
 	"github.com/aiden-deloryn/hoist/src/util"
 	"github.com/aiden-deloryn/hoist/src/values"
 	"github.com/spf13/cobra"
-	"golang.org/x/crypto/ssh/terminal"
 )
 
 // sendCmd represents the send command
 var sendCmd = &cobra.Command{
 	Use:   "send [filename]",
 	Short: "Send a file over a local area network",
-	Long:  `Send a file over a local area network.`,
-	RunE:  runSendCmd,
-	Args:  cobra.ExactArgs(1),
+	Long: `Send a file over a local area network.
+
+Several files or directories can be shared on the same port with --share
+NAME=PATH, each with its own password and access rules if they are set in a
+[share NAME] section of the --config file. Receivers ask for one by adding
+its name to the address, e.g. "hoist get 192.168.1.10:47478/builds".`,
+	RunE: runSendCmd,
+	Args: cobra.MaximumNArgs(1),
 }
 
 func init() {
@@ -37,58 +49,343 @@ func init() {
 	// is called directly, e.g.:
 	// sendCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	sendCmd.Flags().BoolP("keep-alive", "k", false, "Keep the connection open for multiple transfers")
-	sendCmd.Flags().Bool("no-password", false, "Do not prompt for a password (password will be blank)")
-	sendCmd.Flags().String("password", "", "Set the password for incoming connections")
 	sendCmd.Flags().BoolP("follow-symlinks", "l", false, "Follow symbolic links instead of skipping them")
-	sendCmd.Flags().StringP("port", "p", "0", "The port number to use for serving files")
+	addServerFlags(sendCmd)
+}
+
+// addServerFlags adds the flags shared by hoist send and hoist serve to cmd.
+func addServerFlags(cmd *cobra.Command) {
+	cmd.Flags().Duration("expire", 0, "Stop sharing after this much time, e.g. 30m (0 to never expire)")
+	cmd.Flags().Int("max-downloads", 0, "Stop sharing after this many successful downloads of each share (0 for no limit)")
+	cmd.Flags().Int("max-clients", 0, "The maximum number of clients that can be connected at once (0 for no limit)")
+	cmd.Flags().Int("max-auth-failures", values.DEFAULT_MAX_AUTH_FAILURES, "Lock out an IP address after this many failed password attempts (-1 for no limit)")
+	cmd.Flags().Duration("auth-backoff", values.DEFAULT_AUTH_BACKOFF, "How long an IP address must wait after a failed password attempt, doubling after each failure (-1s to disable)")
+	cmd.Flags().Duration("auth-lockout", values.DEFAULT_AUTH_LOCKOUT, "How long an IP address stays locked out, and how long failed attempts are remembered (-1s to remember them until the sender stops)")
+	cmd.Flags().Bool("no-password", false, "Do not prompt for a password (password will be blank)")
+	cmd.Flags().String("password", "", "Set the password for incoming connections (visible to other users, prefer --password-file or $HOIST_PASSWORD)")
+	cmd.Flags().String("password-file", "", "Read the password from the first line of this file")
+	cmd.Flags().Bool("generate-password", false, "Generate a random password and display it with the address")
+	cmd.Flags().Bool("trusted-only", false, "Only send to trusted peers, without a password (see \"hoist trust\")")
+	cmd.Flags().Bool("confirm", false, "Ask before sending to each receiver once it has authenticated")
+	cmd.Flags().Duration("confirm-timeout", values.DEFAULT_APPROVAL_TIMEOUT, "Decline a receiver if --confirm gets no answer within this time (0 to wait forever)")
+	cmd.Flags().StringP("port", "p", "0", "The port number to use for serving files")
+	cmd.Flags().String("bind", "", "Only listen on this IP address (default all interfaces)")
+	cmd.Flags().String("interface", "", "Only listen on the addresses of this network interface, e.g. eth0")
+	cmd.Flags().StringSlice("allow", nil, "Only accept connections from these IP addresses or CIDR networks, e.g. 10.20.0.0/16,192.168.1.42")
+	cmd.Flags().StringSlice("deny", nil, "Refuse connections from these IP addresses or CIDR networks")
+	cmd.Flags().String("progress", string(progress.ModeAuto), "How to display the progress of connected clients: auto, bar, plain or none")
+	cmd.Flags().BoolP("quiet", "q", false, "Do not display the progress of connected clients (same as --progress=none)")
+	cmd.Flags().Duration("handshake-timeout", values.DEFAULT_HANDSHAKE_TIMEOUT, "Abort if authentication doesn't complete within this time (0 to disable)")
+	cmd.Flags().Duration("idle-timeout", values.DEFAULT_IDLE_TIMEOUT, "Abort if no data is sent or received for this long (0 to disable)")
+	cmd.Flags().Duration("timeout", 0, "Abort if the transfer doesn't complete within this time (0 to disable)")
+	cmd.Flags().Bool("tls", false, "Encrypt transfers with TLS 1.3 using a new self-signed certificate, and display its fingerprint")
+	cmd.Flags().String("tls-cert", "", "Use TLS with this PEM encoded certificate instead of generating one (requires --tls-key)")
+	cmd.Flags().String("tls-key", "", "The PEM encoded private key for --tls-cert")
+	cmd.Flags().String("tls-client-ca", "", "Only accept clients presenting a TLS certificate signed by one of the PEM encoded certificates in this file")
+	cmd.Flags().Bool("json", false, "Write newline-delimited JSON events to stdout instead of human readable output")
+	cmd.Flags().StringArray("share", nil, "Also share this file or directory under a name, as NAME=PATH, e.g. builds=/srv/builds (can be repeated)")
+	cmd.Flags().String("config", "", "Read settings from this file, with one \"flag = value\" per line and [share NAME] sections, or in YAML if it ends in .yaml or .yml")
 }
 
 func runSendCmd(cmd *cobra.Command, args []string) error {
+	filename := ""
+
+	if len(args) > 0 {
+		filename = args[0]
+	}
+
+	return runServer(cmd, filename, false)
+}
+
+// runServer shares filename, and the shares given with --share, until the
+// server stops. filename may be empty if there are other shares. With
+// serveRoot, each share is a directory and clients choose what to download
+// from it.
+func runServer(cmd *cobra.Command, filename string, serveRoot bool) error {
+	sections, err := applyConfigFile(cmd)
+
+	if err != nil {
+		return err
+	}
+
+	options, generatedPassword, err := readServerOptions(cmd, sections, filename, serveRoot, true, nil)
+
+	if err != nil {
+		return err
+	}
+
+	// hoist serve doesn't have this flag, and always keeps running
 	keepAlive, _ := cmd.Flags().GetBool("keep-alive")
-	skipPassword, _ := cmd.Flags().GetBool("no-password")
-	password, _ := cmd.Flags().GetString("password")
+	progressModeString, _ := cmd.Flags().GetString("progress")
+	quiet, _ := cmd.Flags().GetBool("quiet")
+	jsonOutput, _ := cmd.Flags().GetBool("json")
+	confirm, _ := cmd.Flags().GetBool("confirm")
+	confirmTimeout, _ := cmd.Flags().GetDuration("confirm-timeout")
+
+	progressMode, err := progress.ParseMode(progressModeString)
+
+	if err != nil {
+		return err
+	}
+
+	if quiet {
+		progressMode = progress.ModeNone
+	}
+
+	if jsonOutput {
+		options.Events = events.NewJSONHandler(os.Stdout)
+
+		// Keep the password out of the event stream, which may be logged
+		if generatedPassword {
+			fmt.Fprintf(os.Stderr, "Password: %s\n", options.Password)
+		}
+	} else {
+		console := progress.NewSenderConsole(os.Stdout, progressMode)
+		defer console.Close()
+		options.Events = console
+
+		if generatedPassword {
+			console.ShowPassword(options.Password)
+		}
+
+		if serveRoot {
+			console.ShowServeRoot()
+		}
+	}
+
+	if confirm {
+		options.Approve = newConfirmer().approve
+		options.ApprovalTimeout = confirmTimeout
+	}
+
+	options.KeepAlive = keepAlive || serveRoot
+	sendServer := server.NewServer(*options)
+
+	ctx, cancel := context.WithCancel(cmd.Context())
+	defer cancel()
+
+	// The first Ctrl-C stops accepting new connections and lets active
+	// transfers finish, the second cancels them
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupts)
+
+	go func() {
+		select {
+		case <-interrupts:
+			sendServer.Shutdown()
+		case <-ctx.Done():
+			return
+		}
+
+		select {
+		case <-interrupts:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	_, err = sendServer.Serve(ctx)
+
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("server error: %s", err)
+	}
+
+	return nil
+}
+
+// readServerOptions reads the options for a server sharing filename, the
+// shares given with --share and those in the config file's sections from
+// cmd's flags, once the config file has been applied. If interactive is
+// false, the user is never asked for the password. The options which only
+// matter to an interactive sender, like its output, are left for the caller
+// to set. certificate, if not nil, is presented by --tls without --tls-cert
+// instead of a newly generated one. generatedPassword is true if the password
+// was generated with --generate-password.
+func readServerOptions(cmd *cobra.Command, sections []config.Entry, filename string, serveRoot bool, interactive bool, certificate *tls.Certificate) (options *server.Options, generatedPassword bool, err error) {
+	// hoist serve doesn't have this flag, so it is false
 	followSymlinks, _ := cmd.Flags().GetBool("follow-symlinks")
+	expire, _ := cmd.Flags().GetDuration("expire")
+	maxDownloads, _ := cmd.Flags().GetInt("max-downloads")
+	maxClients, _ := cmd.Flags().GetInt("max-clients")
+	maxAuthFailures, _ := cmd.Flags().GetInt("max-auth-failures")
+	authBackoff, _ := cmd.Flags().GetDuration("auth-backoff")
+	authLockout, _ := cmd.Flags().GetDuration("auth-lockout")
 	port, _ := cmd.Flags().GetString("port")
-	ip, err := util.GetLocalIPAddress()
+	bindAddress, _ := cmd.Flags().GetString("bind")
+	interfaceName, _ := cmd.Flags().GetString("interface")
+	handshakeTimeout, _ := cmd.Flags().GetDuration("handshake-timeout")
+	idleTimeout, _ := cmd.Flags().GetDuration("idle-timeout")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	useTLS, _ := cmd.Flags().GetBool("tls")
+	tlsCertFile, _ := cmd.Flags().GetString("tls-cert")
+	tlsKeyFile, _ := cmd.Flags().GetString("tls-key")
+	tlsClientCAFile, _ := cmd.Flags().GetString("tls-client-ca")
+	trustedOnly, _ := cmd.Flags().GetBool("trusted-only")
+	allowList, _ := cmd.Flags().GetStringSlice("allow")
+	denyList, _ := cmd.Flags().GetStringSlice("deny")
+
+	if bindAddress != "" && interfaceName != "" {
+		return nil, false, fmt.Errorf("only one of --bind and --interface can be used")
+	}
+
+	allow, err := util.ParseNetworks(allowList)
+
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid --allow: %s", err)
+	}
+
+	deny, err := util.ParseNetworks(denyList)
+
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid --deny: %s", err)
+	}
+
+	// Accept "[::1]" as well as "::1"
+	bindAddress = strings.TrimSuffix(strings.TrimPrefix(bindAddress, "["), "]")
+
+	namedShares, err := readNamedShares(cmd, sections)
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to get local IP address: %s\n", err.Error())
+		return nil, false, err
 	}
 
-	filename := filepath.FromSlash(strings.TrimSuffix(args[0], string(filepath.Separator)))
+	if filename == "" && len(namedShares) == 0 {
+		return nil, false, fmt.Errorf("nothing to share, give a file or directory, or use --share NAME=PATH")
+	}
 
-	// Bash doesn't expand "~" if the path is in single or double quotes
-	if strings.HasPrefix(filename, "~") {
-		user, err := user.Current()
+	if filename != "" {
+		filename, err = sharedFilename(filename)
 
 		if err != nil {
-			return fmt.Errorf("failed to expand home directory (~): %s", err)
+			return nil, false, err
 		}
+	}
 
-		filename = filepath.Join(user.HomeDir, filename[1:])
+	// The password is only needed by shares which don't have their own
+	needPassword := filename != ""
+
+	for _, named := range namedShares {
+		if !named.hasPassword {
+			needPassword = true
+		}
 	}
 
-	if !skipPassword && password == "" {
-		fmt.Print("Enter a password: ")
-		passwordBytes, err := terminal.ReadPassword(int(syscall.Stdin))
-		fmt.Println()
+	self, trustedPeers, err := loadIdentity()
 
-		if err != nil {
-			return fmt.Errorf("failed to set password: %s", err)
+	if err != nil {
+		return nil, false, err
+	}
+
+	password := ""
+
+	// Trusted peers don't need a password
+	if trustedOnly {
+		for _, flag := range []string{"no-password", "password", "password-file", "generate-password"} {
+			if cmd.Flags().Changed(flag) {
+				return nil, false, fmt.Errorf("--%s can't be used with --trusted-only", flag)
+			}
+		}
+	} else if needPassword && interactive {
+		password, generatedPassword, err = readPassword(cmd, "Enter a password: ")
+	} else if needPassword {
+		var ok bool
+		password, generatedPassword, ok, err = readPasswordOption(cmd)
+
+		if err == nil && !ok {
+			err = errors.New("no password is set, use password, password-file, no-password or trusted-only")
 		}
+	}
 
-		password = string(passwordBytes)
+	if err != nil {
+		return nil, false, err
 	}
 
-	if len(password) > values.MAX_PASSWORD_LENGTH {
-		return fmt.Errorf("Password length must be %d characters or less", values.MAX_PASSWORD_LENGTH)
+	shares := make([]server.Share, len(namedShares))
+
+	for i, named := range namedShares {
+		shares[i] = named.Share
+		shares[i].ServeRoot = serveRoot
+		shares[i].FollowSymlinks = followSymlinks
+
+		if !named.hasPassword {
+			shares[i].Password = password
+			shares[i].TrustedOnly = trustedOnly
+		}
 	}
 
-	err = server.StartServer(fmt.Sprintf("%s:%s", ip, port), filename, string(password), keepAlive, followSymlinks)
+	tlsCertificate, tlsClientCAs, err := loadServerTLS(useTLS, tlsCertFile, tlsKeyFile, tlsClientCAFile, certificate)
 
 	if err != nil {
-		return fmt.Errorf("server error: %s", err)
+		return nil, false, err
 	}
 
-	return nil
+	return &server.Options{
+		Address:          net.JoinHostPort(bindAddress, port),
+		Interface:        interfaceName,
+		Allow:            allow,
+		Deny:             deny,
+		Identity:         self,
+		TrustedPeers:     trustedPeers,
+		TrustedOnly:      trustedOnly,
+		TLSCertificate:   tlsCertificate,
+		TLSClientCAs:     tlsClientCAs,
+		Filename:         filename,
+		Password:         password,
+		Shares:           shares,
+		Expire:           expire,
+		MaxDownloads:     maxDownloads,
+		MaxClients:       maxClients,
+		MaxAuthFailures:  maxAuthFailures,
+		AuthBackoff:      authBackoff,
+		AuthLockout:      authLockout,
+		FollowSymlinks:   followSymlinks,
+		ServeRoot:        serveRoot,
+		HandshakeTimeout: handshakeTimeout,
+		IdleTimeout:      idleTimeout,
+		Timeout:          timeout,
+	}, generatedPassword, nil
+}
+
+// loadServerTLS works out the certificate the sender should present, if any,
+// and the certificates client certificates must be signed by. A certificate is
+// generated if none is given, unless generated is not nil.
+func loadServerTLS(useTLS bool, certFile string, keyFile string, clientCAFile string, generated *tls.Certificate) (*tls.Certificate, *x509.CertPool, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, nil, fmt.Errorf("--tls-cert and --tls-key must be used together")
+	}
+
+	if !useTLS && certFile == "" && clientCAFile == "" {
+		return nil, nil, nil
+	}
+
+	var certificate *tls.Certificate
+	var clientCAs *x509.CertPool
+	var err error
+
+	if certFile != "" {
+		certificate, err = certs.LoadCertificate(certFile, keyFile)
+	} else if generated != nil {
+		certificate = generated
+	} else {
+		certificate, err = certs.GenerateCertificate()
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if clientCAFile != "" {
+		clientCAs, err = certs.LoadCertPool(clientCAFile)
+
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return certificate, clientCAs, nil
 }
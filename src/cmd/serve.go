@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve [directory]",
+	Short: "Serve a directory over a local area network, letting receivers choose what to download",
+	Long: `Serve a directory over a local area network, letting receivers choose what to download.
+
+Receivers name the file or directory they want after the address, e.g.
+"hoist get 192.168.1.10:47478:projects/alpha", and can see what's available
+with "hoist ls". Paths leading outside the directory, through ".." or
+symbolic links, are refused. The server keeps running until it is stopped
+or one of its limits is reached.
+
+Like hoist send, several directories can be served on the same port with
+--share NAME=PATH, e.g. "hoist ls 192.168.1.10:47478/builds".`,
+	RunE: runServeCmd,
+	Args: cobra.MaximumNArgs(1),
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	addServerFlags(serveCmd)
+}
+
+func runServeCmd(cmd *cobra.Command, args []string) error {
+	root := ""
+
+	if len(args) > 0 {
+		root = args[0]
+	}
+
+	return runServer(cmd, root, true)
+}
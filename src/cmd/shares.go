@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aiden-deloryn/hoist/src/config"
+	"github.com/aiden-deloryn/hoist/src/server"
+	"github.com/aiden-deloryn/hoist/src/share"
+	"github.com/aiden-deloryn/hoist/src/util"
+	"github.com/spf13/cobra"
+)
+
+// namedShare is a share given with --share or in a "[share NAME]" section of
+// the config file.
+type namedShare struct {
+	server.Share
+	// hasPassword is true if the share has its own password, or only
+	// accepts trusted peers, rather than using the command's password
+	hasPassword bool
+	// trustedPeers lets trusted peers skip the share's own password
+	trustedPeers bool
+}
+
+// sharedFilename cleans up the name of a file or directory to share.
+func sharedFilename(filename string) (string, error) {
+	filename = filepath.FromSlash(strings.TrimSuffix(filename, string(filepath.Separator)))
+
+	// Bash doesn't expand "~" if the path is in single or double quotes
+	return expandHome(filename)
+}
+
+// readNamedShares returns the shares given with --share, and in the config
+// file sections, in the order they were first given.
+func readNamedShares(cmd *cobra.Command, sections []config.Entry) ([]*namedShare, error) {
+	configFile, _ := cmd.Flags().GetString("config")
+	shareFlags, _ := cmd.Flags().GetStringArray("share")
+
+	var shares []*namedShare
+	byName := map[string]*namedShare{}
+
+	find := func(name string) (*namedShare, error) {
+		if err := share.CheckShareName(name); err != nil {
+			return nil, err
+		}
+
+		if named, ok := byName[name]; ok {
+			return named, nil
+		}
+
+		named := &namedShare{Share: server.Share{Name: name}}
+		byName[name] = named
+		shares = append(shares, named)
+
+		return named, nil
+	}
+
+	// Paths given on the command line take precedence over the config file
+	fromFlags := map[string]bool{}
+
+	for _, value := range shareFlags {
+		name, path, found := strings.Cut(value, "=")
+
+		if !found || path == "" {
+			return nil, fmt.Errorf("invalid --share %q, expected NAME=PATH", value)
+		}
+
+		named, err := find(name)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid --share: %s", err)
+		}
+
+		if fromFlags[name] {
+			return nil, fmt.Errorf("--share %s is given more than once", name)
+		}
+
+		fromFlags[name] = true
+		named.Filename = path
+	}
+
+	// Only one password setting can be used for each share
+	passwordSettings := map[string]string{}
+
+	for _, entry := range sections {
+		location := fmt.Sprintf("%s:%d", configFile, entry.Line)
+		name := strings.TrimPrefix(entry.Section, "share ")
+
+		if name == entry.Section {
+			return nil, fmt.Errorf("%s: unknown section %q, expected [share NAME]", location, entry.Section)
+		}
+
+		named, err := find(name)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", location, err)
+		}
+
+		if entry.Key == "password" || entry.Key == "password-file" || entry.Key == "no-password" {
+			if previous, ok := passwordSettings[name]; ok && previous != entry.Key {
+				return nil, fmt.Errorf("%s: only one of password, password-file and no-password can be used for a share", location)
+			}
+
+			passwordSettings[name] = entry.Key
+		}
+
+		if err := applyShareSetting(named, entry, fromFlags[name]); err != nil {
+			return nil, fmt.Errorf("%s: %s", location, err)
+		}
+	}
+
+	for _, named := range shares {
+		if named.Filename == "" {
+			return nil, fmt.Errorf("share %q has no path", named.Name)
+		}
+
+		filename, err := sharedFilename(named.Filename)
+
+		if err != nil {
+			return nil, err
+		}
+
+		named.Filename = filename
+
+		// Trusted peers must use a share's own password unless the section
+		// says otherwise
+		named.RequirePassword = named.Password != "" && !named.trustedPeers
+	}
+
+	return shares, nil
+}
+
+// applyShareSetting applies a setting from a "[share NAME]" section of the
+// config file. The path is ignored if pathGiven is true.
+func applyShareSetting(named *namedShare, entry config.Entry, pathGiven bool) error {
+	switch entry.Key {
+	case "path":
+		if !pathGiven {
+			named.Filename = entry.Value
+		}
+	case "password":
+		named.Password = entry.Value
+		named.hasPassword = true
+	case "password-file":
+		password, err := readPasswordFile(entry.Value)
+
+		if err != nil {
+			return err
+		}
+
+		named.Password = password
+		named.hasPassword = true
+	case "trusted-peers":
+		enabled, err := strconv.ParseBool(entry.Value)
+
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %s", entry.Key, err)
+		}
+
+		named.trustedPeers = enabled
+	case "no-password", "trusted-only":
+		enabled, err := strconv.ParseBool(entry.Value)
+
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %s", entry.Key, err)
+		}
+
+		if entry.Key == "trusted-only" {
+			named.TrustedOnly = enabled
+		}
+
+		if enabled {
+			named.Password = ""
+			named.hasPassword = true
+		}
+	case "allow", "deny":
+		networks, err := util.ParseNetworks(strings.Split(entry.Value, ","))
+
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %s", entry.Key, err)
+		}
+
+		if entry.Key == "allow" {
+			named.Allow = append(named.Allow, networks...)
+		} else {
+			named.Deny = append(named.Deny, networks...)
+		}
+	default:
+		return fmt.Errorf("unknown share setting %q, expected path, password, password-file, no-password, trusted-only, trusted-peers, allow or deny", entry.Key)
+	}
+
+	return nil
+}
@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aiden-deloryn/hoist/src/identity"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// trustCmd represents the trust command
+var trustCmd = &cobra.Command{
+	Use:   "trust",
+	Short: "Manage the peers which can receive files without a password",
+	Long: `Manage the peers which can receive files without a password.
+
+Every hoist install has its own identity key. Senders let trusted peers
+download without a password, and only peers added with "hoist trust add" are
+trusted. Receivers keep a separate list of the senders they have accepted,
+to check that those senders still have the same key. Accepting a sender
+never lets it download from you.`,
+}
+
+var trustAddCmd = &cobra.Command{
+	Use:   "add [name] [public key]",
+	Short: "Trust a peer's public key",
+	RunE:  runTrustAddCmd,
+	Args:  cobra.ExactArgs(2),
+}
+
+var trustListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List trusted peers, or with --senders, the senders accepted by hoist get",
+	RunE:  runTrustListCmd,
+	Args:  cobra.NoArgs,
+}
+
+var trustRemoveCmd = &cobra.Command{
+	Use:   "remove [name]",
+	Short: "Stop trusting a peer, and forget it as a sender",
+	RunE:  runTrustRemoveCmd,
+	Args:  cobra.ExactArgs(1),
+}
+
+var trustSelfCmd = &cobra.Command{
+	Use:   "self",
+	Short: "Display this computer's name and public key, for adding to other computers",
+	RunE:  runTrustSelfCmd,
+	Args:  cobra.NoArgs,
+}
+
+func init() {
+	rootCmd.AddCommand(trustCmd)
+	trustCmd.AddCommand(trustAddCmd)
+	trustCmd.AddCommand(trustListCmd)
+	trustCmd.AddCommand(trustRemoveCmd)
+	trustCmd.AddCommand(trustSelfCmd)
+
+	trustListCmd.Flags().Bool("senders", false, "List the senders accepted by hoist get instead of the trusted peers")
+}
+
+func runTrustAddCmd(cmd *cobra.Command, args []string) error {
+	key, err := identity.ParsePublicKey(args[1])
+
+	if err != nil {
+		return err
+	}
+
+	_, store, err := loadIdentity()
+
+	if err != nil {
+		return err
+	}
+
+	return store.Add(args[0], key)
+}
+
+func runTrustListCmd(cmd *cobra.Command, args []string) error {
+	senders, _ := cmd.Flags().GetBool("senders")
+	_, store, err := loadIdentity()
+
+	if err == nil && senders {
+		store, err = loadKnownSenders()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, peer := range store.Peers() {
+		fmt.Printf("%s %s\n", peer.Name, identity.FormatPublicKey(peer.PublicKey))
+	}
+
+	return nil
+}
+
+func runTrustRemoveCmd(cmd *cobra.Command, args []string) error {
+	_, store, err := loadIdentity()
+
+	if err != nil {
+		return err
+	}
+
+	knownSenders, err := loadKnownSenders()
+
+	if err != nil {
+		return err
+	}
+
+	trusted, err := store.Remove(args[0])
+
+	if err != nil {
+		return err
+	}
+
+	known, err := knownSenders.Remove(args[0])
+
+	if err != nil {
+		return err
+	}
+
+	if !trusted && !known {
+		return fmt.Errorf("no peer named %q is trusted or known as a sender", args[0])
+	}
+
+	return nil
+}
+
+func runTrustSelfCmd(cmd *cobra.Command, args []string) error {
+	self, _, err := loadIdentity()
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s %s\n", self.Name, identity.FormatPublicKey(self.PublicKey()))
+
+	return nil
+}
+
+// loadIdentity returns this computer's identity, creating it if needed, and
+// its trusted peers.
+func loadIdentity() (*identity.Identity, *identity.TrustStore, error) {
+	directory, err := identity.DefaultDirectory()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	self, err := identity.LoadOrCreate(directory)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	store, err := identity.LoadTrustStore(directory)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return self, store, nil
+}
+
+// loadKnownSenders returns the senders the user has accepted with hoist get.
+func loadKnownSenders() (*identity.TrustStore, error) {
+	directory, err := identity.DefaultDirectory()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return identity.LoadKnownSenders(directory)
+}
+
+// verifySender returns a function which checks a sender's identity against
+// the trusted peers and the known senders. Senders which are seen for the
+// first time are added to the known senders if the user agrees, but never
+// to the trusted peers, which may download without a password. Senders
+// whose key has changed are refused.
+func verifySender(trustedPeers *identity.TrustStore, knownSenders *identity.TrustStore) func(peer identity.Peer) error {
+	return func(peer identity.Peer) error {
+		key := identity.FormatPublicKey(peer.PublicKey)
+		status, name := trustedPeers.Check(peer.Name, peer.PublicKey)
+
+		if status == identity.Unknown {
+			status, name = knownSenders.Check(peer.Name, peer.PublicKey)
+		}
+
+		switch status {
+		case identity.Trusted:
+			return nil
+		case identity.NameChanged:
+			fmt.Fprintf(os.Stderr, "Warning: the sender now calls itself %q, but its key was accepted as %q\n", peer.Name, name)
+			return nil
+		case identity.KeyChanged:
+			fmt.Fprintf(os.Stderr, "@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\n")
+			fmt.Fprintf(os.Stderr, "@       WARNING: THE SENDER'S IDENTITY HAS CHANGED!       @\n")
+			fmt.Fprintf(os.Stderr, "@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\n")
+			fmt.Fprintf(os.Stderr, "The sender claims to be %q, but its key is different from the one you trusted:\n", name)
+			fmt.Fprintf(os.Stderr, "  %s\n", key)
+			fmt.Fprintf(os.Stderr, "Someone could be impersonating it. If hoist was reinstalled on the sender, remove the old key with:\n")
+			fmt.Fprintf(os.Stderr, "  hoist trust remove %s\n", name)
+			return fmt.Errorf("the identity key of %q has changed", name)
+		}
+
+		// Without a terminal there is nobody to ask
+		if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+			fmt.Fprintf(os.Stderr, "Warning: the sender %q is not a trusted peer, its key is %s\n", peer.Name, key)
+			return nil
+		}
+
+		fmt.Fprintf(os.Stderr, "The sender identifies itself as %q with the key:\n", peer.Name)
+		fmt.Fprintf(os.Stderr, "  %s\n", key)
+		fmt.Fprintf(os.Stderr, "This key has not been seen before. Accept it and remember it as %q? [y/N] ", peer.Name)
+		answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+
+		if err != nil {
+			return fmt.Errorf("failed to read answer: %s", err)
+		}
+
+		if answer := strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			return errors.New("the sender's identity was not accepted")
+		}
+
+		return knownSenders.Add(peer.Name, peer.PublicKey)
+	}
+}
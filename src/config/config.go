@@ -0,0 +1,77 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Entry is a single setting of a config file.
+type Entry struct {
+	// Section is the name of the section the entry is in, e.g. "share docs"
+	// after a "[share docs]" line, or empty before the first section
+	Section string
+	Key     string
+	Value   string
+	// Line is the line number the entry was read from, for error messages
+	Line int
+}
+
+// Load reads a config file. Files ending in .yaml or .yml are read with
+// LoadYAML, and others are made up of "key = value" lines, which may be split
+// into sections by "[name]" lines. Blank lines and lines starting with '#'
+// are ignored. Keys may be repeated, and the entries are returned in the
+// order they appear.
+func Load(filename string) ([]Entry, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return LoadYAML(filename)
+	}
+
+	file, err := os.Open(filename)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %s", err)
+	}
+
+	defer file.Close()
+
+	var entries []Entry
+	section := ""
+	scanner := bufio.NewScanner(file)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			section = strings.Join(strings.Fields(text[1:len(text)-1]), " ")
+
+			if section == "" {
+				return nil, fmt.Errorf("%s:%d: expected a section name", filename, line)
+			}
+
+			continue
+		}
+
+		key, value, found := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+
+		if !found || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected \"key = value\"", filename, line)
+		}
+
+		entries = append(entries, Entry{Section: section, Key: key, Value: strings.TrimSpace(value), Line: line})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read config file: %s", err)
+	}
+
+	return entries, nil
+}
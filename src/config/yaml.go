@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadYAML reads a YAML config file, which maps each key to a value or a list
+// of values. Each share under the "shares" key maps its settings in the same
+// way, and its entries are returned in the section "share NAME", e.g.
+//
+//	port: 7000
+//	allow: [192.168.1.0/24]
+//	shares:
+//	  docs:
+//	    path: /srv/docs
+//
+// A list gives one entry for each of its values.
+func LoadYAML(filename string) ([]Entry, error) {
+	data, err := os.ReadFile(filename)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %s", err)
+	}
+
+	var document yaml.Node
+
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	// An empty file has no content
+	if len(document.Content) == 0 {
+		return nil, nil
+	}
+
+	root := document.Content[0]
+
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d: expected \"key: value\" settings", filename, root.Line)
+	}
+
+	var entries []Entry
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+
+		if key.Value != "shares" {
+			found, err := yamlEntries(filename, "", key, value)
+
+			if err != nil {
+				return nil, err
+			}
+
+			entries = append(entries, found...)
+			continue
+		}
+
+		if value.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%s:%d: expected the settings of each share under its name", filename, value.Line)
+		}
+
+		for j := 0; j+1 < len(value.Content); j += 2 {
+			name, settings := value.Content[j], value.Content[j+1]
+
+			if settings.Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("%s:%d: expected \"key: value\" settings for share %q", filename, settings.Line, name.Value)
+			}
+
+			for k := 0; k+1 < len(settings.Content); k += 2 {
+				found, err := yamlEntries(filename, "share "+name.Value, settings.Content[k], settings.Content[k+1])
+
+				if err != nil {
+					return nil, err
+				}
+
+				entries = append(entries, found...)
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+// yamlEntries returns the entries for a key and its value, which is either a
+// single value or a list of them.
+func yamlEntries(filename string, section string, key *yaml.Node, value *yaml.Node) ([]Entry, error) {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		return []Entry{{Section: section, Key: key.Value, Value: value.Value, Line: key.Line}}, nil
+	case yaml.SequenceNode:
+		var entries []Entry
+
+		for _, item := range value.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("%s:%d: expected a list of values for %s", filename, item.Line, key.Value)
+			}
+
+			entries = append(entries, Entry{Section: section, Key: key.Value, Value: item.Value, Line: item.Line})
+		}
+
+		return entries, nil
+	default:
+		return nil, fmt.Errorf("%s:%d: expected a value or a list of values for %s", filename, value.Line, key.Value)
+	}
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadYAML(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "hoist.yaml")
+	config := `port: 7000
+allow: [10.0.0.0/8, 192.168.1.0/24]
+keep-alive: true
+shares:
+  docs:
+    path: /srv/docs
+    deny:
+      - 10.0.5.0/24
+`
+
+	if err := os.WriteFile(filename, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := Load(filename)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Entry{
+		{Key: "port", Value: "7000", Line: 1},
+		{Key: "allow", Value: "10.0.0.0/8", Line: 2},
+		{Key: "allow", Value: "192.168.1.0/24", Line: 2},
+		{Key: "keep-alive", Value: "true", Line: 3},
+		{Section: "share docs", Key: "path", Value: "/srv/docs", Line: 6},
+		{Section: "share docs", Key: "deny", Value: "10.0.5.0/24", Line: 8},
+	}
+
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("got %+v, want %+v", entries, expected)
+	}
+}
+
+func TestLoadYAMLErrors(t *testing.T) {
+	tests := []struct {
+		description string
+		config      string
+	}{
+		{"not a mapping", "- port\n"},
+		{"nested setting", "port:\n  number: 7000\n"},
+		{"list of lists", "allow: [[10.0.0.0/8]]\n"},
+		{"share without settings", "shares:\n  docs: /srv/docs\n"},
+		{"invalid yaml", "port: [7000\n"},
+	}
+
+	for _, test := range tests {
+		filename := filepath.Join(t.TempDir(), "hoist.yml")
+
+		if err := os.WriteFile(filename, []byte(test.config), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := Load(filename); err == nil {
+			t.Errorf("%s: expected an error", test.description)
+		}
+	}
+}
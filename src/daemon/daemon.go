@@ -0,0 +1,218 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/aiden-deloryn/hoist/src/server"
+	"github.com/aiden-deloryn/hoist/src/systemd"
+)
+
+// Options configures a Daemon.
+type Options struct {
+	// Load reads the configuration and returns the options for a new
+	// server. It is called when the daemon starts, and again each time it
+	// is reloaded. The server's listener, events and KeepAlive are set by
+	// the daemon
+	Load func() (*server.Options, error)
+	// Listener, if not nil, is used instead of listening on the address
+	// returned by Load, e.g. a socket passed in by systemd. It is closed
+	// when the daemon stops
+	Listener net.Listener
+	// Logger receives the server's events, and messages about the daemon
+	Logger Logger
+}
+
+// Daemon runs a server until it is stopped, replacing it with a new one
+// each time its configuration is reloaded. The old server stops accepting
+// connections, but carries on with its active transfers until they finish.
+// systemd is told when the daemon is ready, reloading or stopping.
+type Daemon struct {
+	options  Options
+	listener *sharedListener
+	// address is the address the daemon listens on, or empty if it was
+	// given a listener
+	address  string
+	servers  sync.WaitGroup
+	reloads  chan struct{}
+	stopping chan struct{}
+	once     sync.Once
+}
+
+// instance is a running server, and done receives the error returned by its
+// Serve method.
+type instance struct {
+	server *server.Server
+	done   chan error
+}
+
+func New(options Options) *Daemon {
+	return &Daemon{
+		options:  options,
+		reloads:  make(chan struct{}, 1),
+		stopping: make(chan struct{}),
+	}
+}
+
+// Run starts the server and keeps it running until Shutdown is called, or
+// the server stops by itself, e.g. because it expired. Run returns once
+// every active transfer has finished. Cancelling ctx aborts them
+// immediately.
+func (this *Daemon) Run(ctx context.Context) error {
+	options, err := this.options.Load()
+
+	if err != nil {
+		return err
+	}
+
+	listener := this.options.Listener
+
+	if listener == nil {
+		listener, err = net.Listen("tcp", options.Address)
+
+		if err != nil {
+			return fmt.Errorf("failed to start TCP server: %s", err)
+		}
+
+		this.address = options.Address
+	}
+
+	this.listener = newSharedListener(listener)
+	current, err := this.start(ctx, options, nil)
+
+	if err != nil {
+		this.listener.Close()
+		return err
+	}
+
+	this.notify("READY=1")
+
+	for {
+		select {
+		case <-this.reloads:
+			this.notify("RELOADING=1")
+			next, err := this.reload(ctx, current)
+
+			if err != nil {
+				this.options.Logger.Log(PriorityError, fmt.Sprintf("Failed to reload the configuration, still using the old one: %s", err))
+			} else {
+				current = next
+			}
+
+			this.notify("READY=1")
+		case <-this.stopping:
+			this.notify("STOPPING=1")
+			this.options.Logger.Log(PriorityInfo, fmt.Sprintf("Stopping, waiting for %d active connection(s) to finish", current.server.ActiveConnections()))
+			current.server.Shutdown()
+
+			return this.stop(nil)
+		case err := <-current.done:
+			// The server reached one of its limits
+			this.notify("STOPPING=1")
+
+			return this.stop(err)
+		}
+	}
+}
+
+// Reload reads the configuration again and replaces the server with a new
+// one. If the configuration is invalid, the error is logged and the old
+// server carries on.
+func (this *Daemon) Reload() {
+	select {
+	case this.reloads <- struct{}{}:
+	default:
+		// A reload is already waiting
+	}
+}
+
+// Shutdown stops the daemon from accepting new connections. Run returns once
+// the active transfers have finished.
+func (this *Daemon) Shutdown() {
+	this.once.Do(func() {
+		close(this.stopping)
+	})
+}
+
+// start starts a server with options on a new handle of the shared
+// listener. If previous is not nil, the new server inherits its auth
+// failures and download counts.
+func (this *Daemon) start(ctx context.Context, options *server.Options, previous *instance) (*instance, error) {
+	handle := this.listener.handle()
+	options.Listener = handle
+	options.KeepAlive = true
+	options.Events = logEvents(this.options.Logger)
+
+	running := &instance{
+		server: server.NewServer(*options),
+		done:   make(chan error, 1),
+	}
+
+	if previous != nil {
+		running.server.Inherit(previous.server)
+	}
+
+	if err := running.server.Listen(); err != nil {
+		handle.Close()
+		return nil, err
+	}
+
+	this.servers.Add(1)
+
+	go func() {
+		defer this.servers.Done()
+
+		_, err := running.server.Serve(ctx)
+		running.done <- err
+	}()
+
+	return running, nil
+}
+
+// reload starts a server with the new configuration, then shuts down
+// current, which finishes its active transfers in the background.
+func (this *Daemon) reload(ctx context.Context, current *instance) (*instance, error) {
+	options, err := this.options.Load()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if this.address != "" && options.Address != this.address {
+		this.options.Logger.Log(PriorityWarning, fmt.Sprintf("Still listening on %s, restart the daemon to listen on %s", this.address, options.Address))
+	}
+
+	next, err := this.start(ctx, options, current)
+
+	if err != nil {
+		return nil, err
+	}
+
+	active := current.server.ActiveConnections()
+	current.server.Shutdown()
+
+	this.options.Logger.Log(PriorityInfo, fmt.Sprintf("Reloaded the configuration, %d active connection(s) will finish with the old one", active))
+
+	return next, nil
+}
+
+// stop closes the listener and waits for every server to finish.
+func (this *Daemon) stop(err error) error {
+	this.listener.Close()
+	this.servers.Wait()
+
+	if err == context.Canceled {
+		return nil
+	}
+
+	return err
+}
+
+// notify tells systemd about the daemon's state, logging any failure.
+func (this *Daemon) notify(state string) {
+	if err := systemd.Notify(state); err != nil {
+		this.options.Logger.Log(PriorityWarning, err.Error())
+	}
+}
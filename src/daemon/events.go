@@ -0,0 +1,95 @@
+package daemon
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aiden-deloryn/hoist/src/events"
+	"github.com/aiden-deloryn/hoist/src/identity"
+	"github.com/aiden-deloryn/hoist/src/progress"
+	"github.com/aiden-deloryn/hoist/src/util"
+)
+
+// logEvents returns a handler which writes the server's events to logger.
+// Events about the progress of each file are left out.
+func logEvents(logger Logger) events.Handler {
+	return events.HandlerFunc(func(event events.Event) {
+		peer := progress.DescribePeer(event.Address, identity.Peer{Name: event.Peer, User: event.User}, event.Trusted)
+
+		switch event.Type {
+		case events.Listening:
+			message := "Listening on " + strings.Join(event.Addresses, ", ")
+
+			if len(event.Shares) > 0 {
+				message += " with shares " + describeShares(event.Shares)
+			}
+
+			if event.Fingerprint != "" {
+				message += ", TLS fingerprint " + event.Fingerprint
+			}
+
+			logger.Log(PriorityInfo, message)
+		case events.ClientRejected:
+			logger.Log(PriorityWarning, fmt.Sprintf("Rejected connection from %s: %s", event.Address, event.Message))
+		case events.AuthFailed:
+			logger.Log(PriorityWarning, fmt.Sprintf("Authentication failed for %s: %s", event.Address, event.Message))
+		case events.TransferStarted:
+			what := "file(s)"
+
+			if requested := progress.DescribeRequested(event); requested != "" {
+				what = requested
+			}
+
+			logger.Log(PriorityInfo, fmt.Sprintf("Sending %s to %s (%s)", what, peer, util.FormatByteSize(event.TotalBytes)))
+		case events.TransferDeclined:
+			logger.Log(PriorityInfo, fmt.Sprintf("%s declined the transfer", event.Address))
+		case events.ShareListed:
+			where := ""
+
+			if requested := progress.DescribeRequested(event); requested != "" {
+				where = " in " + requested
+			}
+
+			logger.Log(PriorityInfo, fmt.Sprintf("%s listed %s%s", peer, progress.DescribeFiles(event.FileCount, event.TotalBytes), where))
+		case events.Summary:
+			duration := progress.FormatDuration(time.Duration(event.DurationMs) * time.Millisecond)
+			logger.Log(PriorityInfo, fmt.Sprintf("Sent %s to %s in %s", progress.DescribeFiles(event.FileCount, event.TotalBytes), event.Address, duration))
+		case events.Warning:
+			logger.Log(PriorityWarning, describeClientMessage(event))
+		case events.Error:
+			logger.Log(PriorityError, describeClientMessage(event))
+		case events.ShuttingDown:
+			// The daemon reports being stopped or reloaded itself
+			if event.Message != "" {
+				logger.Log(PriorityInfo, fmt.Sprintf("Stopping, %s", event.Message))
+			}
+		}
+	})
+}
+
+// describeClientMessage returns the message of a warning or error, along
+// with the client it is about, if any.
+func describeClientMessage(event events.Event) string {
+	if event.Address == "" {
+		return event.Message
+	}
+
+	return fmt.Sprintf("%s: %s", event.Address, event.Message)
+}
+
+// describeShares lists the names of shares, calling the unnamed share
+// "(unnamed)".
+func describeShares(names []string) string {
+	described := make([]string, len(names))
+
+	for i, name := range names {
+		described[i] = name
+
+		if name == "" {
+			described[i] = "(unnamed)"
+		}
+	}
+
+	return strings.Join(described, ", ")
+}
@@ -0,0 +1,86 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Priority is the importance of a log message, using the syslog levels.
+type Priority int
+
+const (
+	PriorityError   Priority = 3
+	PriorityWarning Priority = 4
+	PriorityInfo    Priority = 6
+)
+
+// Logger writes messages to a log. It may be called from several goroutines
+// at once.
+type Logger interface {
+	Log(priority Priority, message string)
+}
+
+// Where log messages can be written.
+const (
+	LogAuto     = "auto"
+	LogStderr   = "stderr"
+	LogJournald = "journald"
+	LogSyslog   = "syslog"
+)
+
+// NewLogger returns a Logger which writes to target, one of LogAuto,
+// LogStderr, LogJournald or LogSyslog. LogAuto uses journald when stderr is
+// connected to the journal, e.g. when started by systemd, and otherwise
+// writes to stderr.
+func NewLogger(target string) (Logger, error) {
+	if target == LogAuto && os.Getenv("JOURNAL_STREAM") != "" {
+		target = LogJournald
+	}
+
+	switch target {
+	case LogAuto, LogStderr:
+		return &streamLogger{out: os.Stderr}, nil
+	case LogJournald:
+		return &streamLogger{out: os.Stderr, journald: true}, nil
+	case LogSyslog:
+		return newSyslogLogger()
+	}
+
+	return nil, fmt.Errorf("invalid log target %q, expected auto, stderr, journald or syslog", target)
+}
+
+// streamLogger writes one line for each message. journald reads the priority
+// of each line from a "<N>" prefix, and adds its own timestamps.
+type streamLogger struct {
+	mutex    sync.Mutex
+	out      io.Writer
+	journald bool
+}
+
+func (this *streamLogger) Log(priority Priority, message string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	// Keep each message on a single line
+	message = strings.ReplaceAll(message, "\n", " ")
+
+	if this.journald {
+		fmt.Fprintf(this.out, "<%d>%s\n", priority, message)
+		return
+	}
+
+	level := ""
+
+	switch priority {
+	case PriorityError:
+		level = "error: "
+	case PriorityWarning:
+		level = "warning: "
+	}
+
+	fmt.Fprintf(this.out, "%s %s%s\n", time.Now().Format("2006-01-02 15:04:05"), level, message)
+}
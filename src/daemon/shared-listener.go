@@ -0,0 +1,98 @@
+package daemon
+
+import (
+	"net"
+	"sync"
+)
+
+// sharedListener accepts connections on a listener and hands each one to
+// whichever of its handles asks for one next. Servers are given handles
+// rather than the listener itself, so a server can be replaced by another
+// without closing the socket, and without refusing any connections.
+type sharedListener struct {
+	listener net.Listener
+	accepted chan acceptResult
+	closed   chan struct{}
+	once     sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+func newSharedListener(listener net.Listener) *sharedListener {
+	shared := &sharedListener{
+		listener: listener,
+		accepted: make(chan acceptResult),
+		closed:   make(chan struct{}),
+	}
+
+	go shared.run()
+
+	return shared
+}
+
+func (this *sharedListener) run() {
+	for {
+		conn, err := this.listener.Accept()
+
+		select {
+		case this.accepted <- acceptResult{conn: conn, err: err}:
+		case <-this.closed:
+			if conn != nil {
+				conn.Close()
+			}
+
+			return
+		}
+	}
+}
+
+// handle returns a new listener which accepts connections from the shared
+// listener until it is closed. Closing it leaves the shared listener open.
+func (this *sharedListener) handle() net.Listener {
+	return &listenerHandle{shared: this, closed: make(chan struct{})}
+}
+
+// Close stops accepting connections, closing the socket.
+func (this *sharedListener) Close() error {
+	var err error
+
+	this.once.Do(func() {
+		close(this.closed)
+		err = this.listener.Close()
+	})
+
+	return err
+}
+
+// listenerHandle is one server's view of a sharedListener.
+type listenerHandle struct {
+	shared *sharedListener
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (this *listenerHandle) Accept() (net.Conn, error) {
+	select {
+	case result := <-this.shared.accepted:
+		return result.conn, result.err
+	case <-this.closed:
+		return nil, net.ErrClosed
+	case <-this.shared.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (this *listenerHandle) Close() error {
+	this.once.Do(func() {
+		close(this.closed)
+	})
+
+	return nil
+}
+
+func (this *listenerHandle) Addr() net.Addr {
+	return this.shared.listener.Addr()
+}
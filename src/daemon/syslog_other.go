@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package daemon
+
+import "errors"
+
+func newSyslogLogger() (Logger, error) {
+	return nil, errors.New("syslog isn't available on this platform, use --log stderr")
+}
@@ -0,0 +1,37 @@
+//go:build !windows && !plan9
+
+package daemon
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+
+	"github.com/aiden-deloryn/hoist/src/values"
+)
+
+// syslogLogger writes to the local syslog daemon.
+type syslogLogger struct {
+	writer *syslog.Writer
+}
+
+func newSyslogLogger() (Logger, error) {
+	writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, strings.ToLower(values.APP_NAME))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %s", err)
+	}
+
+	return &syslogLogger{writer: writer}, nil
+}
+
+func (this *syslogLogger) Log(priority Priority, message string) {
+	switch priority {
+	case PriorityError:
+		this.writer.Err(message)
+	case PriorityWarning:
+		this.writer.Warning(message)
+	default:
+		this.writer.Info(message)
+	}
+}
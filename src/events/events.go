@@ -0,0 +1,99 @@
+package events
+
+import (
+	"time"
+)
+
+type Type string
+
+// The event types reported by the server and the client. The JSON
+// representation of each event is documented in docs/json-events.md and
+// must not change in a backwards incompatible way.
+const (
+	Listening        Type = "listening"
+	ClientConnected  Type = "client_connected"
+	ClientRejected   Type = "client_rejected"
+	AuthFailed       Type = "auth_failed"
+	AwaitingApproval Type = "awaiting_approval"
+	TransferStarted  Type = "transfer_started"
+	TransferDeclined Type = "transfer_declined"
+	ShareListed      Type = "share_listed"
+	FileStarted      Type = "file_started"
+	Progress         Type = "progress"
+	FileDone         Type = "file_done"
+	SymlinkCreated   Type = "symlink_created"
+	Warning          Type = "warning"
+	Error            Type = "error"
+	Summary          Type = "summary"
+	ShuttingDown     Type = "shutting_down"
+)
+
+// Event describes something that happened during a transfer. Only the fields
+// relevant to the event's type are set, the rest are left empty. Numeric
+// fields and Trusted are marshalled even when zero, so that e.g. an empty
+// file still has a size, which means they are only meaningful for the event
+// types docs/json-events.md lists them under.
+type Event struct {
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+	// Address is the listening address for Listening events, the address of
+	// the client for other events reported by the server, and the address of
+	// the server for events reported by the client
+	Address string `json:"address,omitempty"`
+	// Addresses lists every address clients can use to reach the server
+	Addresses []string `json:"addresses,omitempty"`
+	// Fingerprint is the SHA-256 fingerprint of the server's TLS certificate
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Shares lists the names of the server's named shares, and Share is the
+	// name of the share a client asked for
+	Shares []string `json:"shares,omitempty"`
+	Share  string   `json:"share,omitempty"`
+	// File is the name of the file relative to the root of the transfer,
+	// always using '/' as the path separator
+	File string `json:"file,omitempty"`
+	// Path is where the client is writing the file or symlink on disk
+	Path string `json:"path,omitempty"`
+	// Target is the target of a symlink
+	Target string `json:"target,omitempty"`
+	// Size is the size of File in bytes
+	Size int64 `json:"size"`
+	// Bytes is the number of bytes of File transferred so far
+	Bytes int64 `json:"bytes"`
+	// FileCount and TotalBytes describe the whole transfer
+	FileCount  int64 `json:"fileCount"`
+	TotalBytes int64 `json:"totalBytes"`
+	// SHA256 is the hex encoded checksum of File
+	SHA256     string `json:"sha256,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+	// Peer, User and PublicKey are the name, user name and identity key of
+	// the other side of the connection, or of the server itself for
+	// Listening events. Trusted is true if the peer is a trusted peer
+	Peer      string `json:"peer,omitempty"`
+	User      string `json:"user,omitempty"`
+	PublicKey string `json:"publicKey,omitempty"`
+	Trusted   bool   `json:"trusted"`
+	// Connections is the number of clients connected to the server
+	Connections int64  `json:"connections"`
+	Message     string `json:"message,omitempty"`
+}
+
+// Handler receives events as they happen. Handlers used by the server may
+// be called from several goroutines at once.
+type Handler interface {
+	HandleEvent(event Event)
+}
+
+// HandlerFunc allows an ordinary function to be used as a Handler.
+type HandlerFunc func(event Event)
+
+func (this HandlerFunc) HandleEvent(event Event) {
+	this(event)
+}
+
+// Discard is a Handler which ignores every event.
+var Discard Handler = HandlerFunc(func(event Event) {})
+
+// Duration converts d into the value used for Event.DurationMs.
+func Duration(d time.Duration) int64 {
+	return d.Milliseconds()
+}
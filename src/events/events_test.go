@@ -0,0 +1,25 @@
+package events
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestEventKeepsZeroValues(t *testing.T) {
+	data, err := json.Marshal(Event{Type: FileDone, File: "empty.txt"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, field := range []string{`"size":0`, `"bytes":0`, `"fileCount":0`, `"totalBytes":0`, `"durationMs":0`, `"connections":0`, `"trusted":false`} {
+		if !strings.Contains(string(data), field) {
+			t.Errorf("%s is missing %s", data, field)
+		}
+	}
+
+	if strings.Contains(string(data), `"path"`) {
+		t.Errorf("%s includes an empty path", data)
+	}
+}
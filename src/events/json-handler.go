@@ -0,0 +1,47 @@
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Only report the progress of each file this often, so scripts aren't
+// flooded with an event for every chunk that is copied
+const jsonProgressInterval = time.Second
+
+// JSONHandler writes each event to a writer as a single line of JSON.
+type JSONHandler struct {
+	mutex        sync.Mutex
+	encoder      *json.Encoder
+	lastProgress map[string]time.Time
+}
+
+func NewJSONHandler(out io.Writer) *JSONHandler {
+	return &JSONHandler{
+		encoder:      json.NewEncoder(out),
+		lastProgress: map[string]time.Time{},
+	}
+}
+
+func (this *JSONHandler) HandleEvent(event Event) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	key := event.Address + "\x00" + event.File
+
+	switch event.Type {
+	case Progress:
+		if event.Time.Sub(this.lastProgress[key]) < jsonProgressInterval {
+			return
+		}
+
+		this.lastProgress[key] = event.Time
+	case FileDone:
+		delete(this.lastProgress, key)
+	}
+
+	// There is nowhere left to report a failure to write an event
+	this.encoder.Encode(event)
+}
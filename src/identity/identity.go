@@ -0,0 +1,180 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/aiden-deloryn/hoist/src/values"
+)
+
+// PUBLIC_KEY_PREFIX starts the text form of every public key.
+const PUBLIC_KEY_PREFIX = "ed25519:"
+
+// Identity is the long-lived key pair which identifies a hoist install to
+// its peers.
+type Identity struct {
+	// Name is shown to peers so they can tell who they are talking to,
+	// usually the host name
+	Name string
+	// User is the name of the user running hoist, shown to peers alongside
+	// Name. It may be empty.
+	User       string
+	PrivateKey ed25519.PrivateKey
+}
+
+// Generate creates a new identity with a random key.
+func Generate(name string) (*Identity, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate identity key: %s", err)
+	}
+
+	return &Identity{Name: name, PrivateKey: privateKey}, nil
+}
+
+// LoadOrCreate reads the identity key stored in directory, creating one the
+// first time it is called. The identity is named after this computer and the
+// current user.
+func LoadOrCreate(directory string) (*Identity, error) {
+	self, err := load(directory)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if current, err := user.Current(); err == nil && ValidateUser(current.Username) == nil {
+		self.User = current.Username
+	}
+
+	return self, nil
+}
+
+func load(directory string) (*Identity, error) {
+	name, err := os.Hostname()
+
+	if err != nil || ValidateName(name) != nil {
+		name = values.APP_NAME
+	}
+
+	filename := filepath.Join(directory, "identity")
+	data, err := os.ReadFile(filename)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return create(filename, name)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity: %s", err)
+	}
+
+	block, _ := pem.Decode(data)
+
+	if block == nil {
+		return nil, fmt.Errorf("failed to read identity: %s is not PEM encoded", filename)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity: %s", err)
+	}
+
+	privateKey, ok := key.(ed25519.PrivateKey)
+
+	if !ok {
+		return nil, fmt.Errorf("failed to read identity: %s is not an Ed25519 key", filename)
+	}
+
+	return &Identity{Name: name, PrivateKey: privateKey}, nil
+}
+
+func create(filename string, name string) (*Identity, error) {
+	identity, err := Generate(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(identity.PrivateKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode identity: %s", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create config directory: %s", err)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	// O_EXCL stops two instances started at once from overwriting each
+	// other's key
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+
+	if errors.Is(err, os.ErrExist) {
+		return load(filepath.Dir(filename))
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to save identity: %s", err)
+	}
+
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to save identity: %s", err)
+	}
+
+	return identity, nil
+}
+
+func (this *Identity) PublicKey() ed25519.PublicKey {
+	return this.PrivateKey.Public().(ed25519.PublicKey)
+}
+
+// DefaultDirectory returns where the identity and trusted peers are stored,
+// which is $HOIST_CONFIG_DIR if set, or a "hoist" directory inside the
+// user's configuration directory.
+func DefaultDirectory() (string, error) {
+	if directory := os.Getenv(values.CONFIG_DIR_ENV_VAR); directory != "" {
+		return directory, nil
+	}
+
+	configDirectory, err := os.UserConfigDir()
+
+	if err != nil {
+		return "", fmt.Errorf("failed to find config directory: %s", err)
+	}
+
+	return filepath.Join(configDirectory, "hoist"), nil
+}
+
+// FormatPublicKey returns the text form of key, e.g. "ed25519:Lk3...".
+func FormatPublicKey(key ed25519.PublicKey) string {
+	return PUBLIC_KEY_PREFIX + base64.RawURLEncoding.EncodeToString(key)
+}
+
+// ParsePublicKey parses a key in the form returned by FormatPublicKey.
+func ParsePublicKey(text string) (ed25519.PublicKey, error) {
+	if !strings.HasPrefix(text, PUBLIC_KEY_PREFIX) {
+		return nil, fmt.Errorf("invalid public key %q, expected it to start with %q", text, PUBLIC_KEY_PREFIX)
+	}
+
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(text, PUBLIC_KEY_PREFIX))
+
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key %q", text)
+	}
+
+	return ed25519.PublicKey(key), nil
+}
@@ -0,0 +1,257 @@
+package identity
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TrustStatus describes what the trust store knows about a peer.
+type TrustStatus int
+
+const (
+	// Unknown means neither the peer's name nor its key have been seen
+	Unknown TrustStatus = iota
+	// Trusted means the peer's key is in the store
+	Trusted
+	// KeyChanged means a different key is stored under the peer's name,
+	// so the peer may be an impostor
+	KeyChanged
+	// NameChanged means the peer's key is in the store, but under a
+	// different name than the one the peer gave
+	NameChanged
+)
+
+// Peer is the identity of the other side of a connection, or of a trusted
+// peer.
+type Peer struct {
+	Name string
+	// User is the name of the user running hoist on the peer. It is only
+	// for display, so it is not stored with trusted peers.
+	User      string
+	PublicKey ed25519.PublicKey
+}
+
+// TrustStore is a list of peers, saved in a text file with one
+// "<name> <public key>" pair per line, like SSH's known_hosts.
+type TrustStore struct {
+	filename string
+	// description is written at the top of the file
+	description string
+	mutex       sync.Mutex
+	peers       []Peer
+}
+
+// LoadTrustStore reads the trusted peers stored in directory, which may
+// download from this computer without a password. A missing file is
+// treated as an empty store.
+func LoadTrustStore(directory string) (*TrustStore, error) {
+	return loadStore(filepath.Join(directory, "trusted_peers"), "Peers trusted by hoist")
+}
+
+// LoadKnownSenders reads the senders stored in directory, which the user has
+// accepted when downloading from them. Being a known sender doesn't let a
+// peer download without a password. A missing file is treated as an empty
+// store.
+func LoadKnownSenders(directory string) (*TrustStore, error) {
+	return loadStore(filepath.Join(directory, "known_senders"), "Senders accepted by hoist get")
+}
+
+func loadStore(filename string, description string) (*TrustStore, error) {
+	store := &TrustStore{filename: filename, description: description}
+	file, err := os.Open(store.filename)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", filepath.Base(filename), err)
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a name and a public key", store.filename, line)
+		}
+
+		key, err := ParsePublicKey(fields[1])
+
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", store.filename, line, err)
+		}
+
+		store.peers = append(store.peers, Peer{Name: fields[0], PublicKey: key})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", filepath.Base(filename), err)
+	}
+
+	return store, nil
+}
+
+// Check reports whether key is in the store. If it is, the name it was
+// stored under is returned, and the status is NameChanged if that isn't
+// name. Otherwise name is looked up to detect changed keys.
+func (this *TrustStore) Check(name string, key ed25519.PublicKey) (TrustStatus, string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for _, peer := range this.peers {
+		if peer.PublicKey.Equal(key) && peer.Name != name {
+			return NameChanged, peer.Name
+		}
+
+		if peer.PublicKey.Equal(key) {
+			return Trusted, peer.Name
+		}
+	}
+
+	for _, peer := range this.peers {
+		if peer.Name == name {
+			return KeyChanged, peer.Name
+		}
+	}
+
+	return Unknown, ""
+}
+
+// Add stores key under name and saves the store.
+func (this *TrustStore) Add(name string, key ed25519.PublicKey) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for _, peer := range this.peers {
+		if peer.Name == name {
+			return fmt.Errorf("a peer named %q is already in %s, remove it first", name, filepath.Base(this.filename))
+		}
+
+		if peer.PublicKey.Equal(key) {
+			return fmt.Errorf("this key is already in %s as %q", filepath.Base(this.filename), peer.Name)
+		}
+	}
+
+	this.peers = append(this.peers, Peer{Name: name, PublicKey: key})
+
+	return this.save()
+}
+
+// Remove removes the peer called name and saves the store. found is false
+// if there is no such peer.
+func (this *TrustStore) Remove(name string) (found bool, err error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for i, peer := range this.peers {
+		if peer.Name == name {
+			this.peers = append(this.peers[:i], this.peers[i+1:]...)
+			return true, this.save()
+		}
+	}
+
+	return false, nil
+}
+
+// Peers returns every peer in the store, sorted by name.
+func (this *TrustStore) Peers() []Peer {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	peers := append([]Peer{}, this.peers...)
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Name < peers[j].Name
+	})
+
+	return peers
+}
+
+func (this *TrustStore) save() error {
+	var data bytes.Buffer
+	fmt.Fprintf(&data, "# %s, one \"<name> <public key>\" per line\n", this.description)
+
+	for _, peer := range this.peers {
+		fmt.Fprintf(&data, "%s %s\n", peer.Name, FormatPublicKey(peer.PublicKey))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(this.filename), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %s", err)
+	}
+
+	// Write to a temporary file first so a crash can't leave the store
+	// half written
+	temporary := this.filename + ".tmp"
+
+	if err := os.WriteFile(temporary, data.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to save %s: %s", filepath.Base(this.filename), err)
+	}
+
+	if err := os.Rename(temporary, this.filename); err != nil {
+		return fmt.Errorf("failed to save %s: %s", filepath.Base(this.filename), err)
+	}
+
+	return nil
+}
+
+// ValidateName makes sure a peer name can be stored and displayed safely.
+func ValidateName(name string) error {
+	if name == "" || len(name) > 255 {
+		return fmt.Errorf("peer names must be between 1 and 255 bytes long")
+	}
+
+	if !utf8.ValidString(name) {
+		return fmt.Errorf("peer names must be valid UTF-8")
+	}
+
+	for _, r := range name {
+		if unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return fmt.Errorf("peer name %q must not contain spaces or control characters", name)
+		}
+	}
+
+	return nil
+}
+
+// ValidateUser makes sure a user name can be displayed safely. Unlike peer
+// names, user names may contain spaces, and may be empty.
+func ValidateUser(user string) error {
+	if len(user) > 255 {
+		return fmt.Errorf("user names must be at most 255 bytes long")
+	}
+
+	if !utf8.ValidString(user) {
+		return fmt.Errorf("user names must be valid UTF-8")
+	}
+
+	for _, r := range user {
+		if !unicode.IsPrint(r) {
+			return fmt.Errorf("user name %q must not contain control characters", user)
+		}
+	}
+
+	return nil
+}
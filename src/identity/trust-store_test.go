@@ -0,0 +1,93 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"testing"
+)
+
+func newTestKey(t *testing.T) ed25519.PublicKey {
+	key, _, err := ed25519.GenerateKey(nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func TestTrustStoreCheck(t *testing.T) {
+	laptopKey := newTestKey(t)
+	serverKey := newTestKey(t)
+	strangerKey := newTestKey(t)
+
+	store, err := LoadTrustStore(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Add("laptop", laptopKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Add("server", serverKey); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description string
+		name        string
+		key         ed25519.PublicKey
+		status      TrustStatus
+		storedName  string
+	}{
+		{"known key and name", "laptop", laptopKey, Trusted, "laptop"},
+		{"new key under a stored name", "laptop", strangerKey, KeyChanged, "laptop"},
+		{"new key under a new name", "stranger", strangerKey, Unknown, ""},
+		{"known key under a new name", "desktop", laptopKey, NameChanged, "laptop"},
+		{"known key under another stored name", "server", laptopKey, NameChanged, "laptop"},
+	}
+
+	for _, test := range tests {
+		status, storedName := store.Check(test.name, test.key)
+
+		if status != test.status || storedName != test.storedName {
+			t.Errorf("%s: got (%d, %q), want (%d, %q)", test.description, status, storedName, test.status, test.storedName)
+		}
+	}
+}
+
+func TestTrustStoresAreSeparate(t *testing.T) {
+	directory := t.TempDir()
+	key := newTestKey(t)
+
+	senders, err := LoadKnownSenders(directory)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := senders.Add("sender", key); err != nil {
+		t.Fatal(err)
+	}
+
+	trusted, err := LoadTrustStore(directory)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status, _ := trusted.Check("sender", key); status != Unknown {
+		t.Errorf("a known sender is in the trusted peers with status %d", status)
+	}
+
+	reloaded, err := LoadKnownSenders(directory)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status, _ := reloaded.Check("sender", key); status != Trusted {
+		t.Errorf("the known sender wasn't saved, got status %d", status)
+	}
+}
@@ -0,0 +1,297 @@
+package progress
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aiden-deloryn/hoist/src/events"
+	"github.com/aiden-deloryn/hoist/src/identity"
+	"github.com/aiden-deloryn/hoist/src/share"
+	"github.com/aiden-deloryn/hoist/src/util"
+)
+
+// ReceiverConsole is an events.Handler which displays the progress of a
+// download in a human readable form.
+type ReceiverConsole struct {
+	out     *os.File
+	mode    Mode
+	tracker *Tracker
+}
+
+func NewReceiverConsole(out *os.File, mode Mode) *ReceiverConsole {
+	return &ReceiverConsole{
+		out:  out,
+		mode: ResolveMode(mode, out),
+	}
+}
+
+func (this *ReceiverConsole) HandleEvent(event events.Event) {
+	switch event.Type {
+	case events.FileStarted:
+		this.tracker = NewTracker(this.out, this.mode, event.Path, event.Size)
+		this.tracker.Start("Copying file")
+	case events.Progress:
+		if this.tracker != nil {
+			this.tracker.Update(event.Bytes)
+		}
+	case events.FileDone:
+		if this.tracker != nil {
+			this.tracker.Finish()
+			this.tracker = nil
+		}
+	case events.SymlinkCreated:
+		if this.mode != ModeNone {
+			fmt.Fprintf(this.out, "Creating symlink: \n")
+			fmt.Fprintf(this.out, "  %s --> %s\n", event.Path, event.Target)
+		}
+	case events.AwaitingApproval:
+		fmt.Fprintf(os.Stderr, "Waiting for the sender's approval...\n")
+	case events.Warning:
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", event.Message)
+	}
+}
+
+// SenderConsole is an events.Handler which displays the share address and
+// the live progress of every connected client.
+type SenderConsole struct {
+	board     *StatusBoard
+	mutex     sync.Mutex
+	transfers map[string]*Transfer
+	password  string
+	// serveRoot is true if clients choose a path inside the share
+	serveRoot bool
+}
+
+func NewSenderConsole(out *os.File, mode Mode) *SenderConsole {
+	board := NewStatusBoard(out, ResolveMode(mode, out))
+	board.Start()
+
+	return &SenderConsole{
+		board:     board,
+		transfers: map[string]*Transfer{},
+	}
+}
+
+// ShowPassword displays password underneath the share address, for use when
+// the password was generated rather than chosen by the user.
+func (this *SenderConsole) ShowPassword(password string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.password = password
+}
+
+// printListening explains how to download the share, or shares.
+func (this *SenderConsole) printListening(event events.Event) {
+	addresses := event.Addresses
+
+	if len(addresses) == 0 {
+		addresses = []string{event.Address}
+	}
+
+	fingerprintFlag := ""
+
+	if event.Fingerprint != "" {
+		fingerprintFlag = " --fingerprint " + event.Fingerprint
+	}
+
+	switch {
+	case len(event.Shares) > 0:
+		command, action := "get", "download"
+
+		if this.serveRoot {
+			command, action = "ls", "see what's in"
+		}
+
+		this.board.Printf("Serving %d shares. To %s one on another machine, use:\n", len(event.Shares), action)
+
+		for _, name := range event.Shares {
+			address := addresses[0]
+
+			if name != "" {
+				address += "/" + name
+			}
+
+			this.board.Printf("  hoist %s %s%s\n", command, shellQuoteAddress(address), fingerprintFlag)
+		}
+
+		if this.serveRoot {
+			this.board.Printf("And to download part of one, add the path after its name, e.g. hoist get %s\n", shellQuoteAddress(addresses[0]+"/NAME:PATH"))
+		}
+
+		if len(addresses) > 1 {
+			this.board.Printf("This computer can also be reached on %s\n", strings.Join(addresses[1:], ", "))
+		}
+	case this.serveRoot:
+		this.board.Printf("The directory is being served. To see what's in it on another machine, use:\n")
+
+		for _, address := range addresses {
+			this.board.Printf("  hoist ls %s%s\n", shellQuoteAddress(address), fingerprintFlag)
+		}
+
+		this.board.Printf("And to download part of it, add the path after the address:\n")
+		this.board.Printf("  hoist get %s%s\n", shellQuoteAddress(addresses[0]+":PATH"), fingerprintFlag)
+	default:
+		this.board.Printf("The target file or directory is ready to send. To download it on another machine, use:\n")
+
+		for _, address := range addresses {
+			this.board.Printf("  hoist get %s%s\n", shellQuoteAddress(address), fingerprintFlag)
+		}
+
+		if len(addresses) > 1 {
+			descriptor := share.Descriptor{Addresses: addresses, Fingerprint: event.Fingerprint}
+			this.board.Printf("Or try every address and use the first one that works:\n")
+			this.board.Printf("  hoist get '%s'\n", descriptor.String())
+		}
+	}
+
+	if this.password != "" {
+		this.board.Printf("Password: %s\n", this.password)
+	}
+
+	if event.PublicKey != "" {
+		this.board.Printf("Sender identity: %s %s\n", event.Peer, event.PublicKey)
+	}
+}
+
+// DescribeRequested returns the share and path the client asked for, as
+// they appear after the address, e.g. "builds:projects/alpha", or an empty
+// string if it didn't ask for either.
+func DescribeRequested(event events.Event) string {
+	switch {
+	case event.Share != "" && event.File != "":
+		return strconv.Quote(event.Share + ":" + event.File)
+	case event.Share != "":
+		return strconv.Quote(event.Share)
+	case event.File != "":
+		return strconv.Quote(event.File)
+	}
+
+	return ""
+}
+
+// ShowServeRoot explains how to choose a path inside the share, for use with
+// hoist serve.
+func (this *SenderConsole) ShowServeRoot() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.serveRoot = true
+}
+
+// Close stops rendering the status of connected clients.
+func (this *SenderConsole) Close() {
+	this.board.Stop()
+}
+
+// Snapshot returns the current status of every active transfer.
+func (this *SenderConsole) Snapshot() []TransferStatus {
+	return this.board.Snapshot()
+}
+
+func (this *SenderConsole) HandleEvent(event events.Event) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	transfer := this.transfers[event.Address]
+
+	switch event.Type {
+	case events.Listening:
+		this.printListening(event)
+	case events.AuthFailed:
+		this.board.Printf("Authentication failed for %s: %s\n", event.Address, event.Message)
+	case events.TransferStarted:
+		this.transfers[event.Address] = this.board.Add(event.Address, event.TotalBytes)
+		what := "file(s)"
+
+		// The client chose a share, or a path inside one
+		if requested := DescribeRequested(event); requested != "" {
+			what = requested
+		}
+
+		this.board.Printf("Sending %s to %s (%s)...\n", what, DescribePeer(event.Address, identity.Peer{Name: event.Peer, User: event.User}, event.Trusted), util.FormatByteSize(event.TotalBytes))
+	case events.TransferDeclined:
+		this.board.Printf("%s declined the transfer\n", event.Address)
+	case events.ShareListed:
+		where := ""
+
+		if requested := DescribeRequested(event); requested != "" {
+			where = " in " + requested
+		}
+
+		this.board.Printf("%s listed %s%s\n", DescribePeer(event.Address, identity.Peer{Name: event.Peer, User: event.User}, event.Trusted), DescribeFiles(event.FileCount, event.TotalBytes), where)
+	case events.FileStarted:
+		if transfer != nil {
+			transfer.SetFile(event.File)
+		}
+	case events.Progress:
+		if transfer != nil {
+			transfer.SetFileBytes(event.Bytes)
+		}
+	case events.Summary:
+		this.removeTransfer(event.Address)
+		this.board.Printf("File(s) sent to %s in %s\n", event.Address, FormatDuration(time.Duration(event.DurationMs)*time.Millisecond))
+	case events.ClientRejected:
+		this.board.Printf("Rejected connection from %s: %s\n", event.Address, event.Message)
+	case events.ShuttingDown:
+		if event.Message != "" {
+			this.board.Printf("Shutting down, %s\n", event.Message)
+		}
+
+		if event.Connections > 0 {
+			this.board.Printf("Waiting for %d active transfer(s) to finish. Press Ctrl-C again to cancel them.\n", event.Connections)
+		}
+	case events.Warning:
+		this.board.Printf("Warning: %s\n", event.Message)
+	case events.Error:
+		this.removeTransfer(event.Address)
+
+		if event.Address != "" {
+			this.board.Printf("Error (%s): %s\n", event.Address, event.Message)
+		} else {
+			this.board.Printf("Error: %s\n", event.Message)
+		}
+	}
+}
+
+// removeTransfer takes a client off the status board. The caller must hold
+// the mutex.
+func (this *SenderConsole) removeTransfer(address string) {
+	if transfer, ok := this.transfers[address]; ok {
+		transfer.Remove()
+		delete(this.transfers, address)
+	}
+}
+
+// shellQuoteAddress quotes IPv6 addresses, since most shells treat square
+// brackets as a glob pattern.
+func shellQuoteAddress(address string) string {
+	if strings.HasPrefix(address, "[") {
+		return "'" + address + "'"
+	}
+
+	return address
+}
+
+// DescribePeer returns a peer's address, followed by the user and host names
+// it gave and whether it is trusted, e.g. "192.168.1.52 (jane@laptop)".
+func DescribePeer(address string, peer identity.Peer, trusted bool) string {
+	name := peer.Name
+
+	if peer.User != "" {
+		name = peer.User + "@" + name
+	}
+
+	switch {
+	case peer.Name == "":
+		return address
+	case trusted:
+		return fmt.Sprintf("%s (%s, trusted)", address, name)
+	default:
+		return fmt.Sprintf("%s (%s)", address, name)
+	}
+}
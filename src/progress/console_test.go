@@ -0,0 +1,53 @@
+package progress
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aiden-deloryn/hoist/src/events"
+)
+
+func TestSenderConsoleTracksTransfers(t *testing.T) {
+	out, err := os.Create(filepath.Join(t.TempDir(), "out"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer out.Close()
+
+	console := NewSenderConsole(out, ModePlain)
+	defer console.Close()
+
+	for _, event := range []events.Event{
+		{Type: events.TransferStarted, Address: "10.0.0.1:5000", TotalBytes: 300},
+		{Type: events.TransferStarted, Address: "10.0.0.2:5000", TotalBytes: 100},
+		{Type: events.FileStarted, Address: "10.0.0.1:5000", File: "a.bin", Size: 100},
+		{Type: events.Progress, Address: "10.0.0.1:5000", File: "a.bin", Size: 100, Bytes: 100},
+		{Type: events.FileStarted, Address: "10.0.0.1:5000", File: "b.bin", Size: 200},
+		{Type: events.Progress, Address: "10.0.0.1:5000", File: "b.bin", Size: 200, Bytes: 50},
+		{Type: events.Summary, Address: "10.0.0.2:5000"},
+	} {
+		console.HandleEvent(event)
+	}
+
+	snapshot := console.Snapshot()
+
+	if len(snapshot) != 1 {
+		t.Fatalf("got %d transfers, want 1", len(snapshot))
+	}
+
+	status := snapshot[0]
+
+	if status.ClientAddress != "10.0.0.1:5000" || status.CurrentFile != "b.bin" || status.BytesSent != 150 || status.Percent() != 50 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	// A failed transfer is taken off the board
+	console.HandleEvent(events.Event{Type: events.Error, Address: "10.0.0.1:5000", Message: "failed"})
+
+	if snapshot := console.Snapshot(); len(snapshot) != 0 {
+		t.Errorf("got %d transfers after they finished, want 0", len(snapshot))
+	}
+}
@@ -0,0 +1,67 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aiden-deloryn/hoist/src/types"
+	"github.com/aiden-deloryn/hoist/src/util"
+)
+
+// WriteListing lists every directory, file and symlink in manifest with its
+// type, size and modification time, in the style of "ls -l".
+func WriteListing(out io.Writer, manifest *types.Manifest) {
+	type listingEntry struct {
+		kind    string
+		size    string
+		modTime time.Time
+		name    string
+		display string
+	}
+
+	var entries []listingEntry
+
+	for _, directory := range manifest.Directories {
+		entries = append(entries, listingEntry{"d", "-", directory.ModTime, directory.Name, directory.Name + "/"})
+	}
+
+	for _, file := range manifest.Files {
+		entries = append(entries, listingEntry{"-", util.FormatByteSize(file.Size), file.ModTime, file.Name, file.Name})
+	}
+
+	for _, symlink := range manifest.Symlinks {
+		entries = append(entries, listingEntry{"l", "-", symlink.ModTime, symlink.Name, symlink.Name + " -> " + symlink.Target})
+	}
+
+	// Sort by each component in turn, so that every directory is followed
+	// by its contents. Names never contain control characters.
+	sort.Slice(entries, func(i, j int) bool {
+		return strings.ReplaceAll(entries[i].name, "/", "\x00") < strings.ReplaceAll(entries[j].name, "/", "\x00")
+	})
+
+	width := 0
+
+	for _, entry := range entries {
+		if len(entry.size) > width {
+			width = len(entry.size)
+		}
+	}
+
+	for _, entry := range entries {
+		fmt.Fprintf(out, "%s  %*s  %s  %s\n", entry.kind, width, entry.size, entry.modTime.Local().Format("2006-01-02 15:04"), entry.display)
+	}
+
+	totals := []string{
+		DescribeCount(int64(len(manifest.Directories)), "directory", "directories"),
+		DescribeFiles(manifest.FileCount, manifest.TotalSize),
+	}
+
+	if len(manifest.Symlinks) > 0 {
+		totals = append(totals, DescribeCount(int64(len(manifest.Symlinks)), "symlink", "symlinks"))
+	}
+
+	fmt.Fprintf(out, "%s\n", strings.Join(totals, ", "))
+}
@@ -0,0 +1,303 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aiden-deloryn/hoist/src/util"
+	"golang.org/x/term"
+)
+
+type Mode string
+
+const (
+	// ModeAuto uses ModeBar when writing to a terminal and ModePlain otherwise
+	ModeAuto  Mode = "auto"
+	ModeBar   Mode = "bar"
+	ModePlain Mode = "plain"
+	ModeNone  Mode = "none"
+)
+
+const (
+	barRefreshInterval   = 100 * time.Millisecond
+	tableRefreshInterval = 500 * time.Millisecond
+	plainRefreshInterval = 5 * time.Second
+	rateSampleInterval   = 500 * time.Millisecond
+	// Weight given to the most recent rate sample when smoothing throughput
+	rateSmoothingFactor = 0.3
+	defaultTermWidth    = 80
+	minBarWidth         = 10
+)
+
+func ParseMode(mode string) (Mode, error) {
+	switch Mode(mode) {
+	case ModeAuto, ModeBar, ModePlain, ModeNone:
+		return Mode(mode), nil
+	}
+
+	return "", fmt.Errorf("invalid progress mode '%s' (must be one of: auto, bar, plain, none)", mode)
+}
+
+// ResolveMode converts ModeAuto into the mode best suited to out.
+func ResolveMode(mode Mode, out *os.File) Mode {
+	if mode != ModeAuto {
+		return mode
+	}
+
+	if term.IsTerminal(int(out.Fd())) {
+		return ModeBar
+	}
+
+	return ModePlain
+}
+
+// Tracker reports the progress of a single file transfer.
+type Tracker struct {
+	out   io.Writer
+	fd    int
+	mode  Mode
+	label string
+	total int64
+
+	startTime      time.Time
+	lastRender     time.Time
+	rate           rateMeter
+	current        int64
+	lastLineLength int
+	finished       bool
+}
+
+// NewTracker creates a Tracker which writes to out. The mode should already
+// have been resolved with ResolveMode.
+func NewTracker(out *os.File, mode Mode, label string, total int64) *Tracker {
+	now := time.Now()
+
+	return &Tracker{
+		out:       out,
+		fd:        int(out.Fd()),
+		mode:      mode,
+		label:     label,
+		total:     total,
+		startTime: now,
+		rate:      newRateMeter(now),
+	}
+}
+
+// Start announces the transfer.
+func (this *Tracker) Start(verb string) {
+	if this.mode == ModeNone {
+		return
+	}
+
+	fmt.Fprintf(this.out, "%s %s (%s)...\n", verb, this.label, util.FormatByteSize(this.total))
+}
+
+// Update records that bytesCopied bytes have been transferred so far and
+// redraws the progress output if enough time has passed.
+func (this *Tracker) Update(bytesCopied int64) {
+	now := time.Now()
+	this.current = bytesCopied
+	this.rate.update(now, bytesCopied)
+
+	if bytesCopied >= this.total {
+		this.Finish()
+		return
+	}
+
+	switch this.mode {
+	case ModeBar:
+		if now.Sub(this.lastRender) >= barRefreshInterval {
+			this.renderBar()
+			this.lastRender = now
+		}
+	case ModePlain:
+		if now.Sub(this.lastRender) >= plainRefreshInterval {
+			// Don't log the initial 0% line, it's implied by Start
+			if !this.lastRender.IsZero() {
+				this.renderPlain()
+			}
+			this.lastRender = now
+		}
+	}
+}
+
+// Finish prints the final state of the transfer. It is safe to call more
+// than once.
+func (this *Tracker) Finish() {
+	if this.finished {
+		return
+	}
+
+	this.finished = true
+
+	// Report the average rate over the whole transfer
+	this.rate.average(this.startTime, this.current)
+
+	switch this.mode {
+	case ModeBar:
+		this.renderBar()
+		fmt.Fprint(this.out, "\n")
+	case ModePlain:
+		this.renderPlain()
+	}
+}
+
+// Rate returns the smoothed transfer rate in bytes per second.
+func (this *Tracker) Rate() float64 {
+	return this.rate.rate
+}
+
+func (this *Tracker) percent() int {
+	return percent(this.current, this.total)
+}
+
+func (this *Tracker) stats() string {
+	stats := fmt.Sprintf("%s/%s %s", util.FormatByteSize(this.current), util.FormatByteSize(this.total), FormatRate(this.rate.rate))
+
+	if this.finished {
+		return stats + " in " + FormatDuration(time.Since(this.startTime))
+	}
+
+	return stats + " ETA " + this.eta()
+}
+
+func (this *Tracker) eta() string {
+	return this.rate.eta(this.total - this.current)
+}
+
+func (this *Tracker) renderBar() {
+	width := terminalWidth(this.fd)
+	stats := this.stats()
+
+	// Leave one column free so the cursor never wraps onto a new line
+	barWidth := width - len(stats) - 4
+
+	if barWidth < minBarWidth {
+		barWidth = minBarWidth
+	}
+
+	line := RenderBar(this.percent(), barWidth) + " " + stats
+
+	// Overwrite any leftover characters from a longer previous line
+	padding := ""
+
+	if len(line) < this.lastLineLength {
+		padding = strings.Repeat(" ", this.lastLineLength-len(line))
+	}
+
+	this.lastLineLength = len(line)
+
+	fmt.Fprintf(this.out, "\r%s%s", line, padding)
+}
+
+func (this *Tracker) renderPlain() {
+	fmt.Fprintf(this.out, "%s: %d%% %s\n", this.label, this.percent(), this.stats())
+}
+
+// RenderBar draws a progress bar with the percentage in the middle, e.g.
+// "|=====   50%        |". The width does not include the enclosing '|'.
+func RenderBar(percent int, width int) string {
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 {
+		percent = 100
+	}
+
+	filled := percent * width / 100
+	bar := []rune(strings.Repeat("=", filled) + strings.Repeat(" ", width-filled))
+	label := []rune(fmt.Sprintf("%d%%", percent))
+
+	// Add percentage text in the middle of the progress bar
+	if len(label) <= width {
+		copy(bar[(width-len(label))/2:], label)
+	}
+
+	return "|" + string(bar) + "|"
+}
+
+// FormatRate formats a transfer rate given in bytes per second, e.g.
+// "5.2 MiB/s".
+func FormatRate(bytesPerSecond float64) string {
+	return util.FormatByteSize(int64(bytesPerSecond)) + "/s"
+}
+
+// FormatDuration formats d as "m:ss", or "h:mm:ss" for durations of an hour
+// or longer.
+func FormatDuration(d time.Duration) string {
+	seconds := int64(d.Round(time.Second).Seconds())
+	hours, minutes, seconds := seconds/3600, (seconds/60)%60, seconds%60
+
+	if hours > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", hours, minutes, seconds)
+	}
+
+	return fmt.Sprintf("%d:%02d", minutes, seconds)
+}
+
+func percent(current int64, total int64) int {
+	if total <= 0 {
+		return 100
+	}
+
+	return int(float64(current) / float64(total) * 100)
+}
+
+func terminalWidth(fd int) int {
+	width, _, err := term.GetSize(fd)
+
+	if err != nil || width <= 0 {
+		return defaultTermWidth
+	}
+
+	return width
+}
+
+// rateMeter measures throughput using an exponential moving average of
+// periodic samples, so the reported rate doesn't jump around.
+type rateMeter struct {
+	sampleStartTime  time.Time
+	sampleStartBytes int64
+	rate             float64
+}
+
+func newRateMeter(now time.Time) rateMeter {
+	return rateMeter{sampleStartTime: now}
+}
+
+func (this *rateMeter) update(now time.Time, bytesCopied int64) {
+	sampleDuration := now.Sub(this.sampleStartTime)
+
+	if sampleDuration < rateSampleInterval {
+		return
+	}
+
+	sampleRate := float64(bytesCopied-this.sampleStartBytes) / sampleDuration.Seconds()
+
+	if this.rate == 0 {
+		this.rate = sampleRate
+	} else {
+		this.rate = rateSmoothingFactor*sampleRate + (1-rateSmoothingFactor)*this.rate
+	}
+
+	this.sampleStartTime = now
+	this.sampleStartBytes = bytesCopied
+}
+
+func (this *rateMeter) average(startTime time.Time, bytesCopied int64) {
+	if elapsed := time.Since(startTime).Seconds(); elapsed > 0 {
+		this.rate = float64(bytesCopied) / elapsed
+	}
+}
+
+func (this *rateMeter) eta(remainingBytes int64) string {
+	if this.rate <= 0 {
+		return "--:--"
+	}
+
+	remaining := float64(remainingBytes) / this.rate
+
+	return FormatDuration(time.Duration(remaining * float64(time.Second)))
+}
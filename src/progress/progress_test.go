@@ -0,0 +1,28 @@
+package progress
+
+import "testing"
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		mode  string
+		valid bool
+	}{
+		{"auto", true},
+		{"bar", true},
+		{"plain", true},
+		{"none", true},
+		{"", false},
+		{"Bar", false},
+		{"json", false},
+	}
+
+	for _, test := range tests {
+		mode, err := ParseMode(test.mode)
+
+		if (err == nil) != test.valid {
+			t.Errorf("%q: got %v, want valid to be %t", test.mode, err, test.valid)
+		} else if test.valid && string(mode) != test.mode {
+			t.Errorf("%q: got mode %q", test.mode, mode)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package progress
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aiden-deloryn/hoist/src/util"
+)
+
+// TransferStatus is a snapshot of the progress of a transfer to a single
+// client.
+type TransferStatus struct {
+	ClientAddress string
+	CurrentFile   string
+	BytesSent     int64
+	TotalBytes    int64
+	// Rate is the smoothed transfer rate in bytes per second
+	Rate      float64
+	StartTime time.Time
+}
+
+func (this TransferStatus) Percent() int {
+	return percent(this.BytesSent, this.TotalBytes)
+}
+
+// StatusBoard keeps track of every active transfer on the sending side and
+// periodically renders them as a table (ModeBar) or as log lines
+// (ModePlain). Messages printed with Printf are written above the table so
+// they don't get mixed up with it.
+type StatusBoard struct {
+	out  *os.File
+	mode Mode
+
+	mutex          sync.Mutex
+	transfers      []*Transfer
+	lastTableLines int
+	stop           chan struct{}
+	done           chan struct{}
+}
+
+// Transfer is a single client's entry on a StatusBoard.
+type Transfer struct {
+	board     *StatusBoard
+	status    TransferStatus
+	rate      rateMeter
+	fileBytes int64
+}
+
+func NewStatusBoard(out *os.File, mode Mode) *StatusBoard {
+	return &StatusBoard{
+		out:  out,
+		mode: mode,
+	}
+}
+
+// Start renders the board in the background until Stop is called.
+func (this *StatusBoard) Start() {
+	this.stop = make(chan struct{})
+	this.done = make(chan struct{})
+
+	interval := tableRefreshInterval
+
+	if this.mode == ModePlain {
+		interval = plainRefreshInterval
+	}
+
+	go func() {
+		defer close(this.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-this.stop:
+				return
+			case <-ticker.C:
+				this.render()
+			}
+		}
+	}()
+}
+
+// Stop stops rendering the board and removes the table from the terminal.
+func (this *StatusBoard) Stop() {
+	if this.stop == nil {
+		return
+	}
+
+	close(this.stop)
+	<-this.done
+	this.stop = nil
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.clearTable()
+}
+
+// Printf writes a message to the output without disturbing the table.
+func (this *StatusBoard) Printf(format string, a ...interface{}) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.clearTable()
+	fmt.Fprintf(this.out, format, a...)
+
+	if this.mode == ModeBar {
+		this.drawTable()
+	}
+}
+
+// Add registers a new transfer of totalBytes to clientAddress.
+func (this *StatusBoard) Add(clientAddress string, totalBytes int64) *Transfer {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	now := time.Now()
+	transfer := &Transfer{
+		board: this,
+		status: TransferStatus{
+			ClientAddress: clientAddress,
+			TotalBytes:    totalBytes,
+			StartTime:     now,
+		},
+		rate: newRateMeter(now),
+	}
+
+	this.transfers = append(this.transfers, transfer)
+
+	return transfer
+}
+
+// Snapshot returns the current status of every active transfer.
+func (this *StatusBoard) Snapshot() []TransferStatus {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	snapshot := make([]TransferStatus, 0, len(this.transfers))
+
+	for _, transfer := range this.transfers {
+		snapshot = append(snapshot, transfer.status)
+	}
+
+	return snapshot
+}
+
+// SetFile records the name of the file currently being sent.
+func (this *Transfer) SetFile(name string) {
+	this.board.mutex.Lock()
+	defer this.board.mutex.Unlock()
+
+	this.status.CurrentFile = name
+	this.fileBytes = 0
+}
+
+// SetFileBytes records that bytesCopied bytes of the current file have been
+// sent so far.
+func (this *Transfer) SetFileBytes(bytesCopied int64) {
+	this.board.mutex.Lock()
+	defer this.board.mutex.Unlock()
+
+	this.status.BytesSent += bytesCopied - this.fileBytes
+	this.fileBytes = bytesCopied
+	this.rate.update(time.Now(), this.status.BytesSent)
+	this.status.Rate = this.rate.rate
+}
+
+// Status returns a snapshot of the transfer.
+func (this *Transfer) Status() TransferStatus {
+	this.board.mutex.Lock()
+	defer this.board.mutex.Unlock()
+
+	return this.status
+}
+
+// Remove takes the transfer off the board once it has completed or failed.
+// It is safe to call more than once.
+func (this *Transfer) Remove() {
+	this.board.mutex.Lock()
+	defer this.board.mutex.Unlock()
+
+	for i, transfer := range this.board.transfers {
+		if transfer == this {
+			this.board.transfers = append(this.board.transfers[:i], this.board.transfers[i+1:]...)
+			break
+		}
+	}
+}
+
+func (this *StatusBoard) render() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	switch this.mode {
+	case ModeBar:
+		this.clearTable()
+		this.drawTable()
+	case ModePlain:
+		for _, transfer := range this.transfers {
+			status := transfer.status
+			fmt.Fprintf(this.out, "%s: %s %d%% %s/%s %s\n", status.ClientAddress, status.CurrentFile, status.Percent(),
+				util.FormatByteSize(status.BytesSent), util.FormatByteSize(status.TotalBytes), FormatRate(status.Rate))
+		}
+	}
+}
+
+// clearTable erases the previously drawn table using ANSI escape codes. The
+// caller must hold the mutex.
+func (this *StatusBoard) clearTable() {
+	if this.lastTableLines == 0 {
+		return
+	}
+
+	// Move the cursor to the start of the table and clear everything below it
+	fmt.Fprintf(this.out, "\x1b[%dA\r\x1b[J", this.lastTableLines)
+	this.lastTableLines = 0
+}
+
+// drawTable writes one row per active transfer. The caller must hold the
+// mutex.
+func (this *StatusBoard) drawTable() {
+	if len(this.transfers) == 0 {
+		return
+	}
+
+	width := terminalWidth(int(this.out.Fd())) - 1
+	addressWidth := len("CLIENT")
+
+	for _, transfer := range this.transfers {
+		if len(transfer.status.ClientAddress) > addressWidth {
+			addressWidth = len(transfer.status.ClientAddress)
+		}
+	}
+
+	// Give whatever space is left over to the filename column
+	const statsWidth = 28
+	fileWidth := width - addressWidth - statsWidth
+
+	if fileWidth < minBarWidth {
+		fileWidth = minBarWidth
+	}
+
+	lines := []string{fmt.Sprintf("%-*s %-*s %5s %10s %9s", addressWidth, "CLIENT", fileWidth, "FILE", "DONE", "RATE", "ETA")}
+
+	for _, transfer := range this.transfers {
+		status := transfer.status
+		lines = append(lines, fmt.Sprintf("%-*s %-*s %4d%% %10s %9s",
+			addressWidth, status.ClientAddress,
+			fileWidth, truncateLeft(status.CurrentFile, fileWidth),
+			status.Percent(),
+			FormatRate(status.Rate),
+			transfer.rate.eta(status.TotalBytes-status.BytesSent)))
+	}
+
+	for _, line := range lines {
+		fmt.Fprintln(this.out, line)
+	}
+
+	this.lastTableLines = len(lines)
+}
+
+// truncateLeft shortens s to at most width characters by replacing the start
+// of the string with "...", keeping the more specific end of a path visible.
+func truncateLeft(s string, width int) string {
+	runes := []rune(s)
+
+	if len(runes) <= width {
+		return s
+	}
+
+	if width <= 3 {
+		return string(runes[len(runes)-width:])
+	}
+
+	return "..." + string(runes[len(runes)-width+3:])
+}
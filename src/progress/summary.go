@@ -0,0 +1,135 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aiden-deloryn/hoist/src/types"
+	"github.com/aiden-deloryn/hoist/src/util"
+)
+
+// The number of top level entries and largest files listed by
+// WriteManifestSummary
+const (
+	summaryTopLevelLimit = 10
+	summaryLargestLimit  = 5
+)
+
+// WriteManifestSummary describes the share in manifest: the size of each top
+// level file and directory, and the largest files.
+func WriteManifestSummary(out io.Writer, manifest *types.Manifest) {
+	fmt.Fprintf(out, "The sender is sharing %s:\n", DescribeFiles(manifest.FileCount, manifest.TotalSize))
+
+	type topLevelEntry struct {
+		name  string
+		files int64
+		size  int64
+	}
+
+	var topLevel []*topLevelEntry
+	entries := map[string]*topLevelEntry{}
+
+	// When a directory is shared, list what's inside it instead
+	root := ""
+
+	if len(manifest.Files) > 0 {
+		if name, _, isDirectory := strings.Cut(manifest.Files[0].Name, "/"); isDirectory {
+			root = name + "/"
+		}
+	}
+
+	for _, file := range manifest.Files {
+		if !strings.HasPrefix(file.Name, root) {
+			root = ""
+			break
+		}
+	}
+
+	for _, file := range manifest.Files {
+		name, _, isDirectory := strings.Cut(strings.TrimPrefix(file.Name, root), "/")
+		name = root + name
+
+		if isDirectory {
+			name += "/"
+		}
+
+		entry := entries[name]
+
+		if entry == nil {
+			entry = &topLevelEntry{name: name}
+			entries[name] = entry
+			topLevel = append(topLevel, entry)
+		}
+
+		entry.files++
+		entry.size += file.Size
+	}
+
+	sort.Slice(topLevel, func(i, j int) bool {
+		return topLevel[i].name < topLevel[j].name
+	})
+
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	for i, entry := range topLevel {
+		if i == summaryTopLevelLimit {
+			fmt.Fprintf(table, "  ...and %d more\n", len(topLevel)-i)
+			break
+		}
+
+		files := ""
+
+		if strings.HasSuffix(entry.name, "/") {
+			files = DescribeCount(entry.files, "file", "files")
+		}
+
+		fmt.Fprintf(table, "  %s\t%s\t%s\n", entry.name, files, util.FormatByteSize(entry.size))
+	}
+
+	table.Flush()
+
+	// The largest files are only interesting if there's more than one
+	if len(manifest.Files) <= 1 {
+		return
+	}
+
+	largest := append([]types.ManifestEntry{}, manifest.Files...)
+	sort.SliceStable(largest, func(i, j int) bool {
+		return largest[i].Size > largest[j].Size
+	})
+
+	if len(largest) > summaryLargestLimit {
+		largest = largest[:summaryLargestLimit]
+	}
+
+	fmt.Fprintf(out, "Largest files:\n")
+	width := 0
+
+	for _, file := range largest {
+		if size := util.FormatByteSize(file.Size); len(size) > width {
+			width = len(size)
+		}
+	}
+
+	for _, file := range largest {
+		fmt.Fprintf(out, "  %*s  %s\n", width, util.FormatByteSize(file.Size), file.Name)
+	}
+}
+
+// DescribeFiles returns a description such as "4,210 files (38.2 GiB)".
+func DescribeFiles(count int64, size int64) string {
+	return fmt.Sprintf("%s (%s)", DescribeCount(count, "file", "files"), util.FormatByteSize(size))
+}
+
+// DescribeCount returns count followed by singular or plural, e.g. "1 file"
+// or "4,210 files".
+func DescribeCount(count int64, singular string, plural string) string {
+	if count == 1 {
+		return "1 " + singular
+	}
+
+	return util.FormatCount(count) + " " + plural
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"fmt"
+	"net"
+)
+
+// checkAccess returns an error if a client connecting from address is not
+// in allow, unless allow is empty, or is in deny.
+func checkAccess(allow []*net.IPNet, deny []*net.IPNet, address net.Addr) error {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil
+	}
+
+	tcpAddress, ok := address.(*net.TCPAddr)
+
+	if !ok {
+		return fmt.Errorf("%s is not a TCP address", address)
+	}
+
+	for _, network := range deny {
+		if network.Contains(tcpAddress.IP) {
+			return fmt.Errorf("%s is denied by %s", tcpAddress.IP, network)
+		}
+	}
+
+	if len(allow) == 0 {
+		return nil
+	}
+
+	for _, network := range allow {
+		if network.Contains(tcpAddress.IP) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s is not in the list of allowed addresses", tcpAddress.IP)
+}
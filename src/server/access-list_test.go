@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/aiden-deloryn/hoist/src/util"
+)
+
+func TestCheckAccess(t *testing.T) {
+	tests := []struct {
+		description string
+		allow       []string
+		deny        []string
+		address     string
+		allowed     bool
+	}{
+		{"no rules", nil, nil, "203.0.113.7", true},
+		{"allowed network", []string{"10.20.0.0/16", "192.168.1.42"}, nil, "10.20.3.4", true},
+		{"allowed address", []string{"10.20.0.0/16", "192.168.1.42"}, nil, "192.168.1.42", true},
+		{"not allowed", []string{"10.20.0.0/16", "192.168.1.42"}, nil, "192.168.1.43", false},
+		{"IPv4 mapped IPv6 address", []string{"192.168.1.42"}, nil, "::ffff:192.168.1.42", true},
+		{"IPv6 network", []string{"fd00::/8"}, nil, "fd00::2", true},
+		{"denied", nil, []string{"10.20.3.0/24"}, "10.20.3.4", false},
+		{"not denied", nil, []string{"10.20.3.0/24"}, "10.20.4.4", true},
+		{"deny overrides allow", []string{"10.20.0.0/16"}, []string{"10.20.3.0/24"}, "10.20.3.4", false},
+	}
+
+	for _, test := range tests {
+		allow, err := util.ParseNetworks(test.allow)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		deny, err := util.ParseNetworks(test.deny)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = checkAccess(allow, deny, &net.TCPAddr{IP: net.ParseIP(test.address), Port: 50000})
+
+		if (err == nil) != test.allowed {
+			t.Errorf("%s: got %v, want allowed to be %t", test.description, err, test.allowed)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/aiden-deloryn/hoist/src/values"
+)
+
+// authLimiter slows down password guessing by making each IP address wait
+// exponentially longer after every failed attempt, and locking it out after
+// too many failures. An address's failures are forgotten once it has made
+// none for the lockout period, so a lockout doesn't last forever.
+type authLimiter struct {
+	maxFailures int
+	baseDelay   time.Duration
+	lockout     time.Duration
+	// now returns the current time, and is replaced by tests
+	now func() time.Time
+
+	mutex     sync.Mutex
+	clients   map[string]*authRecord
+	lastPrune time.Time
+	// deriving counts the passwords being checked for each address
+	deriving map[string]int
+}
+
+type authRecord struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// newAuthLimiter creates an authLimiter. A maxFailures or baseDelay of 0 or
+// less disables the lockout or the backoff respectively. Failures are
+// remembered for lockout after the last one.
+func newAuthLimiter(maxFailures int, baseDelay time.Duration, lockout time.Duration) *authLimiter {
+	return &authLimiter{
+		maxFailures: maxFailures,
+		baseDelay:   baseDelay,
+		lockout:     lockout,
+		now:         time.Now,
+		clients:     map[string]*authRecord{},
+		deriving:    map[string]int{},
+	}
+}
+
+// allow reports whether the client at address may try to authenticate. If
+// not, the returned error explains why.
+func (this *authLimiter) allow(address net.Addr) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	host := hostOf(address)
+	record, ok := this.clients[host]
+
+	if !ok {
+		return nil
+	}
+
+	now := this.now()
+
+	if this.expired(record, now) {
+		delete(this.clients, host)
+		return nil
+	}
+
+	if this.maxFailures > 0 && record.failures >= this.maxFailures {
+		wait := record.lastFailure.Add(this.lockout).Sub(now)
+		return fmt.Errorf("locked out after %d failed password attempts, try again in %s", record.failures, wait.Round(time.Second))
+	}
+
+	if wait := record.blockedUntil.Sub(now); wait > 0 {
+		return fmt.Errorf("too many failed password attempts, try again in %s", wait.Round(time.Second))
+	}
+
+	return nil
+}
+
+// startDerivation is called before checking a password from address, which
+// takes one of the few key derivations which can run at once. It returns an
+// error if the client isn't allowed to try a password at the moment, or is
+// already using MAX_DERIVATIONS_PER_CLIENT of them, so that one client can't
+// hold up everyone else. finishDerivation must be called afterwards.
+func (this *authLimiter) startDerivation(address net.Addr) error {
+	// Other connections from the address may have failed since it
+	// connected
+	if err := this.allow(address); err != nil {
+		return err
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	host := hostOf(address)
+
+	if this.deriving[host] >= values.MAX_DERIVATIONS_PER_CLIENT {
+		return fmt.Errorf("already checking %d password(s) from this address", this.deriving[host])
+	}
+
+	this.deriving[host]++
+
+	return nil
+}
+
+// finishDerivation is called once a password started with startDerivation
+// has been checked.
+func (this *authLimiter) finishDerivation(address net.Addr) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	host := hostOf(address)
+	this.deriving[host]--
+
+	if this.deriving[host] <= 0 {
+		delete(this.deriving, host)
+	}
+}
+
+// recordFailure registers a failed attempt from address and returns a
+// description of how many attempts the client has used.
+func (this *authLimiter) recordFailure(address net.Addr) string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	now := this.now()
+	host := hostOf(address)
+	record, ok := this.clients[host]
+
+	if ok && this.expired(record, now) {
+		ok = false
+	}
+
+	if !ok {
+		this.makeRoom(now)
+		record = &authRecord{}
+		this.clients[host] = record
+	}
+
+	record.failures++
+	record.lastFailure = now
+
+	if this.baseDelay > 0 {
+		delay := this.baseDelay
+
+		for i := 1; i < record.failures && delay < values.MAX_AUTH_BACKOFF; i++ {
+			delay *= 2
+		}
+
+		if delay > values.MAX_AUTH_BACKOFF {
+			delay = values.MAX_AUTH_BACKOFF
+		}
+
+		record.blockedUntil = now.Add(delay)
+	}
+
+	if this.maxFailures > 0 {
+		return fmt.Sprintf("attempt %d of %d", record.failures, this.maxFailures)
+	}
+
+	return fmt.Sprintf("attempt %d", record.failures)
+}
+
+// recordSuccess forgets any previous failures from address.
+func (this *authLimiter) recordSuccess(address net.Addr) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	delete(this.clients, hostOf(address))
+}
+
+// inherit copies the failures recorded by previous, which may have
+// different settings.
+func (this *authLimiter) inherit(previous *authLimiter) {
+	previous.mutex.Lock()
+	defer previous.mutex.Unlock()
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for host, record := range previous.clients {
+		copied := *record
+		this.clients[host] = &copied
+	}
+}
+
+// expired reports whether record's failures are old enough to be forgotten.
+// Records are kept while the address still has to wait.
+func (this *authLimiter) expired(record *authRecord, now time.Time) bool {
+	if this.lockout <= 0 || now.Before(record.blockedUntil) {
+		return false
+	}
+
+	return now.Sub(record.lastFailure) >= this.lockout
+}
+
+// makeRoom deletes expired records, at most once a minute, and if there
+// are still MAX_AUTH_RECORDS left, deletes the one whose last failure was
+// longest ago. The caller must hold the mutex.
+func (this *authLimiter) makeRoom(now time.Time) {
+	if now.Sub(this.lastPrune) >= time.Minute || len(this.clients) >= values.MAX_AUTH_RECORDS {
+		this.lastPrune = now
+
+		for host, record := range this.clients {
+			if this.expired(record, now) {
+				delete(this.clients, host)
+			}
+		}
+	}
+
+	if len(this.clients) < values.MAX_AUTH_RECORDS {
+		return
+	}
+
+	oldest := ""
+
+	for host, record := range this.clients {
+		if oldest == "" || record.lastFailure.Before(this.clients[oldest].lastFailure) {
+			oldest = host
+		}
+	}
+
+	delete(this.clients, oldest)
+}
+
+// hostOf returns the IP address of a remote address without the port, so
+// that every connection from the same machine is treated the same way.
+func hostOf(address net.Addr) string {
+	if tcpAddress, ok := address.(*net.TCPAddr); ok {
+		return tcpAddress.IP.String()
+	}
+
+	host, _, err := net.SplitHostPort(address.String())
+
+	if err != nil {
+		return address.String()
+	}
+
+	return host
+}
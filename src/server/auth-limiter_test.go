@@ -0,0 +1,137 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aiden-deloryn/hoist/src/values"
+)
+
+func TestAuthLimiter(t *testing.T) {
+	address := &net.TCPAddr{IP: net.ParseIP("192.168.1.52"), Port: 50000}
+
+	tests := []struct {
+		description string
+		maxFailures int
+		lockout     time.Duration
+		failures    int
+		// elapsed is the time since the last failure
+		elapsed time.Duration
+		allowed bool
+	}{
+		{"no failures", 3, time.Hour, 0, 0, true},
+		{"waiting after a failure", 3, time.Hour, 1, 0, false},
+		{"backoff over", 3, time.Hour, 1, 2 * time.Second, true},
+		{"locked out", 3, time.Hour, 3, 30 * time.Minute, false},
+		{"lockout expired", 3, time.Hour, 3, time.Hour, true},
+		{"lockout never expires", 3, -1, 3, 1000 * time.Hour, false},
+		{"no lockout", -1, time.Hour, 5, 10 * time.Minute, true},
+	}
+
+	for _, test := range tests {
+		now := time.Now()
+		limiter := newAuthLimiter(test.maxFailures, time.Second, test.lockout)
+		limiter.now = func() time.Time { return now }
+
+		for i := 0; i < test.failures; i++ {
+			limiter.recordFailure(address)
+		}
+
+		now = now.Add(test.elapsed)
+		err := limiter.allow(address)
+
+		if (err == nil) != test.allowed {
+			t.Errorf("%s: got %v, want allowed to be %t", test.description, err, test.allowed)
+		}
+	}
+}
+
+func TestAuthLimiterForgetsExpiredFailures(t *testing.T) {
+	now := time.Now()
+	limiter := newAuthLimiter(3, time.Second, time.Hour)
+	limiter.now = func() time.Time { return now }
+	address := &net.TCPAddr{IP: net.ParseIP("10.0.0.1")}
+
+	limiter.recordFailure(address)
+	now = now.Add(2 * time.Hour)
+
+	if attempts := limiter.recordFailure(address); attempts != "attempt 1 of 3" {
+		t.Errorf("expired failures were counted, got %q", attempts)
+	}
+
+	// Expired records are pruned when a new address fails
+	now = now.Add(2 * time.Hour)
+	limiter.recordFailure(&net.TCPAddr{IP: net.ParseIP("10.0.0.2")})
+
+	if _, ok := limiter.clients["10.0.0.1"]; ok {
+		t.Error("an expired record was not deleted")
+	}
+}
+
+func TestAuthLimiterIsCapped(t *testing.T) {
+	now := time.Now()
+	limiter := newAuthLimiter(3, time.Second, -1)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < values.MAX_AUTH_RECORDS+10; i++ {
+		now = now.Add(time.Millisecond)
+		limiter.recordFailure(&net.TCPAddr{IP: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i))})
+	}
+
+	if len(limiter.clients) > values.MAX_AUTH_RECORDS {
+		t.Errorf("the limiter holds %d records, more than %d", len(limiter.clients), values.MAX_AUTH_RECORDS)
+	}
+
+	// The oldest records make way for new ones
+	if _, ok := limiter.clients["10.0.0.0"]; ok {
+		t.Error("the oldest record was kept")
+	}
+}
+
+func TestAuthLimiterInherit(t *testing.T) {
+	address := &net.TCPAddr{IP: net.ParseIP("10.0.0.1")}
+	previous := newAuthLimiter(1, time.Second, time.Hour)
+	previous.recordFailure(address)
+
+	limiter := newAuthLimiter(1, time.Second, time.Hour)
+	limiter.inherit(previous)
+
+	if err := limiter.allow(address); err == nil {
+		t.Error("a locked out address was allowed after inheriting the failures")
+	}
+}
+
+func TestAuthLimiterDerivations(t *testing.T) {
+	limiter := newAuthLimiter(3, time.Second, time.Hour)
+	address := &net.TCPAddr{IP: net.ParseIP("10.0.0.1")}
+	other := &net.TCPAddr{IP: net.ParseIP("10.0.0.2")}
+
+	for i := 0; i < values.MAX_DERIVATIONS_PER_CLIENT; i++ {
+		if err := limiter.startDerivation(address); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := limiter.startDerivation(address); err == nil {
+		t.Error("an address was allowed more derivations than the limit")
+	}
+
+	if err := limiter.startDerivation(other); err != nil {
+		t.Errorf("another address was refused: %s", err)
+	}
+
+	limiter.finishDerivation(address)
+
+	if err := limiter.startDerivation(address); err != nil {
+		t.Errorf("a finished derivation wasn't released: %s", err)
+	}
+
+	// A failure on another connection makes the address wait
+	limiter.recordFailure(other)
+	limiter.finishDerivation(other)
+
+	if err := limiter.startDerivation(other); err == nil {
+		t.Error("an address which must wait was allowed to derive a key")
+	}
+}
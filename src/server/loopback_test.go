@@ -0,0 +1,644 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aiden-deloryn/hoist/src/auth"
+	"github.com/aiden-deloryn/hoist/src/client"
+	"github.com/aiden-deloryn/hoist/src/events"
+	"github.com/aiden-deloryn/hoist/src/identity"
+	"github.com/aiden-deloryn/hoist/src/types"
+)
+
+// testServer is a server running on a loopback address for the length of
+// a test.
+type testServer struct {
+	address string
+	server  *Server
+	done    chan struct{}
+	result  *Result
+}
+
+// startTestServer serves options on a loopback address. The server is shut
+// down when the test ends.
+func startTestServer(t *testing.T, options Options) *testServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options.Listener = listener
+	running := &testServer{
+		address: listener.Addr().String(),
+		server:  NewServer(options),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(running.done)
+
+		result, err := running.server.Serve(context.Background())
+
+		if err != nil {
+			t.Errorf("Serve failed: %s", err)
+		}
+
+		running.result = result
+	}()
+
+	t.Cleanup(func() {
+		running.server.Shutdown()
+
+		select {
+		case <-running.done:
+		case <-time.After(10 * time.Second):
+			t.Error("Serve didn't return after Shutdown")
+		}
+	})
+
+	return running
+}
+
+// wait waits for the server to stop by itself, and returns its result.
+func (this *testServer) wait(t *testing.T) *Result {
+	select {
+	case <-this.done:
+		return this.result
+	case <-time.After(10 * time.Second):
+		t.Fatal("the server didn't stop")
+		return nil
+	}
+}
+
+// stop shuts the server down, and returns its result.
+func (this *testServer) stop(t *testing.T) *Result {
+	this.server.Shutdown()
+
+	return this.wait(t)
+}
+
+// writeTestFiles creates files, mapping names using '/' as the separator to
+// their contents, inside directory.
+func writeTestFiles(t *testing.T, directory string, files map[string]string) {
+	for name, contents := range files {
+		filename := filepath.Join(directory, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filename, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestJSONEvents(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "share")
+	writeTestFiles(t, root, map[string]string{"a.txt": "hello", "empty.txt": ""})
+
+	var stream bytes.Buffer
+	running := startTestServer(t, Options{
+		Filename:  root,
+		Password:  "secret",
+		KeepAlive: true,
+		Events:    events.NewJSONHandler(&stream),
+	})
+
+	receiver := client.NewClient(client.Options{Password: "secret", OutputDirectory: t.TempDir()})
+
+	if _, err := receiver.Get(context.Background(), running.address); err != nil {
+		t.Fatal(err)
+	}
+
+	running.stop(t)
+
+	decoder := json.NewDecoder(&stream)
+	received := map[events.Type][]events.Event{}
+
+	for {
+		var event events.Event
+		err := decoder.Decode(&event)
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("the stream isn't valid JSON: %s", err)
+		}
+
+		if event.Time.IsZero() {
+			t.Errorf("a %s event has no time", event.Type)
+		}
+
+		received[event.Type] = append(received[event.Type], event)
+	}
+
+	for _, eventType := range []events.Type{events.Listening, events.ClientConnected, events.TransferStarted, events.Summary, events.ShuttingDown} {
+		if len(received[eventType]) != 1 {
+			t.Errorf("got %d %s events, want 1", len(received[eventType]), eventType)
+		}
+	}
+
+	sizes := map[string]int64{"a.txt": 5, "empty.txt": 0}
+
+	if len(received[events.FileDone]) != len(sizes) {
+		t.Fatalf("got %d file_done events, want %d", len(received[events.FileDone]), len(sizes))
+	}
+
+	for _, event := range received[events.FileDone] {
+		size, ok := sizes[path.Base(event.File)]
+
+		if !ok || event.Size != size || event.Bytes != size || event.SHA256 == "" {
+			t.Errorf("unexpected file_done event %+v", event)
+		}
+	}
+
+	if started := received[events.TransferStarted]; len(started) == 1 && (started[0].FileCount != 2 || started[0].TotalBytes != 5) {
+		t.Errorf("transfer_started reports %d file(s) and %d bytes, want 2 and 5", started[0].FileCount, started[0].TotalBytes)
+	}
+
+	if summary := received[events.Summary]; len(summary) == 1 && (summary[0].FileCount != 2 || summary[0].TotalBytes != 5) {
+		t.Errorf("summary reports %d file(s) and %d bytes, want 2 and 5", summary[0].FileCount, summary[0].TotalBytes)
+	}
+}
+
+func TestLockedOutClientIsToldWhy(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "share")
+	writeTestFiles(t, root, map[string]string{"a.txt": "a"})
+
+	address := startTestServer(t, Options{Filename: root, Password: "secret", KeepAlive: true, MaxAuthFailures: 1}).address
+
+	wrong := client.NewClient(client.Options{Password: "wrong", OutputDirectory: t.TempDir()})
+
+	if _, err := wrong.Get(context.Background(), address); err == nil || !strings.Contains(err.Error(), "Password is incorrect") {
+		t.Fatalf("got %v, want the password to be incorrect", err)
+	}
+
+	right := client.NewClient(client.Options{Password: "secret", OutputDirectory: t.TempDir()})
+	_, err := right.Get(context.Background(), address)
+
+	if err == nil || !strings.Contains(err.Error(), "locked out after 1 failed password attempts, try again in") {
+		t.Errorf("got %v, want the reason for the lockout", err)
+	}
+}
+
+// readTree returns the files under directory, mapping names using '/' as
+// the separator to their contents, or to "-> target" for symlinks.
+func readTree(t *testing.T, directory string) map[string]string {
+	tree := map[string]string{}
+
+	err := filepath.Walk(directory, func(filename string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		name, _ := filepath.Rel(directory, filename)
+		name = filepath.ToSlash(name)
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(filename)
+			tree[name] = "-> " + filepath.ToSlash(target)
+			return err
+		}
+
+		data, err := os.ReadFile(filename)
+		tree[name] = string(data)
+
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tree
+}
+
+func TestRoundTrip(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "share")
+	writeTestFiles(t, root, map[string]string{"a.txt": "hello", "docs/b.txt": "world", "empty.txt": ""})
+
+	if err := os.Symlink("a.txt", filepath.Join(root, "link")); err != nil {
+		t.Skipf("can't create symlinks: %s", err)
+	}
+
+	running := startTestServer(t, Options{Filename: root, Password: "secret", AuthBackoff: -1})
+	output := t.TempDir()
+
+	wrong := client.NewClient(client.Options{Password: "wrong", OutputDirectory: output})
+
+	if _, err := wrong.Get(context.Background(), running.address); err == nil || !strings.Contains(err.Error(), "Password is incorrect") {
+		t.Fatalf("got %v, want the password to be incorrect", err)
+	}
+
+	// A failed attempt doesn't end the share
+	receiver := client.NewClient(client.Options{Password: "secret", OutputDirectory: output})
+	result, err := receiver.Get(context.Background(), running.address)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.FilesReceived != 3 || result.BytesReceived != 10 || result.SymlinksReceived != 1 {
+		t.Errorf("got %d file(s), %d bytes and %d symlink(s), want 3, 10 and 1", result.FilesReceived, result.BytesReceived, result.SymlinksReceived)
+	}
+
+	want := map[string]string{"share/a.txt": "hello", "share/docs/b.txt": "world", "share/empty.txt": "", "share/link": "-> a.txt"}
+
+	if tree := readTree(t, output); !reflect.DeepEqual(tree, want) {
+		t.Errorf("got %q, want %q", tree, want)
+	}
+
+	// Without KeepAlive, the server stops after the first download
+	if served := running.wait(t); served.Transfers != 1 || served.FilesSent != 3 {
+		t.Errorf("the server sent %d transfer(s) of %d file(s), want 1 of 3", served.Transfers, served.FilesSent)
+	}
+}
+
+func TestDeclinedManifest(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "share")
+	writeTestFiles(t, root, map[string]string{"a.txt": "hello"})
+
+	running := startTestServer(t, Options{Filename: root, Password: "secret"})
+	output := t.TempDir()
+	declined := errors.New("declined")
+
+	receiver := client.NewClient(client.Options{
+		Password:        "secret",
+		OutputDirectory: output,
+		Confirm: func(manifest *types.Manifest) error {
+			if len(manifest.Files) != 1 || manifest.TotalSize != 5 {
+				t.Errorf("unexpected manifest %+v", manifest)
+			}
+
+			return declined
+		},
+	})
+
+	if _, err := receiver.Get(context.Background(), running.address); !errors.Is(err, declined) {
+		t.Fatalf("got %v, want the transfer to be declined", err)
+	}
+
+	if tree := readTree(t, output); len(tree) != 0 {
+		t.Errorf("files were written after declining: %q", tree)
+	}
+
+	// A declined transfer doesn't count as a download, so the server is
+	// still running
+	receiver = client.NewClient(client.Options{Password: "secret", OutputDirectory: output})
+
+	if _, err := receiver.Get(context.Background(), running.address); err != nil {
+		t.Fatal(err)
+	}
+
+	if served := running.wait(t); served.Transfers != 1 {
+		t.Errorf("the server made %d transfer(s), want 1", served.Transfers)
+	}
+}
+
+func TestMaxDownloads(t *testing.T) {
+	directory := t.TempDir()
+	writeTestFiles(t, directory, map[string]string{"a/a.txt": "a", "b/b.txt": "b"})
+
+	running := startTestServer(t, Options{
+		Shares: []Share{
+			{Name: "a", Filename: filepath.Join(directory, "a"), Password: "secret"},
+			{Name: "b", Filename: filepath.Join(directory, "b"), Password: "secret"},
+		},
+		KeepAlive:    true,
+		MaxDownloads: 1,
+	})
+
+	get := func(name string) error {
+		receiver := client.NewClient(client.Options{Password: "secret", OutputDirectory: t.TempDir()})
+		_, err := receiver.Get(context.Background(), running.address+"/"+name)
+
+		return err
+	}
+
+	if err := get("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := get("a"); err == nil || !strings.Contains(err.Error(), errNoDownloadsLeft.Error()) {
+		t.Fatalf("got %v, want the share to have no downloads left", err)
+	}
+
+	// The server stops once every share has been downloaded
+	if err := get("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if served := running.wait(t); served.Transfers != 2 {
+		t.Errorf("the server made %d transfer(s), want 2", served.Transfers)
+	}
+}
+
+func TestProgressEvents(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "share")
+	writeTestFiles(t, root, map[string]string{"big.bin": strings.Repeat("x", 1<<20)})
+
+	var mutex sync.Mutex
+	var progress []events.Event
+
+	running := startTestServer(t, Options{
+		Filename: root,
+		Password: "secret",
+		Events: events.HandlerFunc(func(event events.Event) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if event.Type == events.Progress {
+				progress = append(progress, event)
+			}
+		}),
+	})
+
+	receiver := client.NewClient(client.Options{Password: "secret", OutputDirectory: t.TempDir()})
+
+	if _, err := receiver.Get(context.Background(), running.address); err != nil {
+		t.Fatal(err)
+	}
+
+	running.wait(t)
+
+	if len(progress) == 0 {
+		t.Fatal("no progress was reported")
+	}
+
+	previous := int64(0)
+
+	for _, event := range progress {
+		if event.Address == "" || event.File != "share/big.bin" || event.Size != 1<<20 || event.Bytes < previous || event.Bytes > event.Size {
+			t.Fatalf("unexpected progress event %+v after %d bytes", event, previous)
+		}
+
+		previous = event.Bytes
+	}
+
+	if previous != 1<<20 {
+		t.Errorf("the last progress event reports %d bytes, want %d", previous, 1<<20)
+	}
+}
+
+func TestSilentClientTimesOut(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "share")
+	writeTestFiles(t, root, map[string]string{"a.txt": "a"})
+
+	// The timeout must leave time to check the password
+	running := startTestServer(t, Options{Filename: root, Password: "secret", HandshakeTimeout: 2 * time.Second})
+	conn, err := net.Dial("tcp", running.address)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	// The client never sends its hello, so the server gives up on it
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("the server didn't close the connection: %s", err)
+	}
+
+	// The server carries on serving other clients
+	receiver := client.NewClient(client.Options{Password: "secret", OutputDirectory: t.TempDir()})
+
+	if _, err := receiver.Get(context.Background(), running.address); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCancelledDownloadIsRemoved(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "share")
+	writeTestFiles(t, root, map[string]string{"big.bin": strings.Repeat("x", 32<<20)})
+
+	running := startTestServer(t, Options{Filename: root, Password: "secret", KeepAlive: true})
+	output := t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	receiver := client.NewClient(client.Options{
+		Password:        "secret",
+		OutputDirectory: output,
+		Events: events.HandlerFunc(func(event events.Event) {
+			if event.Type == events.Progress {
+				cancel()
+			}
+		}),
+	})
+
+	if _, err := receiver.Get(ctx, running.address); err != context.Canceled {
+		t.Fatalf("got %v, want the download to be cancelled", err)
+	}
+
+	if tree := readTree(t, output); len(tree) != 0 {
+		t.Errorf("the partial download was left behind: %d file(s)", len(tree))
+	}
+
+	if served := running.stop(t); served.Transfers != 0 {
+		t.Errorf("the server counted %d transfer(s), want 0", served.Transfers)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "share")
+	writeTestFiles(t, root, map[string]string{"a.txt": "a"})
+
+	var mutex sync.Mutex
+	var reason string
+
+	running := startTestServer(t, Options{
+		Filename:  root,
+		KeepAlive: true,
+		Expire:    100 * time.Millisecond,
+		Events: events.HandlerFunc(func(event events.Event) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if event.Type == events.ShuttingDown {
+				reason = event.Message
+			}
+		}),
+	})
+
+	running.wait(t)
+
+	if !strings.Contains(reason, "expired") {
+		t.Errorf("the server shut down with %q, want it to have expired", reason)
+	}
+}
+
+func TestMaxClients(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "share")
+	writeTestFiles(t, root, map[string]string{"a.txt": "a"})
+
+	running := startTestServer(t, Options{Filename: root, Password: "secret", KeepAlive: true, MaxClients: 1})
+
+	// The first client connects but doesn't authenticate yet
+	conn, err := net.Dial("tcp", running.address)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receiver := client.NewClient(client.Options{Password: "secret", OutputDirectory: t.TempDir()})
+
+	if _, err := receiver.Get(context.Background(), running.address); err == nil || !strings.Contains(err.Error(), "the maximum number of clients (1) are already connected") {
+		t.Errorf("got %v, want too many clients to be connected", err)
+	}
+
+	// Once the first client has gone, there is room for another
+	conn.Close()
+
+	for i := 0; running.server.ActiveConnections() > 0 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := receiver.Get(context.Background(), running.address); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestApproval(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "share")
+	writeTestFiles(t, root, map[string]string{"a.txt": "a"})
+
+	laptop, err := identity.Generate("laptop")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := make(chan ApprovalRequest, 3)
+	answers := []bool{false, true}
+
+	running := startTestServer(t, Options{
+		Filename:        root,
+		Password:        "secret",
+		KeepAlive:       true,
+		ApprovalTimeout: 100 * time.Millisecond,
+		Approve: func(ctx context.Context, request ApprovalRequest) bool {
+			requests <- request
+
+			if len(answers) == 0 {
+				// Nobody answers, so the request times out
+				<-ctx.Done()
+				return true
+			}
+
+			answer := answers[0]
+			answers = answers[1:]
+
+			return answer
+		},
+	})
+
+	get := func() error {
+		receiver := client.NewClient(client.Options{Password: "secret", Identity: laptop, OutputDirectory: t.TempDir()})
+		_, err := receiver.Get(context.Background(), running.address)
+
+		return err
+	}
+
+	if err := get(); err != auth.ErrDeclined {
+		t.Errorf("got %v, want the transfer to be declined", err)
+	}
+
+	request := <-requests
+
+	if request.Peer.Name != "laptop" || !bytes.Equal(request.Peer.PublicKey, laptop.PublicKey()) || request.Trusted || request.Filename != root {
+		t.Errorf("unexpected approval request %+v", request)
+	}
+
+	if err := get(); err != nil {
+		t.Errorf("an approved transfer failed: %s", err)
+	}
+
+	if err := get(); err != auth.ErrDeclined {
+		t.Errorf("got %v, want a transfer which wasn't approved in time to be declined", err)
+	}
+
+	if served := running.stop(t); served.Transfers != 1 {
+		t.Errorf("the server made %d transfer(s), want 1", served.Transfers)
+	}
+}
+
+func TestDeniedClientIsToldWhy(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "share")
+	writeTestFiles(t, root, map[string]string{"a.txt": "a"})
+
+	running := startTestServer(t, Options{Filename: root, Password: "secret", Deny: []*net.IPNet{{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}}})
+	receiver := client.NewClient(client.Options{Password: "secret", OutputDirectory: t.TempDir()})
+
+	if _, err := receiver.Get(context.Background(), running.address); err == nil || !strings.Contains(err.Error(), "127.0.0.1 is denied by 127.0.0.0/8") {
+		t.Errorf("got %v, want the client to be denied", err)
+	}
+}
+
+func TestNamedShares(t *testing.T) {
+	directory := t.TempDir()
+	writeTestFiles(t, directory, map[string]string{"docs/a.txt": "docs", "builds/b.bin": "builds"})
+
+	running := startTestServer(t, Options{
+		Shares: []Share{
+			{Name: "docs", Filename: filepath.Join(directory, "docs"), Password: "docs password"},
+			{Name: "builds", Filename: filepath.Join(directory, "builds"), Password: "builds password"},
+			{Name: "private", Filename: filepath.Join(directory, "docs"), Password: "docs password", Deny: []*net.IPNet{{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}}},
+		},
+		KeepAlive:   true,
+		AuthBackoff: -1,
+	})
+
+	tests := []struct {
+		description string
+		share       string
+		password    string
+		// err is part of the expected error, or empty if the download
+		// succeeds
+		err  string
+		tree map[string]string
+	}{
+		{"docs", "/docs", "docs password", "", map[string]string{"docs/a.txt": "docs"}},
+		{"builds", "/builds", "builds password", "", map[string]string{"builds/b.bin": "builds"}},
+		{"another share's password", "/builds", "docs password", "Password is incorrect", nil},
+		{"unknown share", "/music", "docs password", "the sender has no share called \"music\"", nil},
+		{"no share name", "", "docs password", "the sender only has named shares", nil},
+		{"denied share", "/private", "docs password", "the sender has no share called \"private\"", nil},
+	}
+
+	for _, test := range tests {
+		output := t.TempDir()
+		receiver := client.NewClient(client.Options{Password: test.password, OutputDirectory: output})
+		_, err := receiver.Get(context.Background(), running.address+test.share)
+
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got %v, want an error containing %q", test.description, err, test.err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", test.description, err)
+		} else if tree := readTree(t, output); !reflect.DeepEqual(tree, test.tree) {
+			t.Errorf("%s: got %q, want %q", test.description, tree, test.tree)
+		}
+	}
+}
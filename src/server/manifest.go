@@ -0,0 +1,202 @@
+package server
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aiden-deloryn/hoist/src/share"
+	"github.com/aiden-deloryn/hoist/src/types"
+)
+
+// buildManifest walks the target file or directory in the same way as
+// sendObjectToClient and records every regular file that will be sent, so
+// the client knows how much data to expect before the transfer starts. The
+// directories and symlinks are recorded too, so the share can be browsed.
+// Only what selection includes is recorded, along with the directories
+// containing it. destFilename is the name to send filename as, as for
+// sendObjectToClientWithDest.
+func buildManifest(filename string, destFilename string, followSymlinks bool, selection share.Selection) (*types.Manifest, error) {
+	manifest := &types.Manifest{}
+
+	if err := addObjectToManifest(manifest, filename, destFilename, followSymlinks, selection); err != nil {
+		return nil, err
+	}
+
+	if len(selection) == 0 {
+		return manifest, nil
+	}
+
+	// Only keep the directories which were selected or lead to something
+	// which was
+	needed := map[string]bool{}
+
+	for _, entries := range [][]types.ManifestEntry{manifest.Files, manifest.Symlinks} {
+		for _, entry := range entries {
+			for name := path.Dir(entry.Name); name != "."; name = path.Dir(name) {
+				needed[name] = true
+			}
+		}
+	}
+
+	directories := manifest.Directories[:0]
+
+	for _, directory := range manifest.Directories {
+		if needed[directory.Name] || selection.Includes(directory.Name) {
+			directories = append(directories, directory)
+		}
+	}
+
+	manifest.Directories = directories
+
+	return manifest, nil
+}
+
+func addObjectToManifest(manifest *types.Manifest, filename string, destFilename string, followSymlinks bool, selection share.Selection) error {
+	fileInfo, err := os.Stat(filename)
+
+	if err != nil {
+		return fmt.Errorf("failed to read file: %s", err)
+	}
+
+	if !fileInfo.IsDir() {
+		if destFilename == "" {
+			destFilename = filepath.Base(filename)
+		}
+
+		if selection.Includes(filepath.ToSlash(destFilename)) {
+			addFileToManifest(manifest, destFilename, fileInfo)
+		}
+
+		return nil
+	}
+
+	return filepath.Walk(filename, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		outputFilename := destFilename
+
+		if outputFilename == "" {
+			outputFilename = strings.TrimPrefix(path, filepath.Dir(filename))
+			outputFilename = strings.TrimPrefix(outputFilename, string(filepath.Separator))
+		} else {
+			outputFilename = filepath.Clean(outputFilename + string(filepath.Separator) + strings.TrimPrefix(path, filename))
+		}
+
+		outputFilename = strings.ReplaceAll(outputFilename, string(filepath.Separator), "/")
+
+		if info.IsDir() {
+			manifest.Directories = append(manifest.Directories, types.ManifestEntry{
+				Name:    outputFilename,
+				ModTime: info.ModTime(),
+			})
+
+			return nil
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			// Symlinks that are not followed are recreated by the client and
+			// do not carry any file data
+			if !followSymlinks {
+				if !selection.Includes(outputFilename) {
+					return nil
+				}
+
+				linkTarget, err := os.Readlink(path)
+
+				if err != nil {
+					return fmt.Errorf("failed to resolve symlink: '%s'", path)
+				}
+
+				manifest.Symlinks = append(manifest.Symlinks, types.ManifestEntry{
+					Name:    outputFilename,
+					ModTime: info.ModTime(),
+					Target:  linkTarget,
+				})
+
+				return nil
+			}
+
+			linkTarget, err := os.Readlink(path)
+
+			if err != nil {
+				return fmt.Errorf("failed to resolve symlink: '%s'", path)
+			}
+
+			if !filepath.IsAbs(linkTarget) {
+				linkTarget = filepath.Clean(filepath.Join(filepath.Dir(path), linkTarget))
+			}
+
+			return addObjectToManifest(manifest, linkTarget, outputFilename, followSymlinks, selection)
+		}
+
+		if selection.Includes(outputFilename) {
+			addFileToManifest(manifest, outputFilename, info)
+		}
+
+		return nil
+	})
+}
+
+func addFileToManifest(manifest *types.Manifest, name string, info os.FileInfo) {
+	manifest.Files = append(manifest.Files, types.ManifestEntry{
+		Name:    strings.ReplaceAll(name, string(filepath.Separator), "/"),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	})
+	manifest.TotalSize += info.Size()
+	manifest.FileCount++
+}
+
+// errTransferDeclined is returned when the client declines the transfer
+// after seeing the manifest.
+var errTransferDeclined = errors.New("the receiver declined the transfer")
+
+func sendManifestToClient(manifest *types.Manifest, conn net.Conn) error {
+	manifestJSON, err := json.Marshal(manifest)
+
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %s", err)
+	}
+
+	// Send the size of the manifest
+	err = binary.Write(conn, binary.LittleEndian, int64(len(manifestJSON)))
+
+	if err != nil {
+		return fmt.Errorf("failed to send manifest size to the client: %s", err)
+	}
+
+	// Send the manifest
+	_, err = io.WriteString(conn, string(manifestJSON))
+
+	if err != nil {
+		return fmt.Errorf("failed to send manifest to the client: %s", err)
+	}
+
+	return nil
+}
+
+// awaitManifestAnswer waits for the client to accept the manifest, which it
+// does by sending 1, or to decline it, which it does by sending 0.
+func awaitManifestAnswer(conn net.Conn) error {
+	answer := make([]byte, 1)
+
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return fmt.Errorf("failed to read the client's answer to the manifest: %s", err)
+	}
+
+	if answer[0] != 1 {
+		return errTransferDeclined
+	}
+
+	return nil
+}
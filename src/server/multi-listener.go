@@ -0,0 +1,114 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// multiListener accepts connections from several listeners, e.g. one for
+// each address of a network interface.
+type multiListener struct {
+	listeners []net.Listener
+	accepted  chan acceptResult
+	closed    chan struct{}
+	once      sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// listenOnHosts listens on port on each of hosts. If port is 0, the port
+// chosen for the first host is used for the others too.
+func listenOnHosts(hosts []string, port string) (net.Listener, error) {
+	var listeners []net.Listener
+
+	for _, host := range hosts {
+		listener, err := net.Listen("tcp", net.JoinHostPort(host, port))
+
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+
+			return nil, fmt.Errorf("failed to start TCP server: %s", err)
+		}
+
+		listeners = append(listeners, listener)
+
+		if port == "" || port == "0" {
+			port = strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+		}
+	}
+
+	if len(listeners) == 0 {
+		return nil, errors.New("failed to start TCP server: there are no addresses to listen on")
+	}
+
+	multi := &multiListener{
+		listeners: listeners,
+		accepted:  make(chan acceptResult),
+		closed:    make(chan struct{}),
+	}
+
+	for _, listener := range listeners {
+		go multi.run(listener)
+	}
+
+	return multi, nil
+}
+
+func (this *multiListener) run(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+
+		select {
+		case this.accepted <- acceptResult{conn: conn, err: err}:
+		case <-this.closed:
+			if conn != nil {
+				conn.Close()
+			}
+
+			return
+		}
+
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+func (this *multiListener) Accept() (net.Conn, error) {
+	select {
+	case result := <-this.accepted:
+		return result.conn, result.err
+	case <-this.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes every listener.
+func (this *multiListener) Close() error {
+	var err error
+
+	this.once.Do(func() {
+		close(this.closed)
+
+		for _, listener := range this.listeners {
+			if closeErr := listener.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	})
+
+	return err
+}
+
+// Addr returns the address of the first listener.
+func (this *multiListener) Addr() net.Addr {
+	return this.listeners[0].Addr()
+}
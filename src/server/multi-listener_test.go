@@ -0,0 +1,55 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestListenOnHosts(t *testing.T) {
+	hosts := []string{"127.0.0.1"}
+
+	// Not every machine running the tests has IPv6
+	if probe, err := net.Listen("tcp", "[::1]:0"); err == nil {
+		probe.Close()
+		hosts = append(hosts, "::1")
+	}
+
+	listener, err := listenOnHosts(hosts, "0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+
+	for _, host := range hosts {
+		client, err := net.Dial("tcp", net.JoinHostPort(host, port))
+
+		if err != nil {
+			t.Fatalf("%s isn't listening on the same port: %s", host, err)
+		}
+
+		conn, err := listener.Accept()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if local := conn.LocalAddr().(*net.TCPAddr).IP; !local.Equal(net.ParseIP(host)) {
+			t.Errorf("a connection to %s arrived on %s", host, local)
+		}
+
+		conn.Close()
+		client.Close()
+	}
+
+	listener.Close()
+
+	if _, err := listener.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("got %v after closing, want net.ErrClosed", err)
+	}
+}
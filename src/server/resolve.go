@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// resolvePath finds the file or directory called name, using '/' as the
+// path separator, inside root. It refuses names which lead outside root,
+// whether through ".." or through symlinks, and returns the file's real
+// path along with the name the client should save it as.
+func resolvePath(root string, name string) (filename string, saveAs string, err error) {
+	for _, component := range strings.Split(name, "/") {
+		if component == ".." {
+			return "", "", fmt.Errorf("%q is outside the shared directory", name)
+		}
+
+		// Backslashes and drive letters are path separators on Windows
+		if filepath.Separator == '\\' && strings.ContainsAny(component, "\\:") {
+			return "", "", fmt.Errorf("invalid path %q", name)
+		}
+	}
+
+	root, err = filepath.Abs(root)
+
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read the shared directory: %s", err)
+	}
+
+	name = strings.Trim(path.Clean("/"+name), "/")
+
+	if name == "" {
+		return root, "", nil
+	}
+
+	filename, err = filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(name)))
+
+	if os.IsNotExist(err) {
+		return "", "", fmt.Errorf("%q doesn't exist", name)
+	} else if err != nil {
+		return "", "", fmt.Errorf("failed to read %q: %s", name, err)
+	}
+
+	prefix := root
+
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+
+	if filename != root && !strings.HasPrefix(filename, prefix) {
+		return "", "", fmt.Errorf("%q is outside the shared directory", name)
+	}
+
+	return filename, path.Base(name), nil
+}
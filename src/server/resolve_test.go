@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aiden-deloryn/hoist/src/auth"
+	"github.com/aiden-deloryn/hoist/src/share"
+	"github.com/aiden-deloryn/hoist/src/types"
+)
+
+// newTestRoot creates a shared directory holding a file, a directory and a
+// symlink leading outside it, and returns its real path.
+func newTestRoot(t testing.TB) string {
+	directory, err := filepath.EvalSymlinks(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(directory, "root")
+	outside := filepath.Join(directory, "outside")
+
+	for _, dir := range []string{root, outside, filepath.Join(root, "docs")} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "docs", "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Skipf("can't create symlinks: %s", err)
+	}
+
+	return root
+}
+
+// encodeRequest returns request as the client sends it.
+func encodeRequest(request *types.Request) []byte {
+	var data bytes.Buffer
+	requestJSON, _ := json.Marshal(request)
+	data.WriteByte(1)
+	binary.Write(&data, binary.LittleEndian, int64(len(requestJSON)))
+	data.Write(requestJSON)
+
+	return data.Bytes()
+}
+
+func TestResolvePath(t *testing.T) {
+	root := newTestRoot(t)
+
+	tests := []struct {
+		description string
+		name        string
+		filename    string
+		saveAs      string
+		err         bool
+	}{
+		{"the root", "", root, "", false},
+		{"a directory", "docs", filepath.Join(root, "docs"), "docs", false},
+		{"a file", "docs/a.txt", filepath.Join(root, "docs", "a.txt"), "a.txt", false},
+		{"leading and doubled slashes", "/docs//a.txt", filepath.Join(root, "docs", "a.txt"), "a.txt", false},
+		{"dot components", "./docs/./a.txt", filepath.Join(root, "docs", "a.txt"), "a.txt", false},
+		{"parent directory", "..", "", "", true},
+		{"parent directory in the middle", "docs/../../outside", "", "", true},
+		{"parent directory staying inside", "docs/../docs/a.txt", "", "", true},
+		{"symlink leading outside", "escape", "", "", true},
+		{"through a symlink leading outside", "escape/x", "", "", true},
+		{"nonexistent file", "docs/b.txt", "", "", true},
+	}
+
+	for _, test := range tests {
+		filename, saveAs, err := resolvePath(root, test.name)
+
+		if (err != nil) != test.err {
+			t.Errorf("%s: got error %v, want error %t", test.description, err, test.err)
+		} else if filename != test.filename || saveAs != test.saveAs {
+			t.Errorf("%s: got (%q, %q), want (%q, %q)", test.description, filename, saveAs, test.filename, test.saveAs)
+		}
+	}
+}
+
+func FuzzRequest(f *testing.F) {
+	root := newTestRoot(f)
+	shared := &Share{Filename: root, ServeRoot: true}
+
+	f.Add(encodeRequest(&types.Request{List: true}))
+	f.Add(encodeRequest(&types.Request{Path: "docs", Only: []string{"*.txt"}}))
+	f.Add(encodeRequest(&types.Request{Path: "../outside"}))
+	f.Add(encodeRequest(&types.Request{Path: "escape/x"}))
+	f.Add(encodeRequest(&types.Request{Path: "docs/../../outside"}))
+	f.Add(encodeRequest(&types.Request{Only: []string{"../**", "/etc/*", ""}}))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		request, err := auth.AwaitRequest(bytes.NewReader(data))
+
+		if err != nil {
+			return
+		}
+
+		filename, _, err := resolveRequest(shared, request)
+
+		if err == nil && filename != root && !strings.HasPrefix(filename, root+string(filepath.Separator)) {
+			t.Fatalf("%q resolved to %q, outside the share", request.Path, filename)
+		}
+
+		selection, err := share.ParseSelection(request.Only)
+
+		if err != nil {
+			return
+		}
+
+		// A parsed selection must parse to itself
+		reparsed, err := share.ParseSelection(selection)
+
+		if err != nil || !reflect.DeepEqual(reparsed, selection) {
+			t.Fatalf("%q parsed to %q, which parses to %q, %v", request.Only, selection, reparsed, err)
+		}
+
+		selection.Includes("root/docs/a.txt")
+	})
+}
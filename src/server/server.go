@@ -2,8 +2,12 @@ package server
 
 import (
 	"bufio"
-	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,100 +15,1116 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
-
+	"sync"
+	"time"
+
+	"github.com/aiden-deloryn/hoist/src/auth"
+	"github.com/aiden-deloryn/hoist/src/certs"
+	"github.com/aiden-deloryn/hoist/src/events"
+	"github.com/aiden-deloryn/hoist/src/identity"
+	"github.com/aiden-deloryn/hoist/src/share"
 	"github.com/aiden-deloryn/hoist/src/types"
+	"github.com/aiden-deloryn/hoist/src/util"
 	"github.com/aiden-deloryn/hoist/src/values"
 )
 
-func StartServer(address string, filename string, password string, keepAlive bool, followSymlinks bool) error {
-	listner, err := net.Listen("tcp", address)
+// Options configures a Server.
+type Options struct {
+	// Address is the address to listen on, e.g. "192.168.1.10:0", or ":0"
+	// to listen on every interface
+	Address string
+	// Listener, if not nil, is used instead of listening on Address, e.g.
+	// for a socket passed in by systemd. It must accept TCP connections.
+	// It is closed when the server stops.
+	Listener net.Listener
+	// Identity identifies the server to clients. If nil, a new identity is
+	// generated each time the server starts.
+	Identity *identity.Identity
+	// TrustedPeers lists the clients which may connect without a password.
+	// If nil, every client must use the password.
+	TrustedPeers *identity.TrustStore
+	// TrustedOnly rejects clients which are not in TrustedPeers, instead of
+	// asking them for the password
+	TrustedOnly bool
+	// TLSCertificate, if not nil, makes the server use TLS 1.3 and present
+	// this certificate. Clients can pin it using the value of Fingerprint.
+	TLSCertificate *tls.Certificate
+	// TLSClientCAs, if not nil, requires clients to present a TLS
+	// certificate signed by one of these certificates. Requires
+	// TLSCertificate.
+	TLSClientCAs *x509.CertPool
+	// Interface restricts the server to the network interface with this
+	// name, e.g. "eth0", by listening on each of its addresses instead of
+	// Address. With a Listener, connections arriving on other interfaces
+	// are closed instead.
+	Interface string
+	// Allow, if not empty, only accepts connections from these networks.
+	// Other connections are closed before the client can authenticate.
+	Allow []*net.IPNet
+	// Deny closes connections from these networks before the client can
+	// authenticate, even if they are allowed by Allow.
+	Deny []*net.IPNet
+	// Filename is the file or directory to share. It is shared with
+	// clients which don't ask for a share by name, and may be empty if
+	// there are named Shares.
+	Filename string
+	// Password is the password clients must provide. It may be empty.
+	Password string
+	// Shares are shared alongside Filename, with clients asking for them by
+	// name. Each has its own password and access rules.
+	Shares []Share
+	// KeepAlive keeps serving clients until the context is cancelled or a
+	// limit is reached. Otherwise MaxDownloads defaults to 1, so the server
+	// stops once each share has been downloaded.
+	KeepAlive bool
+	// Expire stops the server once it has been running for this long. 0
+	// means the share never expires.
+	Expire time.Duration
+	// MaxDownloads limits how many clients may receive each share in full.
+	// The server stops once every share has reached the limit. 0 means no
+	// limit.
+	MaxDownloads int
+	// MaxClients limits how many clients may be connected at once. Extra
+	// connections are closed immediately. 0 means no limit.
+	MaxClients int
+	// MaxAuthFailures locks out an IP address after this many failed
+	// password attempts. 0 uses values.DEFAULT_MAX_AUTH_FAILURES, and a
+	// negative value disables the lockout.
+	MaxAuthFailures int
+	// AuthBackoff is how long an IP address must wait after its first
+	// failed password attempt. The wait doubles after each further failure.
+	// 0 uses values.DEFAULT_AUTH_BACKOFF, and a negative value disables the
+	// backoff.
+	AuthBackoff time.Duration
+	// AuthLockout is how long an IP address stays locked out, and how long
+	// its failed attempts are remembered after the last one. 0 uses
+	// values.DEFAULT_AUTH_LOCKOUT, and a negative value never forgets them.
+	AuthLockout time.Duration
+	// FollowSymlinks sends the targets of symlinks instead of the symlinks.
+	// It can't be used with ServeRoot.
+	FollowSymlinks bool
+	// ServeRoot makes Filename a directory which clients choose from, by
+	// naming the file or directory they want inside it. Names leading
+	// outside Filename, through ".." or symlinks, are refused.
+	ServeRoot bool
+	// Approve, if not nil, is called once a client has authenticated, and
+	// the share is only sent to the client if it returns true. ctx is
+	// cancelled once ApprovalTimeout has passed, and the client is then
+	// declined. It may be called from several goroutines at once.
+	Approve func(ctx context.Context, request ApprovalRequest) bool
+	// ApprovalTimeout limits how long Approve may take. 0 means no limit.
+	ApprovalTimeout time.Duration
+	// HandshakeTimeout limits how long a client has to authenticate after
+	// connecting. 0 means no limit.
+	HandshakeTimeout time.Duration
+	// IdleTimeout closes a connection when no data has been sent or
+	// received for this long. 0 means no limit.
+	IdleTimeout time.Duration
+	// Timeout limits how long a single client's transfer may take. 0 means
+	// no limit.
+	Timeout time.Duration
+	// Events receives events as they happen. It is called from a separate
+	// goroutine for each client. If nil, events are discarded.
+	Events events.Handler
+}
+
+// Share is a file or directory which clients ask for by name, when a Server
+// has several.
+type Share struct {
+	// Name is the name clients ask for the share by, see
+	// share.CheckShareName
+	Name string
+	// Filename, ServeRoot and FollowSymlinks are as for Options
+	Filename       string
+	ServeRoot      bool
+	FollowSymlinks bool
+	// Password and TrustedOnly are as for Options, but only apply to this
+	// share
+	Password    string
+	TrustedOnly bool
+	// RequirePassword makes trusted peers use the password too, for shares
+	// with a password of their own
+	RequirePassword bool
+	// Allow and Deny limit which networks can use this share, in addition
+	// to Options.Allow and Options.Deny
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+// ApprovalRequest describes a client waiting for Options.Approve.
+type ApprovalRequest struct {
+	// Address is the client's address
+	Address string
+	// Share is the name of the share the client asked for, or empty for
+	// the unnamed share
+	Share string
+	// Peer is the client's identity, and Trusted is true if it is a
+	// trusted peer rather than having used the password
+	Peer    identity.Peer
+	Trusted bool
+	// Filename is the file or directory being shared. With ServeRoot, it
+	// is the file or directory the client asked for, and Path is the name
+	// it asked for it by.
+	Filename string
+	Path     string
+	// List is true if the client only wants to list the share, and Only
+	// holds the patterns chosen by the client, if it only wants some of the
+	// files
+	List bool
+	Only []string
+}
+
+// Result summarises everything the server sent before it stopped.
+type Result struct {
+	// Transfers is the number of clients which received the share in full
+	Transfers int64
+	FilesSent int64
+	BytesSent int64
+}
+
+// Server shares a single file or directory with clients.
+type Server struct {
+	options Options
+	// listener is set by Listen, and must be read with the mutex held by
+	// anything which may run alongside Serve
+	listener net.Listener
+	// addresses are the addresses clients can use to reach the server
+	addresses []string
+	// interfaceIPs are the IP addresses of options.Interface, which
+	// connections must arrive on
+	interfaceIPs map[string]bool
+	// shares maps the name of each share to its settings. The unnamed share
+	// has an empty name.
+	shares map[string]*Share
+
+	mutex       sync.Mutex
+	result      Result
+	connections map[net.Conn]struct{}
+	closing     bool
+	handlers    sync.WaitGroup
+	downloads   *downloadCounter
+	authLimiter *authLimiter
+	// rejections limits how many refused clients are told why at once
+	rejections chan struct{}
+}
+
+// downloadCounter counts the downloads of each share by name. started counts
+// the transfers which have been accepted and have not failed, and finished
+// counts those which succeeded. A server shares its counter with the servers
+// which inherit from it.
+type downloadCounter struct {
+	mutex    sync.Mutex
+	started  map[string]int
+	finished map[string]int
+}
+
+func NewServer(options Options) *Server {
+	if options.Events == nil {
+		options.Events = events.Discard
+	}
+
+	if !options.KeepAlive && options.MaxDownloads == 0 {
+		options.MaxDownloads = 1
+	}
+
+	if options.MaxAuthFailures == 0 {
+		options.MaxAuthFailures = values.DEFAULT_MAX_AUTH_FAILURES
+	}
+
+	if options.AuthBackoff == 0 {
+		options.AuthBackoff = values.DEFAULT_AUTH_BACKOFF
+	}
+
+	if options.AuthLockout == 0 {
+		options.AuthLockout = values.DEFAULT_AUTH_LOCKOUT
+	}
+
+	return &Server{
+		options:     options,
+		connections: map[net.Conn]struct{}{},
+		downloads: &downloadCounter{
+			started:  map[string]int{},
+			finished: map[string]int{},
+		},
+		authLimiter: newAuthLimiter(options.MaxAuthFailures, options.AuthBackoff, options.AuthLockout),
+		rejections:  make(chan struct{}, values.MAX_PENDING_REJECTIONS),
+	}
+}
+
+// Inherit makes the server carry on from previous, e.g. when a daemon's
+// configuration is reloaded, so that clients which are locked out stay
+// locked out and downloads still count towards MaxDownloads. It must be
+// called before Serve.
+func (this *Server) Inherit(previous *Server) {
+	this.downloads = previous.downloads
+	this.authLimiter.inherit(previous.authLimiter)
+}
+
+// Listen starts listening on the configured address. Calling Listen before
+// Serve allows the caller to find out the address with Addr.
+func (this *Server) Listen() error {
+	if this.Addr() != nil {
+		return nil
+	}
+
+	shares, err := buildShares(this.options)
 
 	if err != nil {
-		return fmt.Errorf("failed to start TCP server: %s", err)
+		return err
 	}
 
-	fmt.Printf("The target file or directory is ready to send. To download it on another machine, use:\n")
-	fmt.Printf("  hoist get %s\n", listner.Addr())
+	this.shares = shares
 
-	for {
-		conn, err := listner.Accept()
+	if this.options.Identity == nil {
+		identity, err := identity.Generate(values.APP_NAME)
 
-		// If a connection error occurs, log the error and move on to the next connection
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			continue
+			return err
+		}
+
+		this.options.Identity = identity
+	}
+
+	var interfaceHosts []string
+
+	if this.options.Interface != "" {
+		hosts, err := util.GetInterfaceAddresses(this.options.Interface)
+
+		if err != nil {
+			return err
 		}
 
-		if keepAlive {
-			// Handle multiple connections by starting a new goroutine for each one
-			go handleIncomingConnection(conn, filename, password, followSymlinks)
-		} else {
-			// Handle the first successful connection and then exit
-			handleIncomingConnection(conn, filename, password, followSymlinks)
-			break
+		interfaceHosts = hosts
+		this.interfaceIPs = map[string]bool{}
+
+		for _, host := range hosts {
+			this.interfaceIPs[net.ParseIP(strings.SplitN(host, "%", 2)[0]).String()] = true
 		}
 	}
 
+	listener := this.options.Listener
+
+	if listener == nil && interfaceHosts != nil {
+		// Listen on the interface's own addresses, so connections arriving
+		// on other interfaces are refused by the operating system
+		_, port, err := net.SplitHostPort(this.options.Address)
+
+		if err != nil {
+			return fmt.Errorf("invalid address %q: %s", this.options.Address, err)
+		}
+
+		listener, err = listenOnHosts(interfaceHosts, port)
+
+		if err != nil {
+			return err
+		}
+	} else if listener == nil {
+		listener, err = net.Listen("tcp", this.options.Address)
+
+		if err != nil {
+			return fmt.Errorf("failed to start TCP server: %s", err)
+		}
+	}
+
+	listenAddress, ok := listener.Addr().(*net.TCPAddr)
+
+	if !ok {
+		listener.Close()
+		return fmt.Errorf("%s is not a TCP address", listener.Addr())
+	}
+
+	if this.options.TLSCertificate != nil {
+		listener = tls.NewListener(listener, certs.ServerConfig(this.options.TLSCertificate, this.options.TLSClientCAs))
+	}
+
+	if !listenAddress.IP.IsUnspecified() && interfaceHosts == nil {
+		this.addresses = []string{listenAddress.String()}
+	} else {
+		hosts := interfaceHosts
+
+		if hosts == nil {
+			hosts, err = util.GetInterfaceAddresses("")
+
+			if err != nil {
+				listener.Close()
+				return err
+			}
+		}
+
+		for _, host := range hosts {
+			this.addresses = append(this.addresses, net.JoinHostPort(host, strconv.Itoa(listenAddress.Port)))
+		}
+	}
+
+	// Shutdown may be reading the listener from another goroutine
+	this.mutex.Lock()
+	this.listener = listener
+	this.mutex.Unlock()
+
 	return nil
 }
 
-func handleIncomingConnection(conn net.Conn, filename string, password string, followSymlinks bool) error {
+// Fingerprint returns the fingerprint of the server's TLS certificate, or an
+// empty string if the server doesn't use TLS.
+func (this *Server) Fingerprint() string {
+	if this.options.TLSCertificate == nil {
+		return ""
+	}
+
+	return certs.Fingerprint(this.options.TLSCertificate.Certificate[0])
+}
+
+// Addresses returns every address clients can use to reach the server, or
+// nil if Listen has not been called. When the server listens on every
+// interface, there is one address for each usable IP address of this
+// computer.
+func (this *Server) Addresses() []string {
+	return this.addresses
+}
+
+// Addr returns the address the server is listening on, or nil if Listen
+// has not been called.
+func (this *Server) Addr() net.Addr {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.listener == nil {
+		return nil
+	}
+
+	return this.listener.Addr()
+}
+
+// Serve accepts connections and sends the share to each client that
+// authenticates, until one of the share's limits is reached. Once Shutdown
+// is called, Serve stops accepting connections and returns when every
+// active transfer has finished. Cancelling ctx aborts active transfers
+// immediately.
+func (this *Server) Serve(ctx context.Context) (*Result, error) {
+	if err := this.Listen(); err != nil {
+		return nil, err
+	}
+
+	defer this.listener.Close()
+
+	// Stop accepting connections once the context is cancelled
+	stop := util.CloseOnCancel(ctx, this.listener)
+	defer stop()
+
+	this.options.Events.HandleEvent(events.Event{
+		Type:        events.Listening,
+		Time:        time.Now(),
+		Address:     this.listener.Addr().String(),
+		Addresses:   this.addresses,
+		Shares:      this.shareNames(),
+		Fingerprint: this.Fingerprint(),
+		Peer:        this.options.Identity.Name,
+		User:        this.options.Identity.User,
+		PublicKey:   identity.FormatPublicKey(this.options.Identity.PublicKey()),
+	})
+
+	if this.options.Expire > 0 {
+		timer := time.AfterFunc(this.options.Expire, func() {
+			this.shutdown(fmt.Sprintf("the share expired after %s", this.options.Expire))
+		})
+		defer timer.Stop()
+	}
+
+	for !this.isClosing() {
+		conn, err := this.listener.Accept()
+
+		if ctx.Err() != nil {
+			if err == nil {
+				conn.Close()
+			}
+
+			this.handlers.Wait()
+			return this.Result(), ctx.Err()
+		}
+
+		if err != nil {
+			if this.isClosing() || errors.Is(err, net.ErrClosed) {
+				break
+			}
+
+			// If a connection error occurs, log the error and move on to the next connection
+			this.options.Events.HandleEvent(events.Event{Type: events.Error, Time: time.Now(), Message: err.Error()})
+			continue
+		}
+
+		if err := checkAccess(this.options.Allow, this.options.Deny, conn.RemoteAddr()); err != nil {
+			this.rejectConnection(conn, err.Error())
+			continue
+		}
+
+		if this.interfaceIPs != nil && !this.interfaceIPs[hostOf(conn.LocalAddr())] {
+			this.rejectConnection(conn, fmt.Sprintf("the connection did not arrive on %s", this.options.Interface))
+			continue
+		}
+
+		// Refuse clients which have guessed the password wrong too often
+		if err := this.authLimiter.allow(conn.RemoteAddr()); err != nil {
+			this.rejectConnection(conn, err.Error())
+			continue
+		}
+
+		if !this.addConnection(conn) {
+			this.rejectConnection(conn, fmt.Sprintf("the maximum number of clients (%d) are already connected", this.options.MaxClients))
+			continue
+		}
+
+		// Handle each connection in a new goroutine
+		this.handlers.Add(1)
+
+		go func() {
+			defer this.handlers.Done()
+			this.serveConnection(ctx, conn)
+		}()
+	}
+
+	// Let any transfers which are still running finish
+	this.handlers.Wait()
+
+	return this.Result(), nil
+}
+
+// Shutdown stops the server from accepting new connections. Transfers which
+// are already running carry on, and Serve returns once they have finished.
+func (this *Server) Shutdown() {
+	this.shutdown("")
+}
+
+func (this *Server) shutdown(reason string) {
+	this.mutex.Lock()
+
+	if this.closing {
+		this.mutex.Unlock()
+		return
+	}
+
+	this.closing = true
+	activeConnections := len(this.connections)
+	listener := this.listener
+	this.mutex.Unlock()
+
+	// Report the shutdown before closing the listener, which lets Serve
+	// return
+	this.options.Events.HandleEvent(events.Event{
+		Type:        events.ShuttingDown,
+		Time:        time.Now(),
+		Connections: int64(activeConnections),
+		Message:     reason,
+	})
+
+	if listener != nil {
+		listener.Close()
+	}
+}
+
+// ActiveConnections returns the number of clients currently connected.
+func (this *Server) ActiveConnections() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return len(this.connections)
+}
+
+func (this *Server) isClosing() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.closing
+}
+
+// rejectConnection closes a connection before the client has authenticated.
+// The client is told why in the background, unless too many other clients
+// are being rejected at the same time.
+func (this *Server) rejectConnection(conn net.Conn, reason string) {
+	this.options.Events.HandleEvent(events.Event{
+		Type:    events.ClientRejected,
+		Time:    time.Now(),
+		Address: conn.RemoteAddr().String(),
+		Message: reason,
+	})
+
+	select {
+	case this.rejections <- struct{}{}:
+	default:
+		conn.Close()
+		return
+	}
+
+	this.handlers.Add(1)
+
+	go func() {
+		defer this.handlers.Done()
+		defer func() { <-this.rejections }()
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(values.REJECTION_TIMEOUT))
+		auth.Reject(conn, reason)
+	}()
+}
+
+// addConnection keeps track of a new connection. It returns false if the
+// maximum number of clients are already connected.
+func (this *Server) addConnection(conn net.Conn) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.options.MaxClients > 0 && len(this.connections) >= this.options.MaxClients {
+		return false
+	}
+
+	this.connections[conn] = struct{}{}
+
+	return true
+}
+
+// errNoDownloadsLeft is returned when a client asks to download a share
+// which has been downloaded MaxDownloads times.
+var errNoDownloadsLeft = errors.New("the share has already been downloaded the maximum number of times")
+
+// downloadsLeft reports whether shared can still be downloaded.
+func (this *Server) downloadsLeft(shared *Share) bool {
+	this.downloads.mutex.Lock()
+	defer this.downloads.mutex.Unlock()
+
+	return this.options.MaxDownloads <= 0 || this.downloads.started[shared.Name] < this.options.MaxDownloads
+}
+
+// reserveDownload claims one of shared's downloads for a client which has
+// accepted the manifest. It returns false if there are none left.
+func (this *Server) reserveDownload(shared *Share) bool {
+	this.downloads.mutex.Lock()
+	defer this.downloads.mutex.Unlock()
+
+	if this.options.MaxDownloads > 0 && this.downloads.started[shared.Name] >= this.options.MaxDownloads {
+		return false
+	}
+
+	this.downloads.started[shared.Name]++
+
+	return true
+}
+
+// finishDownload records the outcome of a download claimed with
+// reserveDownload. Failed downloads don't count towards MaxDownloads.
+func (this *Server) finishDownload(shared *Share, session *transferSession, succeeded bool) {
+	this.downloads.mutex.Lock()
+
+	if !succeeded {
+		this.downloads.started[shared.Name]--
+		this.downloads.mutex.Unlock()
+		return
+	}
+
+	this.downloads.finished[shared.Name]++
+	this.downloads.mutex.Unlock()
+
+	this.mutex.Lock()
+	this.result.Transfers++
+	this.result.FilesSent += session.filesSent
+	this.result.BytesSent += session.bytesSent
+	this.mutex.Unlock()
+}
+
+// shutdownIfDownloadLimitReached stops the server once MaxDownloads clients
+// have received every share.
+func (this *Server) shutdownIfDownloadLimitReached() {
+	this.downloads.mutex.Lock()
+	limitReached := this.options.MaxDownloads > 0
+
+	for name := range this.shares {
+		if this.downloads.finished[name] < this.options.MaxDownloads {
+			limitReached = false
+		}
+	}
+
+	this.downloads.mutex.Unlock()
+
+	if !limitReached {
+		return
+	}
+
+	what := "the share has"
+
+	if len(this.shares) > 1 {
+		what = "every share has"
+	}
+
+	if this.options.MaxDownloads == 1 {
+		this.shutdown(what + " been downloaded")
+	} else {
+		this.shutdown(fmt.Sprintf("%s been downloaded %d times", what, this.options.MaxDownloads))
+	}
+}
+
+// serveConnection handles a connection added with addConnection, and reports
+// any error that ends the transfer.
+func (this *Server) serveConnection(ctx context.Context, conn net.Conn) error {
+	err := this.handleIncomingConnection(ctx, conn)
+
+	this.mutex.Lock()
+	delete(this.connections, conn)
+	this.mutex.Unlock()
+
+	// Authentication failures have already been reported as such
+	if _, ok := err.(*authenticationError); err != nil && !ok {
+		this.options.Events.HandleEvent(events.Event{
+			Type:    events.Error,
+			Time:    time.Now(),
+			Address: conn.RemoteAddr().String(),
+			Message: err.Error(),
+		})
+	}
+
+	this.shutdownIfDownloadLimitReached()
+
+	return err
+}
+
+// Result returns a summary of what the server has sent so far.
+func (this *Server) Result() *Result {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	result := this.result
+
+	return &result
+}
+
+// StartServer shares filename on address and blocks until the server stops.
+//
+// Deprecated: use NewServer, which supports cancellation and more options.
+func StartServer(address string, filename string, password string, keepAlive bool, followSymlinks bool) error {
+	server := NewServer(Options{
+		Address:        address,
+		Filename:       filename,
+		Password:       password,
+		KeepAlive:      keepAlive,
+		FollowSymlinks: followSymlinks,
+	})
+
+	_, err := server.Serve(context.Background())
+
+	return err
+}
+
+func (this *Server) handleIncomingConnection(ctx context.Context, conn net.Conn) error {
 	defer conn.Close()
 
-	err := verifyPassword(conn, password)
+	transferCtx, cancel := util.WithOptionalTimeout(ctx, this.options.Timeout)
+	defer cancel()
+
+	// Abort the transfer if the context is cancelled or the transfer takes
+	// too long
+	stop := util.CloseOnCancel(transferCtx, conn)
+	defer stop()
+
+	timeoutConn := util.NewTimeoutConn(conn, this.options.IdleTimeout)
+	timeoutConn.StartHandshake(this.options.HandshakeTimeout)
+
+	session := newTransferSession(conn, this.options.Events)
+	session.emit(events.Event{Type: events.ClientConnected})
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := this.handshakeTLS(transferCtx, tlsConn); err != nil {
+			session.emit(events.Event{Type: events.ClientRejected, Message: err.Error()})
+			return &authenticationError{err}
+		}
+	}
+
+	var shared *Share
+	var shareErr error
+	var deriveErr error
+
+	peer, trusted, err := auth.VerifyClient(timeoutConn, auth.ServerOptions{
+		Identity: this.options.Identity,
+		Share: func(name string) *auth.ShareOptions {
+			session.shareName = name
+			shared, shareErr = this.findShare(name, conn.RemoteAddr())
+
+			if shareErr != nil {
+				return nil
+			}
+
+			return &auth.ShareOptions{
+				IsTrusted: func(peer identity.Peer) bool {
+					return !shared.RequirePassword && this.isTrusted(peer, session)
+				},
+				TrustedOnly: shared.TrustedOnly,
+				Password:    shared.Password,
+			}
+		},
+		Waiting: func() {
+			timeoutConn.StartHandshake(this.options.HandshakeTimeout)
+		},
+		DeriveKey: func(password string, salt []byte) ([]byte, error) {
+			if deriveErr = this.authLimiter.startDerivation(conn.RemoteAddr()); deriveErr != nil {
+				return nil, deriveErr
+			}
+
+			defer this.authLimiter.finishDerivation(conn.RemoteAddr())
+
+			return auth.DeriveKey(password, salt), nil
+		},
+	})
+
+	if deriveErr != nil {
+		session.emit(events.Event{Type: events.ClientRejected, Message: deriveErr.Error()})
+		return &authenticationError{deriveErr}
+	}
+
+	// Asking for share names counts as a failed attempt, so they can't be
+	// guessed
+	if _, ok := err.(*auth.UnknownShareError); ok {
+		attempts := this.authLimiter.recordFailure(conn.RemoteAddr())
+		session.emit(events.Event{Type: events.ClientRejected, Message: fmt.Sprintf("%s (%s)", shareErr, attempts)})
+		return &authenticationError{err}
+	}
+
+	if _, ok := err.(*auth.IncompatibleError); ok {
+		session.emit(events.Event{Type: events.ClientRejected, Message: err.Error()})
+		return &authenticationError{err}
+	}
+
+	if err == auth.ErrNotTrusted {
+		session.emit(events.Event{Type: events.ClientRejected, Message: fmt.Sprintf("%s is not a trusted peer", peer.Name)})
+		return &authenticationError{err}
+	}
+
+	if err == auth.ErrPasswordIncorrect {
+		attempts := this.authLimiter.recordFailure(conn.RemoteAddr())
+		session.emit(events.Event{Type: events.AuthFailed, Message: fmt.Sprintf("%s (%s)", err, attempts)})
+		return &authenticationError{err}
+	}
 
 	if err != nil {
-		return fmt.Errorf("Failed to verify password: %s", err)
+		return fmt.Errorf("authentication failed: %s", err)
 	}
 
-	fmt.Printf("Sending file(s) to %s...\n", conn.RemoteAddr())
-	err = sendObjectToClient(filename, conn, followSymlinks)
+	this.authLimiter.recordSuccess(conn.RemoteAddr())
+	session.peer = *peer
+	session.trusted = trusted
+
+	request, err := auth.AwaitRequest(timeoutConn)
 
 	if err != nil {
-		return fmt.Errorf("An error occurred when sending file: %s", err)
+		return err
+	}
+
+	session.path = request.Path
+	filename, saveAs, err := resolveRequest(shared, request)
+
+	if err != nil {
+		session.emit(events.Event{Type: events.ClientRejected, Message: err.Error()})
+		auth.Refuse(timeoutConn, err.Error())
+		return &authenticationError{err}
+	}
+
+	selection, err := share.ParseSelection(request.Only)
+
+	if err != nil {
+		return fmt.Errorf("the client sent an invalid request: %s", err)
+	}
+
+	timeoutConn.EndHandshake()
+
+	var approve func() bool
+
+	if this.options.Approve != nil {
+		approve = func() bool {
+			return this.approve(transferCtx, session, request, filename)
+		}
+	}
+
+	// Listing the share doesn't count as a download. Check there are
+	// downloads left before asking for approval, so nobody is asked to
+	// approve a transfer which can't happen
+	if !request.List && !this.downloadsLeft(shared) {
+		session.emit(events.Event{Type: events.ClientRejected, Message: errNoDownloadsLeft.Error()})
+		auth.Refuse(timeoutConn, errNoDownloadsLeft.Error())
+		return &authenticationError{errNoDownloadsLeft}
+	}
+
+	if approved, err := auth.Approve(timeoutConn, approve); err != nil {
+		return err
+	} else if !approved {
+		return &authenticationError{auth.ErrDeclined}
+	}
+
+	if request.List {
+		return sendListing(timeoutConn, filename, saveAs, shared.FollowSymlinks, selection, session)
+	}
+
+	err = this.sendManifestAndObjectToClient(shared, filename, saveAs, timeoutConn, selection, session)
+
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	} else if transferCtx.Err() != nil {
+		err = fmt.Errorf("transfer did not complete within %s", this.options.Timeout)
+	}
+
+	if session.downloadReserved {
+		this.finishDownload(shared, session, err == nil)
+	}
+
+	if err == errTransferDeclined {
+		session.emit(events.Event{Type: events.TransferDeclined, Message: err.Error()})
+		return nil
+	}
+
+	// Another client used the last download while this one was looking at
+	// the manifest
+	if err == errNoDownloadsLeft {
+		session.emit(events.Event{Type: events.ClientRejected, Message: err.Error()})
+		return &authenticationError{err}
+	}
+
+	if err != nil {
+		return err
 	}
 
-	fmt.Printf("File(s) sent to %s\n", conn.RemoteAddr())
+	session.emit(events.Event{
+		Type:       events.Summary,
+		FileCount:  session.filesSent,
+		TotalBytes: session.bytesSent,
+		DurationMs: events.Duration(time.Since(session.startTime)),
+	})
 
 	return nil
 }
 
-func verifyPassword(conn net.Conn, password string) error {
-	// Pad the password with '0' so that it's length is MAX_PASSWORD_LENGTH
-	for len(password) < values.MAX_PASSWORD_LENGTH {
-		password += "0"
+// resolveRequest returns the file or directory in shared to send to a
+// client which sent request, and the name to send it as, if it isn't the
+// file's own.
+func resolveRequest(shared *Share, request *types.Request) (filename string, saveAs string, err error) {
+	if !shared.ServeRoot {
+		if request.Path != "" {
+			return "", "", errors.New("this share is a single file or directory, so no path can be given")
+		}
+
+		return shared.Filename, "", nil
 	}
 
-	// Get the password from the client
-	guess := bytes.NewBuffer([]byte{})
-	_, err := io.CopyN(guess, conn, int64(len(password)))
+	return resolvePath(shared.Filename, request.Path)
+}
+
+// sendManifestAndObjectToClient sends the manifest, and once the client
+// accepts it, claims one of shared's downloads and sends the files.
+func (this *Server) sendManifestAndObjectToClient(shared *Share, filename string, destFilename string, conn *util.TimeoutConn, selection share.Selection, session *transferSession) error {
+	followSymlinks := shared.FollowSymlinks
+	manifest, err := buildManifest(filename, destFilename, followSymlinks, selection)
 
 	if err != nil {
-		return errors.New(fmt.Sprintf("Failed to read password from the client: %s", err))
+		return fmt.Errorf("Failed to build manifest: %s", err)
+	}
+
+	err = sendManifestToClient(manifest, conn)
+
+	if err != nil {
+		return fmt.Errorf("Failed to send manifest: %s", err)
+	}
+
+	// The client may want to check the manifest, or ask its user, first,
+	// which can take longer than the idle timeout. The download is only
+	// claimed once the client accepts
+	conn.IdleTimeout = 0
+	err = awaitManifestAnswer(conn)
+	conn.IdleTimeout = this.options.IdleTimeout
+
+	if err != nil {
+		return err
+	}
+
+	if !this.reserveDownload(shared) {
+		auth.Refuse(conn, errNoDownloadsLeft.Error())
+		return errNoDownloadsLeft
+	}
+
+	session.downloadReserved = true
+
+	if _, err := auth.Approve(conn, nil); err != nil {
+		return err
+	}
+
+	session.emit(events.Event{
+		Type:       events.TransferStarted,
+		File:       session.path,
+		FileCount:  manifest.FileCount,
+		TotalBytes: manifest.TotalSize,
+		Peer:       session.peer.Name,
+		User:       session.peer.User,
+		PublicKey:  identity.FormatPublicKey(session.peer.PublicKey),
+		Trusted:    session.trusted,
+	})
+
+	err = sendObjectToClientWithDest(filename, conn, destFilename, true, followSymlinks, selection, session)
+
+	if err != nil {
+		return fmt.Errorf("An error occurred when sending file: %s", err)
+	}
+
+	return nil
+}
+
+// sendListing sends the manifest to a client which only wants to list the
+// share.
+func sendListing(conn net.Conn, filename string, destFilename string, followSymlinks bool, selection share.Selection, session *transferSession) error {
+	manifest, err := buildManifest(filename, destFilename, followSymlinks, selection)
+
+	if err != nil {
+		return fmt.Errorf("Failed to build manifest: %s", err)
+	}
+
+	if err := sendManifestToClient(manifest, conn); err != nil {
+		return fmt.Errorf("Failed to send manifest: %s", err)
+	}
+
+	session.emit(events.Event{
+		Type:       events.ShareListed,
+		File:       session.path,
+		FileCount:  manifest.FileCount,
+		TotalBytes: manifest.TotalSize,
+		Peer:       session.peer.Name,
+		User:       session.peer.User,
+		PublicKey:  identity.FormatPublicKey(session.peer.PublicKey),
+		Trusted:    session.trusted,
+	})
+
+	return nil
+}
+
+// approve asks Options.Approve whether to grant the client's request, and
+// reports the client as rejected if not.
+func (this *Server) approve(ctx context.Context, session *transferSession, request *types.Request, filename string) bool {
+	approvalCtx, cancel := util.WithOptionalTimeout(ctx, this.options.ApprovalTimeout)
+	defer cancel()
+
+	session.emit(events.Event{
+		Type:      events.AwaitingApproval,
+		Peer:      session.peer.Name,
+		User:      session.peer.User,
+		PublicKey: identity.FormatPublicKey(session.peer.PublicKey),
+		Trusted:   session.trusted,
+	})
+
+	approved := this.options.Approve(approvalCtx, ApprovalRequest{
+		Address:  session.address,
+		Share:    session.shareName,
+		Peer:     session.peer,
+		Trusted:  session.trusted,
+		Filename: filename,
+		Path:     request.Path,
+		List:     request.List,
+		Only:     request.Only,
+	})
+
+	switch {
+	case approved && approvalCtx.Err() == nil:
+		return true
+	case ctx.Err() == nil && approvalCtx.Err() != nil:
+		session.emit(events.Event{Type: events.ClientRejected, Message: fmt.Sprintf("the transfer was not approved within %s", this.options.ApprovalTimeout)})
+	default:
+		session.emit(events.Event{Type: events.ClientRejected, Message: "the transfer was declined"})
+	}
+
+	return false
+}
+
+// isTrusted reports whether peer is in the server's trusted peers.
+func (this *Server) isTrusted(peer identity.Peer, session *transferSession) bool {
+	if this.options.TrustedPeers == nil {
+		return false
 	}
 
-	if string(string(guess.Bytes())) != password {
-		// Notify the client that password verification failed
-		io.CopyN(conn, bytes.NewBuffer([]byte{0}), 1)
-		return fmt.Errorf("Password is incorrect")
+	status, name := this.options.TrustedPeers.Check(peer.Name, peer.PublicKey)
+
+	switch status {
+	case identity.KeyChanged:
+		session.emit(events.Event{Type: events.Warning, Message: fmt.Sprintf("the client claims to be the trusted peer %q, but its key has changed, so it must use the password", peer.Name)})
+	case identity.NameChanged:
+		session.emit(events.Event{Type: events.Warning, Message: fmt.Sprintf("the client calls itself %q, but its key is trusted as %q", peer.Name, name)})
 	}
 
-	// Notify the client that password verification succeeded
-	_, err = io.CopyN(conn, bytes.NewBuffer([]byte{1}), 1)
+	return status == identity.Trusted || status == identity.NameChanged
+}
+
+// handshakeTLS completes the TLS handshake within the handshake timeout.
+func (this *Server) handshakeTLS(ctx context.Context, conn *tls.Conn) error {
+	handshakeCtx, cancel := util.WithOptionalTimeout(ctx, this.options.HandshakeTimeout)
+	defer cancel()
+
+	err := conn.HandshakeContext(handshakeCtx)
+
+	if err != nil && ctx.Err() == nil && handshakeCtx.Err() != nil {
+		return fmt.Errorf("TLS handshake did not complete within %s", this.options.HandshakeTimeout)
+	}
+
+	if _, ok := err.(tls.RecordHeaderError); ok {
+		return fmt.Errorf("the client is not using TLS")
+	}
 
 	if err != nil {
-		return fmt.Errorf("Failed to notify client of password verification result: %s", err)
+		return fmt.Errorf("TLS handshake failed: %s", err)
 	}
 
 	return nil
 }
 
-func sendObjectToClient(filename string, conn net.Conn, followSymlinks bool) error {
-	return sendObjectToClientWithDest(filename, conn, "", true, followSymlinks)
+// authenticationError is returned when a client fails to authenticate.
+type authenticationError struct {
+	err error
+}
+
+func (this *authenticationError) Error() string {
+	return fmt.Sprintf("Failed to verify password: %s", this.err)
+}
+
+// transferSession holds the state of a transfer to a single client.
+type transferSession struct {
+	address string
+	// peer is the client's identity, and trusted is true if it
+	// authenticated as a trusted peer rather than with the password
+	peer    identity.Peer
+	trusted bool
+	// shareName is the name of the share the client asked for, and path is
+	// the path it asked for inside a share root
+	shareName string
+	path      string
+	handler   events.Handler
+	startTime time.Time
+	filesSent int64
+	bytesSent int64
+	// downloadReserved is true once one of the share's downloads has been
+	// claimed for the client
+	downloadReserved bool
 }
 
-func sendObjectToClientWithDest(filename string, conn net.Conn, destFilename string, terminateConnectionOnCompletion bool, followSymlinks bool) error {
+func newTransferSession(conn net.Conn, handler events.Handler) *transferSession {
+	return &transferSession{
+		address:   conn.RemoteAddr().String(),
+		handler:   handler,
+		startTime: time.Now(),
+	}
+}
+
+// emit reports an event about this session's client.
+func (this *transferSession) emit(event events.Event) {
+	event.Time = time.Now()
+	event.Address = this.address
+	event.Share = this.shareName
+	this.handler.HandleEvent(event)
+}
+
+func sendObjectToClient(filename string, conn net.Conn, followSymlinks bool, selection share.Selection, session *transferSession) error {
+	return sendObjectToClientWithDest(filename, conn, "", true, followSymlinks, selection, session)
+}
+
+func sendObjectToClientWithDest(filename string, conn net.Conn, destFilename string, terminateConnectionOnCompletion bool, followSymlinks bool, selection share.Selection, session *transferSession) error {
 	file, err := os.Open(filename)
 
 	if err != nil {
@@ -115,9 +1135,19 @@ func sendObjectToClientWithDest(filename string, conn net.Conn, destFilename str
 
 	fileInfo, err := file.Stat()
 
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to get file info: %s", err))
+	}
+
 	if fileInfo.IsDir() {
 		// Send directory
 		err = filepath.Walk(filename, func(path string, info os.FileInfo, err error) error {
+			// The tree may change while it is being sent, in which case info
+			// is nil
+			if err != nil {
+				return errors.New(fmt.Sprintf("Failed to read '%s': %s", path, err))
+			}
+
 			if info.IsDir() {
 				return nil
 			}
@@ -137,6 +1167,10 @@ func sendObjectToClientWithDest(filename string, conn net.Conn, destFilename str
 			// Check if the FSO is a symlink and handle it appropriately
 			if info.Mode()&os.ModeSymlink != 0 {
 				if !followSymlinks {
+					if !selection.Includes(filepath.ToSlash(outputFilename)) {
+						return nil
+					}
+
 					err = sendSymlinkToClient(path, outputFilename, conn)
 
 					if err != nil {
@@ -157,12 +1191,17 @@ func sendObjectToClientWithDest(filename string, conn net.Conn, destFilename str
 					linkTarget = filepath.Clean(filepath.Join(filepath.Dir(path), linkTarget))
 				}
 
-				err = sendObjectToClientWithDest(linkTarget, conn, outputFilename, false, followSymlinks)
+				err = sendObjectToClientWithDest(linkTarget, conn, outputFilename, false, followSymlinks, selection, session)
 
 				return err
 			}
 
-			err = sendFileToClient(path, outputFilename, conn)
+			// Only send the files the client asked for
+			if !selection.Includes(filepath.ToSlash(outputFilename)) {
+				return nil
+			}
+
+			err = sendFileToClient(path, outputFilename, conn, session)
 
 			if err != nil {
 				return errors.New(fmt.Sprintf("Failed to send file to client '%s': %s", path, err))
@@ -175,7 +1214,10 @@ func sendObjectToClientWithDest(filename string, conn net.Conn, destFilename str
 		if destFilename == "" {
 			destFilename = filepath.Base(filename)
 		}
-		err = sendFileToClient(filename, destFilename, conn)
+
+		if selection.Includes(filepath.ToSlash(destFilename)) {
+			err = sendFileToClient(filename, destFilename, conn, session)
+		}
 	}
 
 	if err != nil {
@@ -196,7 +1238,7 @@ func sendObjectToClientWithDest(filename string, conn net.Conn, destFilename str
 	return nil
 }
 
-func sendFileToClient(srcFilename string, destFilename string, conn net.Conn) error {
+func sendFileToClient(srcFilename string, destFilename string, conn net.Conn, session *transferSession) error {
 	file, err := os.Open(srcFilename)
 
 	if err != nil {
@@ -238,7 +1280,17 @@ func sendFileToClient(srcFilename string, destFilename string, conn net.Conn) er
 		return errors.New(fmt.Sprintf("Failed to send file size to the client: %s", err))
 	}
 
-	reader := bufio.NewReader(file)
+	session.emit(events.Event{Type: events.FileStarted, File: destFilename, Size: fileInfo.Size()})
+
+	// Report each chunk that is sent and calculate the file's checksum as it
+	// is read
+	hash := sha256.New()
+	reader := &util.ProgressReader{
+		Reader: io.TeeReader(bufio.NewReader(file), hash),
+		ProgressCallback: func(bytesCopied int64) {
+			session.emit(events.Event{Type: events.Progress, File: destFilename, Size: fileInfo.Size(), Bytes: bytesCopied})
+		},
+	}
 
 	// Send the file to the client
 	_, err = io.CopyN(conn, reader, fileInfo.Size())
@@ -247,6 +1299,16 @@ func sendFileToClient(srcFilename string, destFilename string, conn net.Conn) er
 		return errors.New(fmt.Sprintf("Failed to send file to the client: %s", err))
 	}
 
+	session.filesSent++
+	session.bytesSent += fileInfo.Size()
+	session.emit(events.Event{
+		Type:   events.FileDone,
+		File:   destFilename,
+		Size:   fileInfo.Size(),
+		Bytes:  fileInfo.Size(),
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	})
+
 	return nil
 }
 
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aiden-deloryn/hoist/src/events"
+)
+
+func TestShutdownWhileServing(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "a.txt")
+
+	if err := os.WriteFile(filename, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer(Options{Address: "127.0.0.1:0", Filename: filename, Password: "secret", KeepAlive: true})
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := server.Serve(context.Background())
+		done <- err
+	}()
+
+	// Shut down while Serve may still be listening
+	server.Shutdown()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return after Shutdown")
+	}
+}
+
+func TestSendDirectoryChangingDuringWalk(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "root")
+
+	if err := os.MkdirAll(filepath.Join(root, "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a.txt", filepath.Join("b", "c.txt")} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	// a.txt is sent first, so b is removed before the walk reaches it
+	go func() {
+		header := make([]byte, 8)
+
+		if _, err := io.ReadFull(clientConn, header); err == nil {
+			os.RemoveAll(filepath.Join(root, "b"))
+		}
+
+		io.Copy(io.Discard, clientConn)
+	}()
+
+	err := sendObjectToClient(root, serverConn, false, nil, newTransferSession(serverConn, events.Discard))
+	serverConn.Close()
+
+	if err == nil {
+		t.Error("a directory removed during the walk wasn't reported")
+	}
+}
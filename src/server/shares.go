@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+
+	"github.com/aiden-deloryn/hoist/src/share"
+)
+
+// buildShares checks the shares described by options, and returns them by
+// name. The unnamed share described by Options.Filename has an empty name.
+func buildShares(options Options) (map[string]*Share, error) {
+	shares := map[string]*Share{}
+
+	if options.Filename != "" {
+		shares[""] = &Share{
+			Filename:       options.Filename,
+			ServeRoot:      options.ServeRoot,
+			FollowSymlinks: options.FollowSymlinks,
+			Password:       options.Password,
+			TrustedOnly:    options.TrustedOnly,
+		}
+	}
+
+	for i := range options.Shares {
+		named := &options.Shares[i]
+
+		if err := share.CheckShareName(named.Name); err != nil {
+			return nil, err
+		}
+
+		if _, ok := shares[named.Name]; ok {
+			return nil, fmt.Errorf("there is more than one share called %q", named.Name)
+		}
+
+		shares[named.Name] = named
+	}
+
+	if len(shares) == 0 {
+		return nil, errors.New("nothing to share")
+	}
+
+	for name, shared := range shares {
+		if err := checkShare(shared); err != nil {
+			if name == "" {
+				return nil, err
+			}
+
+			return nil, fmt.Errorf("share %q: %s", name, err)
+		}
+	}
+
+	return shares, nil
+}
+
+// checkShare returns an error if shared can't be served.
+func checkShare(shared *Share) error {
+	info, err := os.Stat(shared.Filename)
+
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", shared.Filename, err)
+	}
+
+	if !shared.ServeRoot {
+		return nil
+	}
+
+	if shared.FollowSymlinks {
+		return errors.New("symlinks can't be followed when serving a directory")
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", shared.Filename)
+	}
+
+	return nil
+}
+
+// findShare returns the share called name, or an error if there is no such
+// share or the client connecting from address may not use it. The client is
+// told that the share doesn't exist either way.
+func (this *Server) findShare(name string, address net.Addr) (*Share, error) {
+	shared := this.shares[name]
+
+	if shared == nil && name == "" {
+		return nil, errors.New("the client didn't ask for a share by name")
+	}
+
+	if shared == nil {
+		return nil, fmt.Errorf("there is no share called %q", name)
+	}
+
+	if err := checkAccess(shared.Allow, shared.Deny, address); err != nil {
+		return nil, fmt.Errorf("%s for share %q", err, name)
+	}
+
+	return shared, nil
+}
+
+// shareNames returns the names of the shares, in order, or nil if there is
+// only the unnamed share. The unnamed share's empty name comes first.
+func (this *Server) shareNames() []string {
+	if _, ok := this.shares[""]; ok && len(this.shares) == 1 {
+		return nil
+	}
+
+	var names []string
+
+	for name := range this.shares {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildShares(t *testing.T) {
+	directory := t.TempDir()
+	file := filepath.Join(directory, "a.txt")
+
+	if err := os.WriteFile(file, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description string
+		options     Options
+		names       []string
+		// err is part of the expected error, or empty if there is none
+		err string
+	}{
+		{"unnamed share", Options{Filename: file}, []string{""}, ""},
+		{"named shares", Options{Filename: file, Shares: []Share{{Name: "a", Filename: file}, {Name: "b", Filename: directory}}}, []string{"", "a", "b"}, ""},
+		{"nothing to share", Options{}, nil, "nothing to share"},
+		{"duplicate names", Options{Shares: []Share{{Name: "a", Filename: file}, {Name: "a", Filename: directory}}}, nil, "more than one share called \"a\""},
+		{"invalid name", Options{Shares: []Share{{Name: "a/b", Filename: file}}}, nil, "invalid share name"},
+		{"missing file", Options{Shares: []Share{{Name: "a", Filename: file + ".missing"}}}, nil, "share \"a\": failed to read"},
+		{"serving a file", Options{Shares: []Share{{Name: "a", Filename: file, ServeRoot: true}}}, nil, "is not a directory"},
+	}
+
+	for _, test := range tests {
+		shares, err := buildShares(test.options)
+
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got %v, want an error containing %q", test.description, err, test.err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", test.description, err)
+			continue
+		}
+
+		for _, name := range test.names {
+			if shares[name] == nil {
+				t.Errorf("%s: there is no share called %q", test.description, name)
+			}
+		}
+
+		if len(shares) != len(test.names) {
+			t.Errorf("%s: got %d shares, want %d", test.description, len(shares), len(test.names))
+		}
+	}
+}
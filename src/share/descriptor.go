@@ -0,0 +1,172 @@
+package share
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// DESCRIPTOR_PREFIX starts every share descriptor, e.g.
+// "hoist://192.168.1.10:47478,[fd00::2]:47478?fingerprint=9f86d0...".
+// The name of a share can be given after an address, e.g.
+// "192.168.1.10:47478/builds", and a path inside a share root (see hoist
+// serve) after that, e.g. "192.168.1.10:47478:projects/alpha". Both can also
+// be given with share and path parameters.
+const DESCRIPTOR_PREFIX = "hoist://"
+
+// Descriptor describes how to reach a share.
+type Descriptor struct {
+	// Addresses are the addresses the share may be reachable on, in order of
+	// preference
+	Addresses []string
+	// Fingerprint is the SHA-256 fingerprint of the share's TLS
+	// certificate, or empty if the share doesn't use TLS
+	Fingerprint string
+	// Share is the name of the share to ask for, or empty for the server's
+	// unnamed share
+	Share string
+	// Path is the path to ask for inside a share root, using '/' as the
+	// path separator, or empty
+	Path string
+}
+
+// CheckShareName returns an error if name can't be used as the name of a
+// share. Names are made of letters, digits, '.', '_' and '-'.
+func CheckShareName(name string) error {
+	if name == "" || len(name) > 64 {
+		return fmt.Errorf("share names must be between 1 and 64 characters long")
+	}
+
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return fmt.Errorf("invalid share name %q, use only letters, digits, '.', '_' and '-'", name)
+		}
+	}
+
+	return nil
+}
+
+// ParseDescriptor parses a share descriptor, or a comma-separated list of
+// addresses, or a single address.
+func ParseDescriptor(descriptor string) (*Descriptor, error) {
+	result := &Descriptor{}
+	seen := map[string]bool{}
+	addresses := strings.TrimPrefix(descriptor, DESCRIPTOR_PREFIX)
+
+	if i := strings.Index(addresses, "?"); i >= 0 {
+		parameters, err := url.ParseQuery(addresses[i+1:])
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid share descriptor: %s", err)
+		}
+
+		result.Fingerprint = parameters.Get("fingerprint")
+		result.Share = parameters.Get("share")
+		result.Path = parameters.Get("path")
+		addresses = addresses[:i]
+	}
+
+	for _, address := range strings.Split(addresses, ",") {
+		address, shareName, path := splitAddress(strings.TrimSpace(address))
+
+		if shareName != "" {
+			if result.Share != "" && result.Share != shareName {
+				return nil, fmt.Errorf("the addresses ask for different shares: %q and %q", result.Share, shareName)
+			}
+
+			result.Share = shareName
+		}
+
+		if path != "" {
+			if result.Path != "" && result.Path != path {
+				return nil, fmt.Errorf("the addresses ask for different paths: %q and %q", result.Path, path)
+			}
+
+			result.Path = path
+		}
+
+		if address == "" || seen[address] {
+			continue
+		}
+
+		seen[address] = true
+
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return nil, fmt.Errorf("invalid address %q: %s", address, err)
+		}
+
+		result.Addresses = append(result.Addresses, address)
+	}
+
+	if len(result.Addresses) == 0 {
+		return nil, fmt.Errorf("no address given")
+	}
+
+	if result.Share != "" {
+		if err := CheckShareName(result.Share); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (this *Descriptor) String() string {
+	descriptor := DESCRIPTOR_PREFIX + strings.Join(this.Addresses, ",")
+
+	parameters := url.Values{}
+
+	if this.Fingerprint != "" {
+		parameters.Set("fingerprint", this.Fingerprint)
+	}
+
+	if this.Share != "" {
+		parameters.Set("share", this.Share)
+	}
+
+	if this.Path != "" {
+		parameters.Set("path", this.Path)
+	}
+
+	if len(parameters) > 0 {
+		descriptor += "?" + parameters.Encode()
+	}
+
+	return descriptor
+}
+
+// splitAddress splits "host:port/share:path" into "host:port", "share" and
+// "path", where the share and path are optional. The host may be an IPv6
+// address in brackets.
+func splitAddress(address string) (hostPort string, shareName string, path string) {
+	start := 0
+
+	if strings.HasPrefix(address, "[") {
+		if end := strings.Index(address, "]"); end >= 0 {
+			start = end
+		}
+	}
+
+	portStart := strings.Index(address[start:], ":")
+
+	if portStart < 0 {
+		return address, "", ""
+	}
+
+	portStart += start + 1
+	portEnd := strings.IndexAny(address[portStart:], "/:")
+
+	if portEnd < 0 {
+		return address, "", ""
+	}
+
+	hostPort, rest := address[:portStart+portEnd], address[portStart+portEnd:]
+
+	if strings.HasPrefix(rest, "/") {
+		shareName, path, _ = strings.Cut(rest[1:], ":")
+		return hostPort, shareName, path
+	}
+
+	return hostPort, "", rest[1:]
+}
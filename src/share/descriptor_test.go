@@ -0,0 +1,52 @@
+package share
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDescriptor(t *testing.T) {
+	tests := []struct {
+		descriptor string
+		parsed     *Descriptor
+		valid      bool
+	}{
+		{"192.168.1.10:7000", &Descriptor{Addresses: []string{"192.168.1.10:7000"}}, true},
+		{"192.168.1.10:7000/builds", &Descriptor{Addresses: []string{"192.168.1.10:7000"}, Share: "builds"}, true},
+		{"192.168.1.10:7000/builds:nightly/x86", &Descriptor{Addresses: []string{"192.168.1.10:7000"}, Share: "builds", Path: "nightly/x86"}, true},
+		{"192.168.1.10:7000:projects/alpha", &Descriptor{Addresses: []string{"192.168.1.10:7000"}, Path: "projects/alpha"}, true},
+		{"[fd00::2]:7000/builds", &Descriptor{Addresses: []string{"[fd00::2]:7000"}, Share: "builds"}, true},
+		{
+			"hoist://192.168.1.10:7000,[fd00::2]:7000,192.168.1.10:7000?fingerprint=ab12&share=builds",
+			&Descriptor{Addresses: []string{"192.168.1.10:7000", "[fd00::2]:7000"}, Fingerprint: "ab12", Share: "builds"},
+			true,
+		},
+		{"192.168.1.10:7000/builds,[fd00::2]:7000/docs", nil, false},
+		{"192.168.1.10:7000/bad name", nil, false},
+		{"192.168.1.10", nil, false},
+		{"", nil, false},
+	}
+
+	for _, test := range tests {
+		parsed, err := ParseDescriptor(test.descriptor)
+
+		if (err == nil) != test.valid {
+			t.Errorf("%q: got error %v, want valid to be %t", test.descriptor, err, test.valid)
+		} else if test.valid && !reflect.DeepEqual(parsed, test.parsed) {
+			t.Errorf("%q: got %+v, want %+v", test.descriptor, parsed, test.parsed)
+		}
+	}
+}
+
+func TestDescriptorString(t *testing.T) {
+	descriptor := &Descriptor{Addresses: []string{"192.168.1.10:7000", "[fd00::2]:7000"}, Fingerprint: "ab12", Share: "builds", Path: "a b/c"}
+	parsed, err := ParseDescriptor(descriptor.String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(parsed, descriptor) {
+		t.Errorf("%q parsed to %+v, want %+v", descriptor.String(), parsed, descriptor)
+	}
+}
@@ -0,0 +1,130 @@
+package share
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Selection chooses some of the files in a share using glob patterns. An
+// empty selection includes everything.
+//
+// Patterns use '/' as the path separator and support *, ? and [...] as in
+// path.Match, and ** to match any number of directories. A pattern without
+// a '/', such as "*.pdf", matches a file or directory of that name at any
+// depth. Other patterns, such as "photos/2024/**", match paths from the
+// root of the share, with or without the name of the shared directory in
+// front. Patterns starting with '/' must include the name of the shared
+// directory. Selecting a directory selects everything inside it.
+type Selection []string
+
+// ParseSelection checks patterns and returns them as a Selection.
+func ParseSelection(patterns []string) (Selection, error) {
+	selection := Selection{}
+
+	for _, pattern := range patterns {
+		anchored := strings.HasPrefix(pattern, "/")
+		pattern = strings.Trim(pattern, "/")
+
+		if pattern == "" {
+			return nil, fmt.Errorf("empty pattern")
+		}
+
+		for _, component := range strings.Split(pattern, "/") {
+			if _, err := path.Match(component, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+			}
+		}
+
+		if anchored {
+			pattern = "/" + pattern
+		}
+
+		selection = append(selection, pattern)
+	}
+
+	return selection, nil
+}
+
+// Includes reports whether the file or directory called name, using '/' as
+// the path separator, is selected.
+func (this Selection) Includes(name string) bool {
+	if len(this) == 0 {
+		return true
+	}
+
+	components := strings.Split(name, "/")
+
+	for _, pattern := range this {
+		if !strings.Contains(pattern, "/") {
+			for _, component := range components {
+				if matched, _ := path.Match(pattern, component); matched {
+					return true
+				}
+			}
+
+			continue
+		}
+
+		// Anything inside a selected directory is selected too
+		patternComponents := append(strings.Split(strings.TrimPrefix(pattern, "/"), "/"), "**")
+		starts := 2
+
+		if strings.HasPrefix(pattern, "/") {
+			starts = 1
+		}
+
+		// Try with and without the name of the shared directory
+		for start := 0; start < len(components) && start < starts; start++ {
+			if matchComponents(patternComponents, components[start:]) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// matchComponents matches a pattern against a path, both split into their
+// components. It takes time proportional to the product of their lengths,
+// however many ** the pattern contains.
+func matchComponents(pattern []string, name []string) bool {
+	// matches[j] is whether pattern[i:] matches name[j:], working backwards
+	// from the end of the pattern
+	matches := make([]bool, len(name)+1)
+	matches[len(name)] = true
+
+	for i := len(pattern) - 1; i >= 0; i-- {
+		next := make([]bool, len(name)+1)
+
+		for j := len(name); j >= 0; j-- {
+			if pattern[i] == "**" {
+				next[j] = matches[j] || (j < len(name) && next[j+1])
+			} else if j < len(name) {
+				matched, _ := path.Match(pattern[i], name[j])
+				next[j] = matched && matches[j+1]
+			}
+		}
+
+		matches = next
+	}
+
+	return matches[0]
+}
+
+// EscapePattern escapes the characters in name which have a special meaning
+// in patterns, so that "/" + EscapePattern(name) selects name and nothing
+// else, or everything inside name if it is a directory.
+func EscapePattern(name string) string {
+	var escaped strings.Builder
+
+	for _, r := range name {
+		if strings.ContainsRune("*?[\\", r) {
+			escaped.WriteRune('\\')
+		}
+
+		escaped.WriteRune(r)
+	}
+
+	return escaped.String()
+}
@@ -0,0 +1,92 @@
+package share
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSelection(t *testing.T) {
+	tests := []struct {
+		patterns  []string
+		selection Selection
+		err       bool
+	}{
+		{nil, Selection{}, false},
+		{[]string{"*.pdf", "photos/2024/**"}, Selection{"*.pdf", "photos/2024/**"}, false},
+		{[]string{"docs/", "/root/docs/"}, Selection{"docs", "/root/docs"}, false},
+		{[]string{""}, nil, true},
+		{[]string{"/"}, nil, true},
+		{[]string{"docs/[a"}, nil, true},
+	}
+
+	for _, test := range tests {
+		selection, err := ParseSelection(test.patterns)
+
+		if (err != nil) != test.err {
+			t.Errorf("%q: got error %v, want error %t", test.patterns, err, test.err)
+		} else if !test.err && !reflect.DeepEqual(selection, test.selection) {
+			t.Errorf("%q: got %q, want %q", test.patterns, selection, test.selection)
+		}
+	}
+}
+
+func TestSelectionIncludes(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		name     string
+		included bool
+	}{
+		{"*.pdf", "root/a/b.pdf", true},
+		{"*.pdf", "root/b.txt", false},
+		{"*.pdf", "root/old.pdf/b.txt", true},
+		{"photos/2024/**", "root/photos/2024/a.jpg", true},
+		{"photos/2024/**", "photos/2024/a.jpg", true},
+		{"photos/2024/**", "root/photos/2024", true},
+		{"photos/2024/**", "root/backup/photos/2024/a.jpg", false},
+		{"photos/2024/**", "root/photos/2023/a.jpg", false},
+		{"docs", "root/docs/a/b.txt", true},
+		{"a/**/b", "root/a/b", true},
+		{"a/**/b", "root/a/x/y/b", true},
+		{"a/**/b", "root/a/x/y/c", false},
+		{"a/?.txt", "root/a/1.txt", true},
+		{"a/?.txt", "root/a/10.txt", false},
+		{"/root/docs", "root/docs/a.txt", true},
+		{"/root/docs", "docs/a.txt", false},
+		{"/root/docs", "other/docs/a.txt", false},
+	}
+
+	for _, test := range tests {
+		selection, err := ParseSelection([]string{test.pattern})
+
+		if err != nil {
+			t.Fatalf("%q: %s", test.pattern, err)
+		}
+
+		if included := selection.Includes(test.name); included != test.included {
+			t.Errorf("%q includes %q: got %t, want %t", test.pattern, test.name, included, test.included)
+		}
+	}
+
+	if !(Selection{}).Includes("root/anything") {
+		t.Error("an empty selection doesn't include everything")
+	}
+}
+
+func TestEscapePattern(t *testing.T) {
+	selection, err := ParseSelection([]string{"/" + EscapePattern("root/a*[1]?.txt")})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, included := range map[string]bool{
+		"root/a*[1]?.txt":   true,
+		"root/a*[1]?.txt/b": true,
+		"root/ab1c.txt":     false,
+		"root/a*1?.txt":     false,
+	} {
+		if selection.Includes(name) != included {
+			t.Errorf("%q includes %q: got %t, want %t", selection, name, !included, included)
+		}
+	}
+}
@@ -1,6 +1,40 @@
 package types
 
+import "time"
+
 type SymlinkMetadata struct {
 	Name   string `json:"name,omitempty"`
 	Target string `json:"target,omitempty"`
 }
+
+// Request is sent by the client once it has authenticated, to say what it
+// wants from the share.
+type Request struct {
+	// List asks for the manifest only, without any files
+	List bool `json:"list,omitempty"`
+	// Only, if not empty, limits the share to the files matching these
+	// patterns (see share.Selection)
+	Only []string `json:"only,omitempty"`
+	// Path names the file or directory to send inside a share root, using
+	// '/' as the path separator
+	Path string `json:"path,omitempty"`
+}
+
+// Manifest lists what the server will send. Only Files are counted in
+// TotalSize and FileCount. Directories and Symlinks are listed so the share
+// can be browsed.
+type Manifest struct {
+	TotalSize   int64           `json:"totalSize"`
+	FileCount   int64           `json:"fileCount"`
+	Files       []ManifestEntry `json:"files,omitempty"`
+	Directories []ManifestEntry `json:"directories,omitempty"`
+	Symlinks    []ManifestEntry `json:"symlinks,omitempty"`
+}
+
+type ManifestEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	// Target is the target of a symlink
+	Target string `json:"target,omitempty"`
+}
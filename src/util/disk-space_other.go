@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package util
+
+// GetAvailableDiskSpace is not supported on this platform.
+func GetAvailableDiskSpace(path string) (int64, error) {
+	return 0, ErrUnsupportedPlatform
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+
+package util
+
+import "golang.org/x/sys/unix"
+
+// GetAvailableDiskSpace returns the number of bytes available to an
+// unprivileged user on the filesystem containing path.
+func GetAvailableDiskSpace(path string) (int64, error) {
+	var stat unix.Statfs_t
+
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+
+	return int64(uint64(stat.Bavail) * uint64(stat.Bsize)), nil
+}
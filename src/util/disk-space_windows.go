@@ -0,0 +1,23 @@
+//go:build windows
+
+package util
+
+import "golang.org/x/sys/windows"
+
+// GetAvailableDiskSpace returns the number of bytes available to the
+// current user on the volume containing path.
+func GetAvailableDiskSpace(path string) (int64, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var freeBytesAvailable, totalBytes, totalFreeBytes uint64
+
+	if err := windows.GetDiskFreeSpaceEx(pathPtr, &freeBytesAvailable, &totalBytes, &totalFreeBytes); err != nil {
+		return 0, err
+	}
+
+	return int64(freeBytesAvailable), nil
+}
@@ -0,0 +1,98 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+// GetInterfaceAddresses returns the IPv4 and IPv6 addresses which other
+// computers can use to reach this one, formatted as hosts for
+// net.JoinHostPort. Link-local IPv6 addresses include the interface name as
+// a zone, e.g. "fe80::1%eth0". If interfaceName is empty every interface
+// which is up is included, and loopback addresses are only returned when
+// there are no others.
+func GetInterfaceAddresses(interfaceName string) ([]string, error) {
+	interfaces, err := net.Interfaces()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list network interfaces: %s", err)
+	}
+
+	var addresses, loopback []net.IPAddr
+	found := false
+
+	for _, iface := range interfaces {
+		if interfaceName != "" && iface.Name != interfaceName {
+			continue
+		}
+
+		found = true
+
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+
+		interfaceAddresses, err := iface.Addrs()
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to get addresses of %s: %s", iface.Name, err)
+		}
+
+		for _, interfaceAddress := range interfaceAddresses {
+			ipNet, ok := interfaceAddress.(*net.IPNet)
+
+			if !ok {
+				continue
+			}
+
+			address := net.IPAddr{IP: ipNet.IP}
+
+			// Link-local IPv6 addresses are only meaningful alongside the
+			// interface they belong to
+			if ipNet.IP.IsLinkLocalUnicast() && ipNet.IP.To4() == nil {
+				address.Zone = iface.Name
+			}
+
+			if ipNet.IP.IsLoopback() {
+				loopback = append(loopback, address)
+			} else {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("there is no network interface named %q", interfaceName)
+	}
+
+	if len(addresses) == 0 || interfaceName != "" {
+		addresses = append(addresses, loopback...)
+	}
+
+	// List IPv4 addresses first, then global IPv6 addresses and finally
+	// link-local ones, since that is roughly the order people will want to
+	// try them in
+	sort.SliceStable(addresses, func(i, j int) bool {
+		return addressRank(addresses[i].IP) < addressRank(addresses[j].IP)
+	})
+
+	hosts := make([]string, len(addresses))
+
+	for i, address := range addresses {
+		hosts[i] = address.String()
+	}
+
+	return hosts, nil
+}
+
+func addressRank(ip net.IP) int {
+	switch {
+	case ip.To4() != nil:
+		return 0
+	case !ip.IsLinkLocalUnicast():
+		return 1
+	default:
+		return 2
+	}
+}
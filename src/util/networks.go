@@ -0,0 +1,46 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ParseNetworks parses a list of CIDR networks, e.g. "10.20.0.0/16", and IP
+// addresses, which are treated as networks containing only that address.
+func ParseNetworks(list []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+
+	for _, text := range list {
+		text = strings.TrimSpace(text)
+
+		if text == "" {
+			continue
+		}
+
+		if strings.Contains(text, "/") {
+			_, network, err := net.ParseCIDR(text)
+
+			if err != nil {
+				return nil, fmt.Errorf("invalid network %q, expected an IP address or CIDR network such as 10.20.0.0/16", text)
+			}
+
+			networks = append(networks, network)
+			continue
+		}
+
+		ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(text, "["), "]"))
+
+		if ip == nil {
+			return nil, fmt.Errorf("invalid network %q, expected an IP address or CIDR network such as 10.20.0.0/16", text)
+		}
+
+		if ipv4 := ip.To4(); ipv4 != nil {
+			ip = ipv4
+		}
+
+		networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+	}
+
+	return networks, nil
+}
@@ -0,0 +1,20 @@
+//go:build linux
+
+package util
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Preallocate reserves size bytes of disk space for file using fallocate, so
+// the filesystem can lay the file out contiguously and a full disk is
+// reported before any data is written.
+func Preallocate(file *os.File, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+
+	return unix.Fallocate(int(file.Fd()), 0, 0, size)
+}
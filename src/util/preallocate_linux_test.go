@@ -0,0 +1,44 @@
+package util
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestPreallocate(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "file"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	err = Preallocate(file, 1<<20)
+
+	if errors.Is(err, unix.EOPNOTSUPP) {
+		t.Skip("the filesystem doesn't support fallocate")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := file.Stat()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Size() != 1<<20 {
+		t.Errorf("the file is %d bytes, want %d", info.Size(), 1<<20)
+	}
+
+	// The space is really reserved, unlike a sparse file
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Blocks*512 < 1<<20 {
+		t.Errorf("only %d bytes were allocated", stat.Blocks*512)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package util
+
+import "os"
+
+// Preallocate is not supported on this platform.
+func Preallocate(file *os.File, size int64) error {
+	return ErrUnsupportedPlatform
+}
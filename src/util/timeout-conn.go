@@ -0,0 +1,85 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// TimeoutConn wraps a net.Conn and fails any read or write once the
+// connection has been idle for longer than IdleTimeout, or once the
+// handshake deadline has passed.
+type TimeoutConn struct {
+	net.Conn
+	IdleTimeout time.Duration
+
+	handshakeTimeout  time.Duration
+	handshakeDeadline time.Time
+}
+
+func NewTimeoutConn(conn net.Conn, idleTimeout time.Duration) *TimeoutConn {
+	return &TimeoutConn{
+		Conn:        conn,
+		IdleTimeout: idleTimeout,
+	}
+}
+
+// StartHandshake limits the time allowed before EndHandshake is called. A
+// timeout of 0 means there is no limit.
+func (this *TimeoutConn) StartHandshake(timeout time.Duration) {
+	this.handshakeTimeout = timeout
+
+	if timeout > 0 {
+		this.handshakeDeadline = time.Now().Add(timeout)
+	}
+}
+
+// EndHandshake removes the deadline set by StartHandshake.
+func (this *TimeoutConn) EndHandshake() {
+	this.handshakeTimeout = 0
+	this.handshakeDeadline = time.Time{}
+	this.extendDeadline()
+}
+
+func (this *TimeoutConn) Read(p []byte) (int, error) {
+	this.extendDeadline()
+	n, err := this.Conn.Read(p)
+
+	return n, this.translateError(err)
+}
+
+func (this *TimeoutConn) Write(p []byte) (int, error) {
+	this.extendDeadline()
+	n, err := this.Conn.Write(p)
+
+	return n, this.translateError(err)
+}
+
+func (this *TimeoutConn) extendDeadline() {
+	deadline := time.Time{}
+
+	if this.IdleTimeout > 0 {
+		deadline = time.Now().Add(this.IdleTimeout)
+	}
+
+	if !this.handshakeDeadline.IsZero() && (deadline.IsZero() || this.handshakeDeadline.Before(deadline)) {
+		deadline = this.handshakeDeadline
+	}
+
+	this.Conn.SetDeadline(deadline)
+}
+
+// translateError replaces the generic "i/o timeout" error with one that
+// explains which timeout was exceeded.
+func (this *TimeoutConn) translateError(err error) error {
+	if err == nil || !os.IsTimeout(err) {
+		return err
+	}
+
+	if !this.handshakeDeadline.IsZero() && !time.Now().Before(this.handshakeDeadline) {
+		return fmt.Errorf("handshake did not complete within %s", this.handshakeTimeout)
+	}
+
+	return fmt.Errorf("connection was idle for more than %s", this.IdleTimeout)
+}
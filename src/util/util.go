@@ -1,52 +1,160 @@
 package util
 
 import (
+	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
-	"net"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func GetLocalIPAddress() (string, error) {
-	var localAddress string
-	conn, err := net.Dial("udp", "1.1.1.1:80")
+var ErrUnsupportedPlatform = errors.New("not supported on this platform")
 
-	if err != nil {
-		return localAddress, err
+// FormatByteSize converts a number of bytes into a human readable string
+// using binary units, e.g. 1536 becomes "1.5 KiB".
+func FormatByteSize(size int64) string {
+	const unit = 1024
+
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// ParseByteSize parses a size such as "10G", "500MiB" or "1024". Units are
+// binary, so "1K" is 1024 bytes.
+func ParseByteSize(text string) (int64, error) {
+	number := strings.TrimSpace(text)
+	number = strings.TrimSuffix(strings.TrimSuffix(number, "B"), "i")
+	multiplier := int64(1)
+
+	if i := strings.IndexAny(number, "KMGTPEkmgtpe"); i >= 0 && i == len(number)-1 {
+		multiplier = 1 << (10 * (strings.IndexByte("KMGTPE", strings.ToUpper(number)[i]) + 1))
+		number = number[:i]
 	}
 
-	localAddress = conn.LocalAddr().(*net.UDPAddr).IP.String()
+	value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+
+	// ParseFloat accepts "NaN" and "Inf", which aren't sizes. float64 can't
+	// hold MaxInt64, which rounds up to 2^63, so anything from 2^63 up
+	// would overflow
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) || value < 0 || value*float64(multiplier) >= math.Exp2(63) {
+		return 0, fmt.Errorf("invalid size %q, expected a number of bytes or a size such as 10G", text)
+	}
 
-	return localAddress, nil
+	return int64(value * float64(multiplier)), nil
 }
 
-func GenerateProgressBarString(progress int) string {
-	progressBarSize := 20
-	progressBarValue := progress / (100 / progressBarSize)
-	progressBarString := ""
+// FormatCount formats n with commas between each group of three digits,
+// e.g. 4210 becomes "4,210".
+func FormatCount(n int64) string {
+	digits := strconv.FormatInt(n, 10)
+	sign := ""
 
-	// Populate the current progress with '='
-	for i := 0; i < progressBarValue; i++ {
-		progressBarString += "="
+	if n < 0 {
+		sign, digits = "-", digits[1:]
 	}
 
-	// Pad the remaining progress with ' '
-	for i := 0; i < progressBarSize-progressBarValue; i++ {
-		progressBarString += " "
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + "," + digits[i:]
 	}
 
-	// Convert progress to string
-	progressString := fmt.Sprint(progress)
-	progressString += "%"
+	return sign + digits
+}
 
-	progressStringSplit := []rune(progressString)
-	progressBarSplit := []rune(progressBarString)
+// GetExistingParentDirectory returns path if it exists, otherwise the closest
+// parent directory of path which does exist.
+func GetExistingParentDirectory(path string) (string, error) {
+	path, err := filepath.Abs(path)
 
-	// Add percentage text in the middle of the progress bar
-	for i := (len(progressBarSplit) - len(progressString)) / 2; i < (len(progressBarSplit)+len(progressString))/2; i++ {
-		j := i - (len(progressBarSplit)-len(progressString))/2
-		progressBarSplit[i] = progressStringSplit[j]
+	if err != nil {
+		return "", err
 	}
 
-	progressBarString = "|" + string(progressBarSplit) + "|"
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+
+		parent := filepath.Dir(path)
+
+		if parent == path {
+			return "", fmt.Errorf("no existing parent directory for '%s'", path)
+		}
+
+		path = parent
+	}
+}
+
+// CloseOnCancel closes c as soon as ctx is cancelled, which unblocks any
+// pending reads, writes or calls to Accept. The returned function must be
+// called once c is no longer in use.
+func CloseOnCancel(ctx context.Context, c io.Closer) (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+// WithOptionalTimeout is like context.WithTimeout, but a timeout of 0 means
+// the context never times out.
+func WithOptionalTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// passwordAlphabet leaves out characters which are easily confused with each
+// other, such as 'l' and '1', so generated passwords are easy to read out.
+const passwordAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// GeneratePassword returns a random password made up of groups of
+// characters separated by dashes, e.g. "k7qx2-m9xdp-...".
+func GeneratePassword(groups int, groupLength int) (string, error) {
+	var password strings.Builder
+	max := big.NewInt(int64(len(passwordAlphabet)))
+
+	for i := 0; i < groups; i++ {
+		if i > 0 {
+			password.WriteByte('-')
+		}
+
+		for j := 0; j < groupLength; j++ {
+			n, err := rand.Int(rand.Reader, max)
+
+			if err != nil {
+				return "", fmt.Errorf("failed to generate random number: %s", err)
+			}
+
+			password.WriteByte(passwordAlphabet[n.Int64()])
+		}
+	}
 
-	return progressBarString
+	return password.String(), nil
 }
@@ -0,0 +1,79 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		text  string
+		size  int64
+		valid bool
+	}{
+		{"0", 0, true},
+		{"1024", 1024, true},
+		{"1K", 1 << 10, true},
+		{"1.5k", 1536, true},
+		{"500MiB", 500 << 20, true},
+		{" 10 GB ", 10 << 30, true},
+		{"7E", 7 << 60, true},
+		{"8E", 0, false},
+		{"8EiB", 0, false},
+		{"9223372036854775807", 0, false},
+		{"9223372036854774784", 9223372036854774784, true},
+		{"1e30", 0, false},
+		{"-1", 0, false},
+		{"-1K", 0, false},
+		{"NaN", 0, false},
+		{"Inf", 0, false},
+		{"-Inf", 0, false},
+		{"", 0, false},
+		{"K", 0, false},
+		{"10X", 0, false},
+	}
+
+	for _, test := range tests {
+		size, err := ParseByteSize(test.text)
+
+		if (err == nil) != test.valid {
+			t.Errorf("%q: got error %v, want valid to be %t", test.text, err, test.valid)
+		} else if size != test.size {
+			t.Errorf("%q: got %d, want %d", test.text, size, test.size)
+		}
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	tests := []struct {
+		list     []string
+		networks []string
+		valid    bool
+	}{
+		{[]string{"10.20.0.0/16", " 192.168.1.42 ", ""}, []string{"10.20.0.0/16", "192.168.1.42/32"}, true},
+		{[]string{"10.20.1.2/16"}, []string{"10.20.0.0/16"}, true},
+		{[]string{"fd00::1", "[fe80::1]"}, []string{"fd00::1/128", "fe80::1/128"}, true},
+		{[]string{"::ffff:10.0.0.1"}, []string{"10.0.0.1/32"}, true},
+		{[]string{"10.20.0.0/33"}, nil, false},
+		{[]string{"example.com"}, nil, false},
+	}
+
+	for _, test := range tests {
+		networks, err := ParseNetworks(test.list)
+
+		if (err == nil) != test.valid {
+			t.Errorf("%q: got error %v, want valid to be %t", test.list, err, test.valid)
+			continue
+		}
+
+		var got []string
+
+		for _, network := range networks {
+			got = append(got, network.String())
+		}
+
+		if strings.Join(got, ",") != strings.Join(test.networks, ",") {
+			t.Errorf("%q: got %q, want %q", test.list, got, test.networks)
+		}
+	}
+}
@@ -1,7 +1,41 @@
 package values
 
+import "time"
+
+const (
+	APP_NAME           = "Hoist"
+	APP_VERSION        = "1.3.0"
+	PASSWORD_ENV_VAR   = "HOIST_PASSWORD"
+	CONFIG_DIR_ENV_VAR = "HOIST_CONFIG_DIR"
+	PROTOCOL_MAGIC     = "HOIST/"
+)
+
+// PROTOCOL_VERSION must be increased whenever a change is made to the
+// protocol which older versions of hoist won't understand.
+const PROTOCOL_VERSION byte = 10
+
+// Limits on the length fields sent by the server, so a malicious server
+// can't make the client allocate huge amounts of memory, and by the client.
+const (
+	MAX_MANIFEST_LENGTH         = 128 << 20
+	MAX_FILENAME_LENGTH         = 4096
+	MAX_SYMLINK_METADATA_LENGTH = 16 << 10
+	MAX_REQUEST_LENGTH          = 64 << 10
+)
+
 const (
-	APP_NAME            = "Hoist"
-	APP_VERSION         = "1.3.0"
-	MAX_PASSWORD_LENGTH = 32
+	DEFAULT_HANDSHAKE_TIMEOUT  = 30 * time.Second
+	DEFAULT_IDLE_TIMEOUT       = 2 * time.Minute
+	DEFAULT_MAX_AUTH_FAILURES  = 10
+	DEFAULT_AUTH_BACKOFF       = time.Second
+	MAX_AUTH_BACKOFF           = 5 * time.Minute
+	DEFAULT_AUTH_LOCKOUT       = 15 * time.Minute
+	MAX_AUTH_RECORDS           = 10000
+	MAX_DERIVATIONS_PER_CLIENT = 2
+	DEFAULT_APPROVAL_TIMEOUT   = time.Minute
+	// Connections refused before the handshake are sent the reason, which
+	// may take at most REJECTION_TIMEOUT, for up to MAX_PENDING_REJECTIONS
+	// connections at once. Any more are just closed
+	REJECTION_TIMEOUT      = 5 * time.Second
+	MAX_PENDING_REJECTIONS = 64
 )